@@ -23,25 +23,25 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"os"
-	"time"
 
 	flag "github.com/spf13/pflag"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 
 	"github.com/mojaves/dnsmasqmgr/pkg/client"
-	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
 )
 
 var (
-	certFile = flag.String("certfile", "", "The TLS cert file")
-	keyFile  = flag.String("keyfile", "", "The TLS key file")
-	timeout  = flag.Int("timeout", 1, "The connection timeout (seconds)")
-	iface    = flag.String("interface", "127.0.0.1", "The server listening interface")
-	port     = flag.Int("port", 50777, "The server port")
+	useTLS     = flag.Bool("tls", false, "Connect using TLS, verifying the server with the system CAs")
+	caCertFile = flag.String("cacert", "", "The CA cert file to verify the server with (implies --tls)")
+	serverName = flag.String("server-name", "", "The name to verify the server cert against, instead of the interface (implies --tls)")
+	certFile   = flag.String("certfile", "", "The client TLS cert file, for mutual TLS (implies --tls)")
+	keyFile    = flag.String("keyfile", "", "The client TLS key file, for mutual TLS")
+	tokenFile  = flag.String("token-file", "", "The file holding the bearer token identifying the client (requires TLS)")
+	timeout    = flag.Int("timeout", 1, "The call timeout (seconds)")
+	retries    = flag.Int("retries", 0, "How many times a call is retried if the server is unavailable (changes are never retried)")
+	iface      = flag.String("interface", "127.0.0.1", "The server listening interface")
+	port       = flag.Int("port", 50777, "The server port")
 )
 
 func main() {
@@ -60,33 +60,23 @@ func main() {
 		os.Exit(2)
 	}
 
-	var opts []grpc.DialOption
-	if *certFile != "" && *keyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(*certFile, *keyFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to generate credentials %v\n", err)
-			os.Exit(8)
-		}
-		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
-	} else {
-		opts = []grpc.DialOption{grpc.WithInsecure()}
+	conf := client.Config{
+		TLS:        *useTLS,
+		CACertFile: *caCertFile,
+		ServerName: *serverName,
+		CertFile:   *certFile,
+		KeyFile:    *keyFile,
+		TokenFile:  *tokenFile,
+		Timeout:    *timeout,
+		Retries:    *retries,
+		Iface:      *iface,
+		Port:       *port,
 	}
-
-	address := fmt.Sprintf("%s:%d", *iface, *port)
-	conn, err := grpc.Dial(address, opts...)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not connect: %v\n", err)
-		os.Exit(16)
-	}
-	defer conn.Close()
-	c := pb.NewDNSMasqManagerClient(conn)
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*timeout)*time.Second)
-	defer cancel()
-
-	out, _, err := query.RunWith(ctx, c)
+	out, _, err := client.RunQuery(&conf, query)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error performing: %s: %v\n", query, err)
 	}
-	fmt.Printf("%s\n", out)
+	if out != "" {
+		fmt.Printf("%s\n", out)
+	}
 }
@@ -23,17 +23,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	flag "github.com/spf13/pflag"
 	"google.golang.org/grpc"
 
 	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
 	"github.com/mojaves/dnsmasqmgr/pkg/server"
 	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
 )
@@ -43,13 +46,20 @@ var (
 	iface    = flag.String("interface", config.DefaultIface, "The server listening interface")
 	port     = flag.Int("port", config.DefaultPort, "The server port")
 	makeConf = flag.Bool("makeconf", false, "Create template configuration and exit")
+	recovery = flag.Bool("recover", false, "Rebuild the managed files from the snapshots and the journal before serving")
+	until    = flag.String("until", "", "restore: the revision or RFC3339 time to bring the managed files back to")
+	fix      = flag.String("fix", "", "check: fix the problems found, keeping the first or the last of the clashing lines")
+	dropOut  = flag.Bool("drop-out-of-range", false, "check: let the fix drop the lines out of all the pools too")
 )
 
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: %s [options] [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s [options] restore --until <revision|time> [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s [options] check [--fix[=first|last] [--drop-out-of-range]] [config.json]\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
+	flag.Lookup("fix").NoOptDefVal = "first"
 	flag.Parse()
 
 	var err error
@@ -62,6 +72,11 @@ func main() {
 	}
 
 	args := flag.Args()
+	restore := len(args) >= 1 && args[0] == "restore"
+	check := len(args) >= 1 && args[0] == "check"
+	if restore || check {
+		args = args[1:]
+	}
 	if len(args) >= 1 {
 		conf, err = config.ParseFile(args[0])
 		if err != nil {
@@ -75,6 +90,36 @@ func main() {
 	}
 	log.Printf("dnsmasqmgrd: using configuration files: hosts=[%v] leases=[%v]", conf.HostsPath, conf.LeasesPath)
 
+	if restore {
+		if *until == "" {
+			log.Fatalf("restore: missing --until")
+		}
+		point, err := journal.ParsePoint(*until)
+		if err != nil {
+			log.Fatalf("restore: %v: %s", err, *until)
+		}
+		rev, err := server.Restore(conf, point)
+		if err != nil {
+			log.Fatalf("restore failed: %v", err)
+		}
+		log.Printf("dnsmasqmgrd: restored %v as revision %d", point, rev)
+		os.Exit(0)
+	}
+
+	if check {
+		os.Exit(runCheck(conf, *fix, *dropOut))
+	}
+
+	if *recovery {
+		if *readOnly {
+			log.Fatalf("cannot recover in read-only mode")
+		}
+		err = server.Recover(conf)
+		if err != nil {
+			log.Fatalf("recovery failed: %v", err)
+		}
+	}
+
 	opts, err := conf.SetupTLS()
 	if err != nil {
 		log.Fatalf("Failed to generate credentials %v", err)
@@ -87,9 +132,9 @@ func main() {
 
 	var mgr *server.DNSMasqMgr
 	if *readOnly {
-		mgr, err = server.NewDNSMasqMgrReadOnly(conf.IPRange, conf.HostsPath, conf.LeasesPath)
+		mgr, err = server.NewDNSMasqMgrReadOnly(conf)
 	} else {
-		mgr, err = server.NewDNSMasqMgr(conf.IPRange, conf.HostsPath, conf.LeasesPath, conf.JournalPath)
+		mgr, err = server.NewDNSMasqMgr(conf)
 	}
 	if err != nil {
 		log.Fatalf("%v", err)
@@ -97,7 +142,81 @@ func main() {
 
 	log.Printf("dnsmasqmgrd: ready ===")
 
+	opts = append(opts, mgr.ServerOptions()...)
 	serv := grpc.NewServer(opts...)
 	pb.RegisterDNSMasqManagerServer(serv, mgr)
 	serv.Serve(lis)
 }
+
+// runCheck reports the problems found in the managed files, after fixing them if fix is not empty.
+// The lines out of range are dropped only if dropOut is set. It returns the exit status, 1 if any problem is left.
+func runCheck(conf *config.Config, fix string, dropOut bool) int {
+	var mgr *server.DNSMasqMgr
+	var err error
+	// the policy is about the remote callers, not the administrator running the check on the host
+	conf.PolicyPath = ""
+	if fix == "" {
+		mgr, err = server.NewDNSMasqMgrReadOnly(conf)
+	} else {
+		mgr, err = server.NewDNSMasqMgr(conf)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer mgr.Close()
+
+	req := pb.CheckRequest{}
+	if fix != "" {
+		res, ok := pb.Resolution_value["KEEP_"+strings.ToUpper(fix)]
+		if !ok {
+			log.Fatalf("check: unknown fix: %s", fix)
+		}
+		req.Fix = pb.Resolution(res)
+		if dropOut {
+			// the lines out of range are dropped only if asked for explicitly
+			for p := range pb.Problem_name {
+				req.Problems = append(req.Problems, pb.Problem(p))
+			}
+		}
+		reply, err := mgr.Check(context.Background(), &req)
+		if err != nil {
+			log.Fatalf("check failed: %v", err)
+		}
+		printFindings(reply.Findings)
+		fixed := 0
+		for _, f := range reply.Findings {
+			if len(f.Dropped) > 0 {
+				fixed++
+			}
+		}
+		log.Printf("dnsmasqmgrd: fixed %d problems", fixed)
+		// dropping lines may leave new orphans
+		req.Fix = pb.Resolution_REPORT
+		req.Problems = nil
+	}
+	reply, err := mgr.Check(context.Background(), &req)
+	if err != nil {
+		log.Fatalf("check failed: %v", err)
+	}
+	printFindings(reply.Findings)
+	if len(reply.Findings) > 0 {
+		log.Printf("dnsmasqmgrd: found %d problems", len(reply.Findings))
+		return 1
+	}
+	return 0
+}
+
+func printFindings(findings []*pb.Finding) {
+	for _, f := range findings {
+		fmt.Printf("%s (%s) %s\n", f.Problem, f.Error, f.Subject)
+		for _, l := range f.Hosts {
+			fmt.Printf("\thosts:   %s\n", l)
+		}
+		for _, l := range f.Leases {
+			fmt.Printf("\tleases:  %s\n", l)
+		}
+		for _, l := range f.Dropped {
+			fmt.Printf("\tdropped: %s\n", l)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package main implements the service which makes dnsmasq reload the files managed by DNSMasqMgr.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/reloader"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+)
+
+var (
+	pidFile  = flag.String("pidfile", reloader.DefaultPidFile, "The dnsmasq pid file")
+	interval = flag.Duration("interval", reloader.DefaultInterval, "How often the files are checked for changes")
+	debounce = flag.Duration("debounce", reloader.DefaultDebounce, "How long the files must be unchanged before reloading")
+	cooldown = flag.Duration("cooldown", reloader.DefaultCooldown, "Minimum time between two reloads")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] config.json\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	conf, err := config.ParseFile(args[0])
+	if err != nil {
+		log.Fatalf("error parsing the configuration %s: %v", args[0], err)
+	}
+	err = conf.Check()
+	if err != nil {
+		log.Fatalf("configuration error: %v", err)
+	}
+
+	r := reloader.New(*pidFile, []string{conf.HostsPath, conf.LeasesPath})
+	r.Interval = *interval
+	r.Debounce = *debounce
+	r.Cooldown = *cooldown
+
+	log.Printf("dnsmasqreloadd: watching hosts=[%v] leases=[%v] pidfile=[%v]", conf.HostsPath, conf.LeasesPath, *pidFile)
+	log.Printf("dnsmasqreloadd: interval=%v debounce=%v cooldown=%v", r.Interval, r.Debounce, r.Cooldown)
+
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("dnsmasqreloadd: got %v, exiting", sig)
+		close(stop)
+	}()
+
+	log.Printf("dnsmasqreloadd: ready ===")
+	r.Run(stop)
+}
@@ -0,0 +1,602 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+)
+
+var (
+	ErrNotFound     error = errors.New("not found")
+	ErrDuplicate    error = errors.New("already exists")
+	ErrMismatch     error = errors.New("entry does not match")
+	ErrInvalid      error = errors.New("invalid request")
+	ErrPrecondition error = errors.New("precondition failed")
+	ErrExhausted    error = errors.New("pool exhausted")
+	ErrUnavailable  error = errors.New("server unavailable")
+	ErrDenied       error = errors.New("permission denied")
+	ErrUnauthorized error = errors.New("not authenticated")
+	ErrUnknownKey   error = errors.New("unknown key")
+	ErrOutOfRange   error = errors.New("revision out of range")
+	ErrConflict     error = errors.New("conflict with external edits")
+	ErrLagging      error = errors.New("watcher lagging behind")
+)
+
+// Error is an error returned by the server. Use errors.Is with the Err* values to tell the kind.
+type Error struct {
+	// Kind is one of the Err* values, nil if the server error is not classified
+	Kind    error
+	Code    codes.Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+var errorKinds = map[codes.Code]error{
+	codes.NotFound:           ErrNotFound,
+	codes.AlreadyExists:      ErrDuplicate,
+	codes.Aborted:            ErrMismatch,
+	codes.InvalidArgument:    ErrInvalid,
+	codes.FailedPrecondition: ErrPrecondition,
+	codes.ResourceExhausted:  ErrExhausted,
+	codes.Unavailable:        ErrUnavailable,
+	codes.DeadlineExceeded:   ErrUnavailable,
+	codes.PermissionDenied:   ErrDenied,
+	codes.Unauthenticated:    ErrUnauthorized,
+	codes.OutOfRange:         ErrOutOfRange,
+}
+
+// detailKinds tells apart the errors sharing a code, by the detail the server attaches to them
+var detailKinds = map[pb.Error]error{
+	pb.Error_MISMATCH: ErrMismatch,
+	pb.Error_CONFLICT: ErrConflict,
+	pb.Error_LAGGING:  ErrLagging,
+}
+
+// fromStatus converts a gRPC error to an Error
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	kind := errorKinds[st.Code()]
+	for _, d := range st.Details() {
+		if ed, ok := d.(*pb.ErrorDetail); ok && detailKinds[ed.Error] != nil {
+			kind = detailKinds[ed.Error]
+		}
+	}
+	return &Error{
+		Kind:    kind,
+		Code:    st.Code(),
+		Message: st.Message(),
+	}
+}
+
+// Key tells how an entry is looked up
+type Key int
+
+const (
+	KeyHostname Key = iota
+	KeyMacaddr
+	KeyIpaddr
+	KeyAlias
+	KeyClientID
+)
+
+var keyNames = map[string]Key{
+	"name":  KeyHostname,
+	"mac":   KeyMacaddr,
+	"ip":    KeyIpaddr,
+	"alias": KeyAlias,
+	"id":    KeyClientID,
+}
+
+// ParseKey converts the name of a Key ("name", "mac", "ip", "alias", "id") to its value
+func ParseKey(s string) (Key, error) {
+	k, ok := keyNames[s]
+	if !ok {
+		return KeyHostname, fmt.Errorf("%w: %s", ErrUnknownKey, s)
+	}
+	return k, nil
+}
+
+// address returns the pb.Key and the pb.Address selecting the entry with the given value
+func (k Key) address(value string) (pb.Key, *pb.Address) {
+	switch k {
+	case KeyMacaddr:
+		return pb.Key_MACADDR, &pb.Address{Macaddr: value}
+	case KeyClientID:
+		return pb.Key_MACADDR, &pb.Address{Clientid: value}
+	case KeyIpaddr:
+		addr := &pb.Address{}
+		if strings.Contains(value, ":") {
+			addr.Ip6Addr = value
+		} else {
+			addr.Ipaddr = value
+		}
+		return pb.Key_IPADDR, addr
+	case KeyAlias:
+		return pb.Key_ALIAS, &pb.Address{Aliases: []string{value}}
+	}
+	return pb.Key_HOSTNAME, &pb.Address{Hostname: value}
+}
+
+// Options tune the connection and the calls of a Client
+type Options struct {
+	// Address is the host:port of the server
+	Address string
+	// CACertFile holds the CA certificates (PEM) the server certificate is verified against.
+	// The system CAs are used if empty.
+	CACertFile string
+	// ServerName overrides the name the server certificate is verified against, which defaults to the host in Address
+	ServerName string
+	// CertFile and KeyFile are the client certificate and key, for the servers requiring mutual TLS
+	CertFile string
+	KeyFile  string
+	// TLS enables TLS, verifying the server with the system CAs. It is implied by the TLS fields above.
+	TLS bool
+	// Token is the bearer token identifying the client to the authorization policy of the server.
+	// It is sent over TLS only.
+	Token string
+	// Timeout bounds every attempt of every call, except Watch. No limit if zero.
+	Timeout time.Duration
+	// Retries is how many times a call is retried when the server is unavailable.
+	// The calls changing the entries are never retried.
+	Retries int
+	// Backoff is the delay before the first retry, doubled at every retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DialOptions are appended to the ones built from the fields above
+	DialOptions []grpc.DialOption
+}
+
+const (
+	DefaultAddress    string        = "127.0.0.1:50777"
+	DefaultBackoff    time.Duration = 100 * time.Millisecond
+	DefaultMaxBackoff time.Duration = 5 * time.Second
+)
+
+func (opts Options) useTLS() bool {
+	return opts.TLS || opts.CACertFile != "" || opts.ServerName != "" || opts.CertFile != ""
+}
+
+func (opts Options) transportCredentials() (credentials.TransportCredentials, error) {
+	tlsConf := &tls.Config{
+		ServerName: opts.ServerName,
+	}
+	if opts.CACertFile != "" {
+		data, err := ioutil.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACertFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConf), nil
+}
+
+// bearerToken sends the token as "authorization: Bearer <token>" metadata
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+// Client talks to a dnsmasqmgrd server over a single connection, safe for concurrent use
+type Client struct {
+	opts Options
+	conn *grpc.ClientConn
+	rpc  pb.DNSMasqManagerClient
+}
+
+// Dial connects to the server. The connection is established in background:
+// an unreachable server is reported by the calls.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Address == "" {
+		opts.Address = DefaultAddress
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	var dialOpts []grpc.DialOption
+	if opts.useTLS() {
+		creds, err := opts.transportCredentials()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate credentials %v", err)
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+	if opts.Token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(bearerToken(opts.Token)))
+	}
+	conn, err := grpc.DialContext(ctx, opts.Address, append(dialOpts, opts.DialOptions...)...)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect: %v", err)
+	}
+	return &Client{
+		opts: opts,
+		conn: conn,
+		rpc:  pb.NewDNSMasqManagerClient(conn),
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// call runs fn with the per-attempt timeout, retrying with backoff while the server is unavailable.
+// Use it only for the calls changing nothing, see callOnce.
+func (c *Client) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, fn)
+		if err == nil || status.Code(err) != codes.Unavailable || attempt >= c.opts.Retries {
+			return fromStatus(err)
+		}
+		select {
+		case <-ctx.Done():
+			return fromStatus(err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > c.opts.MaxBackoff {
+			delay = c.opts.MaxBackoff
+		}
+	}
+}
+
+// callOnce runs fn with the per-attempt timeout, once. The calls changing the managed files are not retried:
+// the server may have applied the change before becoming unavailable, and the retry would apply it again.
+func (c *Client) callOnce(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fromStatus(c.attempt(ctx, fn))
+}
+
+func (c *Client) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.opts.Timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// Lookup returns the entry selected by key and value
+func (c *Client) Lookup(ctx context.Context, key Key, value string) (*Entry, error) {
+	k, addr := key.address(value)
+	var r *pb.AddressReply
+	err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		r, err = c.rpc.LookupAddress(ctx, &pb.AddressRequest{Key: k, Addr: addr})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toEntry(r), nil
+}
+
+// Request adds a new entry. The addresses left empty are taken from the pool,
+// which is chosen by the server if empty.
+func (c *Client) Request(ctx context.Context, addr Address, pool string) (*Address, error) {
+	return c.addressCall(ctx, func(ctx context.Context) (*pb.AddressReply, error) {
+		return c.rpc.RequestAddress(ctx, &pb.AddressRequest{Addr: fromAddress(addr), Pool: pool})
+	})
+}
+
+// Pin turns the active lease selected by key and value into a static entry named hostname, or the name
+// the client sent to dnsmasq if empty. The leased address is kept if it belongs to pool, or to any pool if empty.
+func (c *Client) Pin(ctx context.Context, key Key, value, hostname, pool string) (*Address, error) {
+	k, addr := key.address(value)
+	return c.addressCall(ctx, func(ctx context.Context) (*pb.AddressReply, error) {
+		return c.rpc.PinLease(ctx, &pb.PinRequest{Key: k, Addr: addr, Hostname: hostname, Pool: pool})
+	})
+}
+
+// Delete removes the entry selected by key and value
+func (c *Client) Delete(ctx context.Context, key Key, value string) (*Address, error) {
+	k, addr := key.address(value)
+	return c.addressCall(ctx, func(ctx context.Context) (*pb.AddressReply, error) {
+		return c.rpc.DeleteAddress(ctx, &pb.AddressRequest{Key: k, Addr: addr})
+	})
+}
+
+// Update changes the entry selected by key and value. The non-empty fields of current must match
+// the entry, otherwise ErrMismatch is returned; the non-empty fields of updated replace the ones of the entry.
+func (c *Client) Update(ctx context.Context, key Key, value string, current, updated Address) (*Address, error) {
+	k, cur := key.address(value)
+	overlay(cur, fromAddress(current))
+	return c.addressCall(ctx, func(ctx context.Context) (*pb.AddressReply, error) {
+		return c.rpc.UpdateAddress(ctx, &pb.UpdateRequest{Key: k, Current: cur, Updated: fromAddress(updated)})
+	})
+}
+
+// AddAliases adds the aliases to the entry selected by key and value
+func (c *Client) AddAliases(ctx context.Context, key Key, value string, aliases ...string) (*Address, error) {
+	k, addr := key.address(value)
+	return c.addressCall(ctx, func(ctx context.Context) (*pb.AddressReply, error) {
+		return c.rpc.AddAlias(ctx, &pb.AliasRequest{Key: k, Addr: addr, Aliases: aliases})
+	})
+}
+
+// RemoveAliases removes the aliases from the entry selected by key and value
+func (c *Client) RemoveAliases(ctx context.Context, key Key, value string, aliases ...string) (*Address, error) {
+	k, addr := key.address(value)
+	return c.addressCall(ctx, func(ctx context.Context) (*pb.AddressReply, error) {
+		return c.rpc.RemoveAlias(ctx, &pb.AliasRequest{Key: k, Addr: addr, Aliases: aliases})
+	})
+}
+
+// overlay copies on dst the non-empty fields of src
+func overlay(dst, src *pb.Address) {
+	if src.Hostname != "" {
+		dst.Hostname = src.Hostname
+	}
+	if src.Macaddr != "" {
+		dst.Macaddr = src.Macaddr
+	}
+	if src.Ipaddr != "" {
+		dst.Ipaddr = src.Ipaddr
+	}
+	if src.Ip6Addr != "" {
+		dst.Ip6Addr = src.Ip6Addr
+	}
+	if src.Clientid != "" {
+		dst.Clientid = src.Clientid
+	}
+}
+
+func (c *Client) addressCall(ctx context.Context, fn func(ctx context.Context) (*pb.AddressReply, error)) (*Address, error) {
+	var r *pb.AddressReply
+	err := c.callOnce(ctx, func(ctx context.Context) error {
+		var err error
+		r, err = fn(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	addr := toAddress(r.Addr)
+	return &addr, nil
+}
+
+// ListFilter selects the entries returned by List. All the criteria are optional and combined in AND.
+type ListFilter struct {
+	HostnameGlob  string
+	MacaddrPrefix string
+	Subnet        string
+	// Match is "full", "partial" or empty for any
+	Match string
+}
+
+// List returns all the entries matching the filter, sorted by address
+func (c *Client) List(ctx context.Context, filter ListFilter) ([]Entry, error) {
+	req := pb.ListRequest{
+		HostnameGlob:  filter.HostnameGlob,
+		MacaddrPrefix: filter.MacaddrPrefix,
+		Subnet:        filter.Subnet,
+	}
+	if filter.Match != "" {
+		match, ok := pb.Match_value[strings.ToUpper(filter.Match)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported match: %s", ErrInvalid, filter.Match)
+		}
+		req.Match = pb.Match(match)
+	}
+	var ret []Entry
+	for {
+		var r *pb.ListReply
+		err := c.call(ctx, func(ctx context.Context) error {
+			var err error
+			r, err = c.rpc.ListAddresses(ctx, &req)
+			return err
+		})
+		if err != nil {
+			return ret, err
+		}
+		for _, ar := range r.Addrs {
+			ret = append(ret, *toEntry(ar))
+		}
+		if r.NextPageToken == "" {
+			return ret, nil
+		}
+		req.PageToken = r.NextPageToken
+	}
+}
+
+// Status returns the state of the server and the utilisation of its pools
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var r *pb.StatusReply
+	err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		r, err = c.rpc.GetStatus(ctx, &pb.StatusRequest{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	st := Status{
+		ReadOnly: r.Readonly,
+		Alert:    r.Alert,
+	}
+	for _, p := range r.Pools {
+		st.Pools = append(st.Pools, Pool{
+			Name:       p.Name,
+			Range:      p.Range,
+			Total:      p.Total,
+			Remaining:  p.Remaining,
+			Subnet:     p.Subnet,
+			Domain:     p.Domain,
+			Range6:     p.Range6,
+			Total6:     p.Total6,
+			Remaining6: p.Remaining6,
+		})
+	}
+	return &st, nil
+}
+
+// Leases returns the leases dnsmasq handed out, each one next to the static entry of the same client, if any,
+// followed by the static entries without a lease. The expired leases are included if expired is true.
+func (c *Client) Leases(ctx context.Context, expired bool) ([]LeaseEntry, error) {
+	var r *pb.LeasesReply
+	err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		r, err = c.rpc.ListLeases(ctx, &pb.LeasesRequest{Expired: expired})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ret []LeaseEntry
+	for _, e := range r.Entries {
+		entry := LeaseEntry{}
+		if e.Lease != nil {
+			entry.Lease = toLease(e.Lease)
+		}
+		if e.Static != nil {
+			addr := toAddress(e.Static)
+			entry.Static = &addr
+		}
+		ret = append(ret, entry)
+	}
+	return ret, nil
+}
+
+// Check returns the inconsistencies between the managed files, among problems or all of them if none.
+// The problems are "orphan-binding", "orphan-host", "out-of-range", "shared-address" and "name-conflict".
+// If fix is "first" or "last", the server also fixes them, keeping the first or the last of the clashing lines.
+func (c *Client) Check(ctx context.Context, fix string, problems ...string) ([]Finding, error) {
+	req := pb.CheckRequest{}
+	if fix != "" {
+		res, ok := pb.Resolution_value["KEEP_"+strings.ToUpper(fix)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown fix: %s", ErrInvalid, fix)
+		}
+		req.Fix = pb.Resolution(res)
+	}
+	for _, name := range problems {
+		p, ok := pb.Problem_value[strings.ToUpper(strings.Replace(name, "-", "_", -1))]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown problem: %s", ErrInvalid, name)
+		}
+		req.Problems = append(req.Problems, pb.Problem(p))
+	}
+	var r *pb.CheckReply
+	call := c.call
+	if req.Fix != pb.Resolution_REPORT {
+		call = c.callOnce
+	}
+	err := call(ctx, func(ctx context.Context) error {
+		var err error
+		r, err = c.rpc.Check(ctx, &req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ret []Finding
+	for _, f := range r.Findings {
+		ret = append(ret, Finding{
+			Problem: strings.ToLower(strings.Replace(f.Problem.String(), "_", "-", -1)),
+			Error:   strings.ToLower(f.Error.String()),
+			Subject: f.Subject,
+			Hosts:   f.Hosts,
+			Leases:  f.Leases,
+			Dropped: f.Dropped,
+		})
+	}
+	return ret, nil
+}
+
+// Watch calls fn with every change past the given revision, until ctx is done,
+// the server goes away or fn returns an error. It is neither timed out nor retried.
+// The server keeps only the last changes since it started, so resuming from an older revision
+// fails with ErrOutOfRange: the caller must list the addresses again and watch from now on.
+func (c *Client) Watch(ctx context.Context, fromRevision int64, fn func(Event) error) error {
+	stream, err := c.rpc.Watch(ctx, &pb.WatchRequest{FromRevision: fromRevision})
+	if err != nil {
+		return fromStatus(err)
+	}
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		out := Event{
+			Revision: ev.Revision,
+			Action:   strings.ToLower(ev.Action.String()),
+			Time:     time.Unix(ev.Timestamp, 0).Format(time.RFC3339),
+			Address:  toAddress(ev.Addr),
+		}
+		if ev.Previous != nil {
+			prev := toAddress(ev.Previous)
+			out.Previous = &prev
+		}
+		if err = fn(out); err != nil {
+			return err
+		}
+	}
+}
@@ -19,177 +19,680 @@
  * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
  */
 
-// the package dhcphosts provides utilities to work with configuration in the dhcphosts
-// (see man 8 dnsmasq) format
+// the package client provides a typed library to talk to dnsmasqmgrd, and the queries
+// run by the command line client on top of it
 package client
 
 import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-
 	flag "github.com/spf13/pflag"
 
 	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
 )
 
 type Address struct {
-	Name string `json:"name"`
-	Mac  string `json:"mac"`
-	IP   string `json:"ip"`
+	Name     string   `json:"name"`
+	Mac      string   `json:"mac"`
+	IP       string   `json:"ip"`
+	Aliases  []string `json:"aliases,omitempty"`
+	IP6      string   `json:"ip6,omitempty"`
+	ClientID string   `json:"clientid,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
 }
 
-func addrToJson(a *pb.Address) string {
-	b, err := json.Marshal(Address{
-		Name: a.Hostname,
-		Mac:  a.Macaddr,
-		IP:   a.Ipaddr,
-	})
-	if err != nil {
-		return ""
+// Entry is an Address as found by the server, with how well its files agree about it
+type Entry struct {
+	Address
+	Match string `json:"match"`
+}
+
+type Event struct {
+	Revision int64    `json:"revision"`
+	Action   string   `json:"action"`
+	Time     string   `json:"time"`
+	Address  Address  `json:"address"`
+	Previous *Address `json:"previous,omitempty"`
+}
+
+type Lease struct {
+	// Expiry is a RFC3339 time, or "never"
+	Expiry    string `json:"expiry"`
+	Mac       string `json:"mac,omitempty"`
+	IP        string `json:"ip"`
+	Name      string `json:"name,omitempty"`
+	ClientID  string `json:"clientid,omitempty"`
+	IAID      uint32 `json:"iaid,omitempty"`
+	Temporary bool   `json:"temporary,omitempty"`
+}
+
+// LeaseEntry is a lease dnsmasq handed out next to the static entry of the same client. Either may be nil.
+type LeaseEntry struct {
+	Lease  *Lease   `json:"lease,omitempty"`
+	Static *Address `json:"static,omitempty"`
+}
+
+type Pool struct {
+	Name       string `json:"name"`
+	Range      string `json:"range"`
+	Total      int64  `json:"total"`
+	Remaining  int64  `json:"remaining"`
+	Subnet     string `json:"subnet,omitempty"`
+	Domain     string `json:"domain,omitempty"`
+	Range6     string `json:"range6,omitempty"`
+	Total6     int64  `json:"total6,omitempty"`
+	Remaining6 int64  `json:"remaining6,omitempty"`
+}
+
+type Status struct {
+	ReadOnly bool   `json:"readonly"`
+	Pools    []Pool `json:"pools"`
+	// Alert is set while the server refuses to change the managed files
+	Alert string `json:"alert,omitempty"`
+}
+
+// Finding is an inconsistency between the managed files found by Check
+type Finding struct {
+	// Problem is one of the names Check takes, e.g. "orphan-binding"
+	Problem string `json:"problem"`
+	// Error is "mismatch" if the hosts and the dhcp-host lines disagree, "duplicate" if lines of the same file clash
+	Error   string   `json:"error"`
+	Subject string   `json:"subject"`
+	Hosts   []string `json:"hosts,omitempty"`
+	Leases  []string `json:"leases,omitempty"`
+	// Dropped are the lines the fix removed
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+func toAddress(a *pb.Address) Address {
+	if a == nil {
+		return Address{}
+	}
+	return Address{
+		Name:     a.Hostname,
+		Mac:      a.Macaddr,
+		IP:       a.Ipaddr,
+		Aliases:  a.Aliases,
+		IP6:      a.Ip6Addr,
+		ClientID: a.Clientid,
+		Tags:     a.Tags,
 	}
-	return string(b)
 }
 
-type Queryable interface {
-	String() string
-	SetupArgs(args []string) error
-	RunWith(ctx context.Context, c pb.DNSMasqManagerClient) (string, string, error)
+func toLease(l *pb.Lease) *Lease {
+	ret := Lease{
+		Expiry:    "never",
+		Mac:       l.Macaddr,
+		IP:        l.Ipaddr,
+		Name:      l.Hostname,
+		ClientID:  l.Clientid,
+		IAID:      l.Iaid,
+		Temporary: l.Temporary,
+	}
+	if l.Expiry != 0 {
+		ret.Expiry = time.Unix(l.Expiry, 0).Format(time.RFC3339)
+	}
+	return &ret
 }
 
-type QueryLookup struct {
-	Name string
-	req  *pb.AddressRequest
+func fromAddress(a Address) *pb.Address {
+	return &pb.Address{
+		Hostname: a.Name,
+		Macaddr:  a.Mac,
+		Ipaddr:   a.IP,
+		Aliases:  a.Aliases,
+		Ip6Addr:  a.IP6,
+		Clientid: a.ClientID,
+		Tags:     a.Tags,
+	}
 }
 
-func (ql *QueryLookup) String() string {
-	return fmt.Sprintf("%s(%s)", ql.Name, ql.req.Addr)
+func toEntry(r *pb.AddressReply) *Entry {
+	return &Entry{
+		Address: toAddress(r.Addr),
+		Match:   strings.ToLower(r.Match.String()),
+	}
+}
+
+func toJson(v interface{}) (string, string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", "", err
+	}
+	return string(b), "", nil
+}
+
+type Queryable interface {
+	String() string
+	SetupArgs(args []string) error
+	RunWith(ctx context.Context, c *Client) (string, string, error)
 }
 
-func AddressRequestFromArgs(args []string) (*pb.AddressRequest, error) {
+// keyFromArgs parses the how and the what of the queries which select an entry
+func keyFromArgs(args []string) (Key, string, error) {
 	// args:
 	// [0]       [1]  [2]
 	// <action>  how  what
 	if len(args) < 3 {
-		return nil, fmt.Errorf("not enough arguments: `%v`", args[1:])
-	}
-	req := pb.AddressRequest{}
-	switch args[1] {
-	case "name":
-		req.Key = pb.Key_HOSTNAME
-		req.Addr = &pb.Address{
-			Hostname: args[2],
-		}
-	case "mac":
-		req.Key = pb.Key_MACADDR
-		req.Addr = &pb.Address{
-			Macaddr: args[2],
-		}
-	case "ip":
-		req.Key = pb.Key_IPADDR
-		req.Addr = &pb.Address{
-			Ipaddr: args[2],
-		}
-	default:
-		return nil, fmt.Errorf("%s: unsupported method: %s", args[0], args[1])
+		return KeyHostname, "", fmt.Errorf("not enough arguments: `%v`", args[1:])
+	}
+	key, err := ParseKey(args[1])
+	if err != nil {
+		return KeyHostname, "", fmt.Errorf("%s: unsupported method: %s", args[0], args[1])
 	}
-	return &req, nil
+	return key, args[2], nil
+}
+
+type QueryLookup struct {
+	Name  string
+	key   Key
+	value string
+}
+
+func (ql *QueryLookup) String() string {
+	return fmt.Sprintf("%s(%s)", ql.Name, ql.value)
 }
 
 func (ql *QueryLookup) SetupArgs(args []string) error {
 	var err error
-	ql.req, err = AddressRequestFromArgs(args)
+	ql.key, ql.value, err = keyFromArgs(args)
 	return err
 }
 
-func (ql *QueryLookup) RunWith(ctx context.Context, c pb.DNSMasqManagerClient) (string, string, error) {
-	r, err := c.LookupAddress(ctx, ql.req)
-	return addrToJson(r.Addr), "", err
+func (ql *QueryLookup) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	e, err := c.Lookup(ctx, ql.key, ql.value)
+	if err != nil {
+		return "", "", err
+	}
+	return toJson(e.Address)
 }
 
 type QueryRequest struct {
 	Name string
-	addr *pb.Address
+	addr Address
+	pool string
 }
 
 func (qr *QueryRequest) String() string {
 	reqip := ""
-	if qr.addr.Ipaddr != "" {
-		reqip = fmt.Sprintf(", ip=%s", qr.addr.Ipaddr)
+	if qr.addr.IP != "" {
+		reqip = fmt.Sprintf(", ip=%s", qr.addr.IP)
+	}
+	if qr.addr.IP6 != "" {
+		reqip += fmt.Sprintf(", ip6=%s", qr.addr.IP6)
 	}
-	return fmt.Sprintf("%s(name=%s, mac=%s%s)", qr.Name, qr.addr.Hostname, qr.addr.Macaddr, reqip)
+	if qr.addr.ClientID != "" {
+		reqip += fmt.Sprintf(", id=%s", qr.addr.ClientID)
+	}
+	if qr.pool != "" {
+		reqip += fmt.Sprintf(", pool=%s", qr.pool)
+	}
+	if len(qr.addr.Tags) > 0 {
+		reqip += fmt.Sprintf(", tags=%s", strings.Join(qr.addr.Tags, ","))
+	}
+	aliases := ""
+	if len(qr.addr.Aliases) > 0 {
+		aliases = fmt.Sprintf(", aliases=%s", strings.Join(qr.addr.Aliases, ","))
+	}
+	return fmt.Sprintf("%s(name=%s, mac=%s%s%s)", qr.Name, qr.addr.Name, qr.addr.Mac, reqip, aliases)
 }
 
 func (qr *QueryRequest) SetupArgs(args []string) error {
 	// args:
-	// [0]     [1]  [2]  [[3]]
-	// request host mac  [ip]
+	// [0]     [1]  [2]  [[3]] [4...]
+	// request host mac  [ip]  [ip6=<ip6addr>] [id=<clientid>] [pool=<pool>] [tag=<tag>...] [alias=<alias>...]
+	// mac may be "id:<clientid>" for the clients known by DHCP client identifier only
 	if len(args) < 3 {
 		return fmt.Errorf("not enough arguments: `%v`", args[1:])
 	}
-	qr.addr = &pb.Address{
-		Hostname: args[1],
-		Macaddr:  args[2],
+	qr.addr = Address{
+		Name: args[1],
 	}
-	if len(args) >= 4 {
-		qr.addr.Ipaddr = args[3]
+	if strings.HasPrefix(args[2], "id:") {
+		qr.addr.ClientID = strings.TrimPrefix(args[2], "id:")
+	} else {
+		qr.addr.Mac = args[2]
+	}
+	for ix, arg := range args[3:] {
+		if strings.HasPrefix(arg, "alias=") {
+			qr.addr.Aliases = append(qr.addr.Aliases, strings.TrimPrefix(arg, "alias="))
+		} else if strings.HasPrefix(arg, "ip6=") {
+			qr.addr.IP6 = strings.TrimPrefix(arg, "ip6=")
+		} else if strings.HasPrefix(arg, "id=") {
+			qr.addr.ClientID = strings.TrimPrefix(arg, "id=")
+		} else if strings.HasPrefix(arg, "pool=") {
+			qr.pool = strings.TrimPrefix(arg, "pool=")
+		} else if strings.HasPrefix(arg, "tag=") {
+			qr.addr.Tags = append(qr.addr.Tags, strings.TrimPrefix(arg, "tag="))
+		} else if ix == 0 {
+			qr.addr.IP = arg
+		} else {
+			return fmt.Errorf("%s: unexpected argument: `%s`", args[0], arg)
+		}
 	}
 	return nil
 }
 
-func (qr *QueryRequest) RunWith(ctx context.Context, c pb.DNSMasqManagerClient) (string, string, error) {
-	r, err := c.RequestAddress(ctx, &pb.AddressRequest{
-		Addr: qr.addr,
-	})
-	// TODO: r.Code?
+func (qr *QueryRequest) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	addr, err := c.Request(ctx, qr.addr, qr.pool)
 	if err != nil {
 		return "", "", err
 	}
-	return addrToJson(r.Addr), "", nil
+	return toJson(addr)
 }
 
 type QueryDelete struct {
-	Name string
-	req  *pb.AddressRequest
+	Name  string
+	key   Key
+	value string
 }
 
-func (ql *QueryDelete) String() string {
-	return fmt.Sprintf("%s(%s)", ql.Name, ql.req.Addr)
+func (qd *QueryDelete) String() string {
+	return fmt.Sprintf("%s(%s)", qd.Name, qd.value)
 }
 
-func (ql *QueryDelete) SetupArgs(args []string) error {
+func (qd *QueryDelete) SetupArgs(args []string) error {
 	var err error
-	ql.req, err = AddressRequestFromArgs(args)
+	qd.key, qd.value, err = keyFromArgs(args)
 	return err
 }
 
-func (ql *QueryDelete) RunWith(ctx context.Context, c pb.DNSMasqManagerClient) (string, string, error) {
-	r, err := c.DeleteAddress(ctx, ql.req)
-	return addrToJson(r.Addr), "", err
+func (qd *QueryDelete) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	addr, err := c.Delete(ctx, qd.key, qd.value)
+	if err != nil {
+		return "", "", err
+	}
+	return toJson(addr)
+}
+
+type QueryPin struct {
+	Name     string
+	key      Key
+	value    string
+	hostname string
+	pool     string
+}
+
+func (qp *QueryPin) String() string {
+	return fmt.Sprintf("%s(%s, name=%s, pool=%s)", qp.Name, qp.value, qp.hostname, qp.pool)
+}
+
+// pinKey guesses what the value selects the lease by
+func pinKey(value string) Key {
+	if strings.HasPrefix(value, "id:") {
+		return KeyClientID
+	}
+	if _, err := net.ParseMAC(value); err == nil {
+		return KeyMacaddr
+	}
+	if net.ParseIP(value) != nil {
+		return KeyIpaddr
+	}
+	return KeyHostname
+}
+
+func (qp *QueryPin) SetupArgs(args []string) error {
+	// args:
+	// [0]  [1]                                [2...]
+	// pin  <mac>|id:<clientid>|<ip>|<hostname>  [name=<hostname>] [pool=<pool>]
+	if len(args) < 2 {
+		return fmt.Errorf("not enough arguments: `%v`", args[1:])
+	}
+	qp.key = pinKey(args[1])
+	qp.value = strings.TrimPrefix(args[1], "id:")
+	for _, arg := range args[2:] {
+		items := strings.SplitN(arg, "=", 2)
+		if len(items) != 2 {
+			return fmt.Errorf("%s: malformed value: `%s`", args[0], arg)
+		}
+		switch items[0] {
+		case "name":
+			qp.hostname = items[1]
+		case "pool":
+			qp.pool = items[1]
+		default:
+			return fmt.Errorf("%s: unsupported field: %s", args[0], items[0])
+		}
+	}
+	return nil
+}
+
+func (qp *QueryPin) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	addr, err := c.Pin(ctx, qp.key, qp.value, qp.hostname, qp.pool)
+	if err != nil {
+		return "", "", err
+	}
+	return toJson(addr)
+}
+
+type QueryAlias struct {
+	Name    string
+	key     Key
+	value   string
+	aliases []string
+}
+
+func (qa *QueryAlias) String() string {
+	return fmt.Sprintf("%s(%s, %s)", qa.Name, qa.value, strings.Join(qa.aliases, ","))
+}
+
+func (qa *QueryAlias) SetupArgs(args []string) error {
+	// args:
+	// [0]                  [1]  [2]   [3...]
+	// alias-add|alias-del  how  what  alias...
+	if len(args) < 4 {
+		return fmt.Errorf("not enough arguments: `%v`", args[1:])
+	}
+	var err error
+	qa.key, qa.value, err = keyFromArgs(args)
+	if err != nil {
+		return err
+	}
+	qa.aliases = args[3:]
+	return nil
+}
+
+func (qa *QueryAlias) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	var addr *Address
+	var err error
+	if qa.Name == "alias-del" {
+		addr, err = c.RemoveAliases(ctx, qa.key, qa.value, qa.aliases...)
+	} else {
+		addr, err = c.AddAliases(ctx, qa.key, qa.value, qa.aliases...)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return toJson(addr)
+}
+
+type QueryUpdate struct {
+	Name    string
+	key     Key
+	value   string
+	current Address
+	updated Address
+}
+
+func (qu *QueryUpdate) String() string {
+	return fmt.Sprintf("%s(%s: %+v -> %+v)", qu.Name, qu.value, qu.current, qu.updated)
+}
+
+func (qu *QueryUpdate) SetupArgs(args []string) error {
+	// args:
+	// [0]     [1]  [2]   [3...]
+	// update  how  what  [name=<hostname>] [mac=<macaddr>] [ip=<ipaddr>] [ip6=<ip6addr>] [id=<clientid>]
+	//                    [if-name=<hostname>] [if-mac=<macaddr>] [if-ip=<ipaddr>] [if-ip6=<ip6addr>] [if-id=<clientid>]
+	var err error
+	qu.key, qu.value, err = keyFromArgs(args)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args[3:] {
+		items := strings.SplitN(arg, "=", 2)
+		if len(items) != 2 {
+			return fmt.Errorf("%s: malformed value: `%s`", args[0], arg)
+		}
+		switch items[0] {
+		case "name":
+			qu.updated.Name = items[1]
+		case "mac":
+			qu.updated.Mac = items[1]
+		case "ip":
+			qu.updated.IP = items[1]
+		case "ip6":
+			qu.updated.IP6 = items[1]
+		case "id":
+			qu.updated.ClientID = items[1]
+		case "if-name":
+			qu.current.Name = items[1]
+		case "if-mac":
+			qu.current.Mac = items[1]
+		case "if-ip":
+			qu.current.IP = items[1]
+		case "if-ip6":
+			qu.current.IP6 = items[1]
+		case "if-id":
+			qu.current.ClientID = items[1]
+		default:
+			return fmt.Errorf("%s: unsupported field: %s", args[0], items[0])
+		}
+	}
+	return nil
+}
+
+func (qu *QueryUpdate) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	addr, err := c.Update(ctx, qu.key, qu.value, qu.current, qu.updated)
+	if err != nil {
+		return "", "", err
+	}
+	return toJson(addr)
+}
+
+type QueryList struct {
+	Name   string
+	filter ListFilter
+}
+
+func (ql *QueryList) String() string {
+	return fmt.Sprintf("%s(%+v)", ql.Name, ql.filter)
+}
+
+func (ql *QueryList) SetupArgs(args []string) error {
+	// args:
+	// [0]   [1...]
+	// list  [name=<glob>] [mac=<prefix>] [subnet=<cidr>] [match=full|partial]
+	for _, arg := range args[1:] {
+		items := strings.SplitN(arg, "=", 2)
+		if len(items) != 2 {
+			return fmt.Errorf("%s: malformed filter: `%s`", args[0], arg)
+		}
+		switch items[0] {
+		case "name":
+			ql.filter.HostnameGlob = items[1]
+		case "mac":
+			ql.filter.MacaddrPrefix = items[1]
+		case "subnet":
+			ql.filter.Subnet = items[1]
+		case "match":
+			if _, ok := pb.Match_value[strings.ToUpper(items[1])]; !ok {
+				return fmt.Errorf("%s: unsupported match: %s", args[0], items[1])
+			}
+			ql.filter.Match = items[1]
+		default:
+			return fmt.Errorf("%s: unsupported filter: %s", args[0], items[0])
+		}
+	}
+	return nil
+}
+
+func (ql *QueryList) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	entries, err := c.List(ctx, ql.filter)
+	var lines []string
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return strings.Join(lines, "\n"), "", err
+		}
+		lines = append(lines, string(b))
+	}
+	return strings.Join(lines, "\n"), "", err
+}
+
+type QueryWatch struct {
+	Name string
+	// Out receives the events as JSON lines
+	Out  io.Writer
+	from int64
+}
+
+func (qw *QueryWatch) String() string {
+	return fmt.Sprintf("%s(from=%d)", qw.Name, qw.from)
+}
+
+func (qw *QueryWatch) SetupArgs(args []string) error {
+	// args:
+	// [0]    [[1]]
+	// watch  [revision]
+	if len(args) >= 2 {
+		rev, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || rev < 0 {
+			return fmt.Errorf("%s: malformed revision: `%s`", args[0], args[1])
+		}
+		qw.from = rev
+	}
+	if qw.Out == nil {
+		qw.Out = os.Stdout
+	}
+	return nil
+}
+
+func (qw *QueryWatch) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	err := c.Watch(ctx, qw.from, func(ev Event) error {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(qw.Out, "%s\n", string(b))
+		return nil
+	})
+	return "", "", err
+}
+
+type QueryLeases struct {
+	Name    string
+	expired bool
+}
+
+func (ql *QueryLeases) String() string {
+	return fmt.Sprintf("%s(expired=%v)", ql.Name, ql.expired)
+}
+
+func (ql *QueryLeases) SetupArgs(args []string) error {
+	// args:
+	// [0]     [[1]]
+	// leases  [expired]
+	for _, arg := range args[1:] {
+		if arg != "expired" {
+			return fmt.Errorf("%s: unexpected argument: `%s`", args[0], arg)
+		}
+		ql.expired = true
+	}
+	return nil
+}
+
+func (ql *QueryLeases) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	entries, err := c.Leases(ctx, ql.expired)
+	var lines []string
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return strings.Join(lines, "\n"), "", err
+		}
+		lines = append(lines, string(b))
+	}
+	return strings.Join(lines, "\n"), "", err
+}
+
+type QueryStatus struct {
+	Name string
+}
+
+func (qs *QueryStatus) String() string {
+	return fmt.Sprintf("%s()", qs.Name)
+}
+
+func (qs *QueryStatus) SetupArgs(args []string) error {
+	return nil
+}
+
+func (qs *QueryStatus) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	st, err := c.Status(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return toJson(st)
+}
+
+type QueryCheck struct {
+	Name     string
+	fix      string
+	problems []string
+}
+
+func (qc *QueryCheck) String() string {
+	return fmt.Sprintf("%s(fix=%s problems=%v)", qc.Name, qc.fix, qc.problems)
+}
+
+func (qc *QueryCheck) SetupArgs(args []string) error {
+	// args:
+	// [0]    [[1]...]
+	// check  [fix=first|last] [problem...]
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "fix=") {
+			qc.fix = strings.TrimPrefix(arg, "fix=")
+		} else {
+			qc.problems = append(qc.problems, arg)
+		}
+	}
+	return nil
+}
+
+func (qc *QueryCheck) RunWith(ctx context.Context, c *Client) (string, string, error) {
+	findings, err := c.Check(ctx, qc.fix, qc.problems...)
+	var lines []string
+	for _, f := range findings {
+		b, err := json.Marshal(f)
+		if err != nil {
+			return strings.Join(lines, "\n"), "", err
+		}
+		lines = append(lines, string(b))
+	}
+	return strings.Join(lines, "\n"), "", err
 }
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage %s [options] subcommand args:\n", filepath.Base(os.Args[0]))
 	fmt.Fprintf(os.Stderr, "subcommands:\n")
-	fmt.Fprintf(os.Stderr, "- request <hostname> <macaddr> [ipaddr]\n")
+	fmt.Fprintf(os.Stderr, "- request <hostname> <macaddr>|id:<clientid> [ipaddr] [ip6=<ip6addr>] [id=<clientid>]\n")
+	fmt.Fprintf(os.Stderr, "          [pool=<pool>] [tag=<tag>...] [alias=<alias>...]\n")
 	fmt.Fprintf(os.Stderr, "- delete <how> <what>\n")
 	fmt.Fprintf(os.Stderr, "- lookup <how> <what>\n")
-	fmt.Fprintf(os.Stderr, "  * how:  one of 'name', 'mac', 'ip'\n")
+	fmt.Fprintf(os.Stderr, "- update <how> <what> [name=<hostname>] [mac=<macaddr>] [ip=<ipaddr>] [ip6=<ip6addr>] [id=<clientid>]\n")
+	fmt.Fprintf(os.Stderr, "         [if-name=<hostname>] [if-mac=<macaddr>] [if-ip=<ipaddr>] [if-ip6=<ip6addr>] [if-id=<clientid>]\n")
+	fmt.Fprintf(os.Stderr, "- alias-add <how> <what> <alias>...\n")
+	fmt.Fprintf(os.Stderr, "- alias-del <how> <what> <alias>...\n")
+	fmt.Fprintf(os.Stderr, "  * how:  one of 'name', 'mac', 'id', 'ip', 'alias'. 'ip' takes IPv4 and IPv6 addresses\n")
+	fmt.Fprintf(os.Stderr, "- list [name=<glob>] [mac=<prefix>] [subnet=<cidr>] [match=full|partial]\n")
+	fmt.Fprintf(os.Stderr, "- leases [expired]\n")
+	fmt.Fprintf(os.Stderr, "- pin <macaddr>|id:<clientid>|<ipaddr>|<hostname> [name=<hostname>] [pool=<pool>]\n")
+	fmt.Fprintf(os.Stderr, "- check [fix=first|last] [orphan-binding|orphan-host|out-of-range|shared-address|name-conflict...]\n")
+	fmt.Fprintf(os.Stderr, "- status\n")
+	fmt.Fprintf(os.Stderr, "- watch [revision]\n")
 	fmt.Fprintf(os.Stderr, "options:\n")
 	flag.PrintDefaults()
 }
 
 func ParseArgs() (*Config, []string) {
 	conf := Config{}
-	flag.StringVar(&conf.CertFile, "certfile", "", "The TLS cert file")
-	flag.StringVar(&conf.KeyFile, "keyfile", "", "The TLS key file")
-	flag.IntVar(&conf.Timeout, "timeout", 1, "The connection timeout (seconds)")
+	flag.BoolVar(&conf.TLS, "tls", false, "Connect using TLS, verifying the server with the system CAs")
+	flag.StringVar(&conf.CACertFile, "cacert", "", "The CA cert file to verify the server with (implies --tls)")
+	flag.StringVar(&conf.ServerName, "server-name", "", "The name to verify the server cert against, instead of the interface (implies --tls)")
+	flag.StringVar(&conf.CertFile, "certfile", "", "The client TLS cert file, for mutual TLS (implies --tls)")
+	flag.StringVar(&conf.KeyFile, "keyfile", "", "The client TLS key file, for mutual TLS")
+	flag.StringVar(&conf.TokenFile, "token-file", "", "The file holding the bearer token identifying the client (requires TLS)")
+	flag.IntVar(&conf.Timeout, "timeout", 1, "The call timeout (seconds)")
+	flag.IntVar(&conf.Retries, "retries", 0, "How many times a call is retried if the server is unavailable (changes are never retried)")
 	flag.StringVar(&conf.Iface, "interface", "127.0.0.1", "The server listening interface")
 	flag.IntVar(&conf.Port, "port", 50777, "The server port")
 
@@ -209,6 +712,22 @@ func NewQuery(args []string) (Queryable, error) {
 		query = &QueryRequest{Name: args[0]}
 	case "delete":
 		query = &QueryDelete{Name: args[0]}
+	case "update":
+		query = &QueryUpdate{Name: args[0]}
+	case "alias-add", "alias-del":
+		query = &QueryAlias{Name: args[0]}
+	case "list":
+		query = &QueryList{Name: args[0]}
+	case "leases":
+		query = &QueryLeases{Name: args[0]}
+	case "pin":
+		query = &QueryPin{Name: args[0]}
+	case "check":
+		query = &QueryCheck{Name: args[0]}
+	case "status":
+		query = &QueryStatus{Name: args[0]}
+	case "watch":
+		query = &QueryWatch{Name: args[0]}
 	default:
 		return nil, fmt.Errorf("Unsupported subcommand %s\n", args[0])
 	}
@@ -221,35 +740,51 @@ func NewQuery(args []string) (Queryable, error) {
 }
 
 type Config struct {
-	CertFile string
-	KeyFile  string
-	Timeout  int
-	Iface    string
-	Port     int
+	TLS        bool
+	CACertFile string
+	ServerName string
+	CertFile   string
+	KeyFile    string
+	TokenFile  string
+	Timeout    int
+	Retries    int
+	Iface      string
+	Port       int
 }
 
-func RunQuery(conf *Config, query Queryable) (string, string, error) {
-	var opts []grpc.DialOption
-	if conf.CertFile != "" && conf.KeyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(conf.CertFile, conf.KeyFile)
+// Options returns the client Options matching the command line configuration
+func (conf *Config) Options() (Options, error) {
+	var token string
+	if conf.TokenFile != "" {
+		data, err := ioutil.ReadFile(conf.TokenFile)
 		if err != nil {
-			return "", "", fmt.Errorf("Failed to generate credentials %v", err)
+			return Options{}, err
 		}
-		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
-	} else {
-		opts = []grpc.DialOption{grpc.WithInsecure()}
+		token = strings.TrimSpace(string(data))
 	}
+	return Options{
+		Address:    fmt.Sprintf("%s:%d", conf.Iface, conf.Port),
+		TLS:        conf.TLS,
+		CACertFile: conf.CACertFile,
+		ServerName: conf.ServerName,
+		CertFile:   conf.CertFile,
+		KeyFile:    conf.KeyFile,
+		Timeout:    time.Duration(conf.Timeout) * time.Second,
+		Token:      token,
+		Retries:    conf.Retries,
+	}, nil
+}
 
-	address := fmt.Sprintf("%s:%d", conf.Iface, conf.Port)
-	conn, err := grpc.Dial(address, opts...)
+func RunQuery(conf *Config, query Queryable) (string, string, error) {
+	opts, err := conf.Options()
 	if err != nil {
-		return "", "", fmt.Errorf("Could not connect: %v", err)
+		return "", "", err
 	}
-	defer conn.Close()
-	c := pb.NewDNSMasqManagerClient(conn)
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.Timeout)*time.Second)
-	defer cancel()
-
+	ctx := context.Background()
+	c, err := Dial(ctx, opts)
+	if err != nil {
+		return "", "", err
+	}
+	defer c.Close()
 	return query.RunWith(ctx, c)
 }
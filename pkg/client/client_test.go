@@ -0,0 +1,388 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/server"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+)
+
+const testHosts string = "" +
+	"127.0.0.1\tlocalhost\n" +
+	"192.168.1.63\tclient.test.lan\tclient\n" +
+	""
+
+const testLeases string = "" +
+	"52:54:aa:11:bb:22,192.168.1.63\n" +
+	""
+
+// startServer runs a server on a random local port, returning its address and the function to stop it
+func startServer(t *testing.T, setup func(conf *config.Config)) (string, func()) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	conf := config.Default()
+	conf.IPRange = "192.168.1.60-64"
+	conf.HostsPath = filepath.Join(dir, "hosts")
+	conf.LeasesPath = filepath.Join(dir, "dhcphosts")
+	conf.JournalPath = ""
+	ioutil.WriteFile(conf.HostsPath, []byte(testHosts), 0644)
+	ioutil.WriteFile(conf.LeasesPath, []byte(testLeases), 0644)
+	if setup != nil {
+		setup(conf)
+	}
+	opts, err := conf.SetupTLS()
+	if err != nil {
+		t.Fatalf("unexpected error setting up TLS: %v", err)
+	}
+
+	dmm, err := server.NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	opts = append(opts, dmm.ServerOptions()...)
+	serv := grpc.NewServer(opts...)
+	pb.RegisterDNSMasqManagerServer(serv, dmm)
+	go serv.Serve(lis)
+	return lis.Addr().String(), func() {
+		serv.Stop()
+		dmm.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClient(t *testing.T) {
+	addr, stop := startServer(t, nil)
+	defer stop()
+
+	ctx := context.Background()
+	c, err := Dial(ctx, Options{Address: addr, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error connecting: %v", err)
+	}
+	defer c.Close()
+
+	e, err := c.Lookup(ctx, KeyMacaddr, "52:54:aa:11:bb:22")
+	if err != nil || e.Name != "client.test.lan" || e.IP != "192.168.1.63" || e.Match != "full" {
+		t.Errorf("unexpected lookup result: %v %v", e, err)
+	}
+	_, err = c.Lookup(ctx, KeyHostname, "missing.test.lan")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error looking up a missing entry: %v", err)
+	}
+
+	a, err := c.Request(ctx, Address{Name: "new.test.lan", Mac: "02:00:00:00:00:01"}, "")
+	if err != nil || a.IP == "" {
+		t.Fatalf("unexpected request result: %v %v", a, err)
+	}
+	_, err = c.Request(ctx, Address{Name: "other.test.lan", Mac: "02:00:00:00:00:02", IP: a.IP}, "")
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("unexpected error requesting an address in use: %v", err)
+	}
+	_, err = c.Request(ctx, Address{Name: "other.test.lan", Mac: "02:00:00:00:00:02"}, "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error requesting from a missing pool: %v", err)
+	}
+
+	_, err = c.Update(ctx, KeyHostname, "new.test.lan", Address{IP: "192.168.1.99"}, Address{Name: "renamed.test.lan"})
+	if !errors.Is(err, ErrMismatch) {
+		t.Errorf("unexpected error updating a mismatching entry: %v", err)
+	}
+	u, err := c.Update(ctx, KeyHostname, "new.test.lan", Address{IP: a.IP}, Address{Name: "renamed.test.lan"})
+	if err != nil || u.Name != "renamed.test.lan" || u.IP != a.IP {
+		t.Errorf("unexpected update result: %v %v", u, err)
+	}
+
+	entries, err := c.List(ctx, ListFilter{HostnameGlob: "*.test.lan"})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("unexpected list result: %v %v", entries, err)
+	}
+
+	_, err = c.Delete(ctx, KeyIpaddr, a.IP)
+	if err != nil {
+		t.Errorf("unexpected error deleting: %v", err)
+	}
+	st, err := c.Status(ctx)
+	if err != nil || len(st.Pools) != 1 || st.Pools[0].Remaining != 4 {
+		t.Errorf("unexpected status: %v %v", st, err)
+	}
+}
+
+// writeCert creates a certificate for name signed by parent (self-signed if nil),
+// storing it and its key as <dir>/<name>.pem and <dir>/<name>.key
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating a key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("unexpected error creating a certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling a key: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "dnsmasqmgr.test", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	writeCert(t, dir, "rogue", nil, nil)
+
+	addr, stop := startServer(t, func(conf *config.Config) {
+		conf.CertFile = filepath.Join(dir, "dnsmasqmgr.test.pem")
+		conf.KeyFile = filepath.Join(dir, "dnsmasqmgr.test.key")
+		conf.ClientCAFile = filepath.Join(dir, "ca.pem")
+	})
+	defer stop()
+
+	cases := []struct {
+		name string
+		opts Options
+		ok   bool
+	}{
+		{"mtls", Options{CACertFile: "ca.pem", ServerName: "dnsmasqmgr.test", CertFile: "client.pem", KeyFile: "client.key"}, true},
+		{"no client cert", Options{CACertFile: "ca.pem", ServerName: "dnsmasqmgr.test"}, false},
+		{"untrusted client cert", Options{CACertFile: "ca.pem", ServerName: "dnsmasqmgr.test", CertFile: "rogue.pem", KeyFile: "rogue.key"}, false},
+		{"wrong server name", Options{CACertFile: "ca.pem", ServerName: "other.test", CertFile: "client.pem", KeyFile: "client.key"}, false},
+		{"untrusted server", Options{CACertFile: "rogue.pem", ServerName: "dnsmasqmgr.test", CertFile: "client.pem", KeyFile: "client.key"}, false},
+		{"plaintext", Options{}, false},
+	}
+	ctx := context.Background()
+	for _, c := range cases {
+		opts := c.opts
+		opts.Address = addr
+		opts.Timeout = 5 * time.Second
+		for _, path := range []*string{&opts.CACertFile, &opts.CertFile, &opts.KeyFile} {
+			if *path != "" {
+				*path = filepath.Join(dir, *path)
+			}
+		}
+		cl, err := Dial(ctx, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error connecting: %v", c.name, err)
+		}
+		_, err = cl.Status(ctx)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: unexpected success", c.name)
+		}
+		cl.Close()
+	}
+}
+
+func TestClientPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "dnsmasqmgr.test", ca, caKey)
+	policyPath := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(policyPath, []byte(`{
+		"tokens": [{"identity": "ci", "token": "s3cret"}],
+		"rules": [{"identity": "ci", "hostnames": ["*.ci.lan"]}]
+	}`), 0600)
+
+	addr, stop := startServer(t, func(conf *config.Config) {
+		conf.CertFile = filepath.Join(dir, "dnsmasqmgr.test.pem")
+		conf.KeyFile = filepath.Join(dir, "dnsmasqmgr.test.key")
+		conf.PolicyPath = policyPath
+	})
+	defer stop()
+
+	ctx := context.Background()
+	dial := func(token string) *Client {
+		c, err := Dial(ctx, Options{
+			Address:    addr,
+			CACertFile: filepath.Join(dir, "ca.pem"),
+			ServerName: "dnsmasqmgr.test",
+			Token:      token,
+			Timeout:    5 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error connecting: %v", err)
+		}
+		return c
+	}
+
+	ci := dial("s3cret")
+	defer ci.Close()
+	_, err = ci.Request(ctx, Address{Name: "build.ci.lan", Mac: "02:00:00:00:00:01"}, "")
+	if err != nil {
+		t.Errorf("unexpected error requesting an allowed address: %v", err)
+	}
+	_, err = ci.Request(ctx, Address{Name: "build.test.lan", Mac: "02:00:00:00:00:02"}, "")
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("unexpected error requesting a denied address: %v", err)
+	}
+
+	anon := dial("")
+	defer anon.Close()
+	_, err = anon.Delete(ctx, KeyHostname, "build.ci.lan")
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("unexpected error deleting anonymously: %v", err)
+	}
+	_, err = anon.Lookup(ctx, KeyHostname, "build.ci.lan")
+	if err != nil {
+		t.Errorf("unexpected error looking up anonymously: %v", err)
+	}
+
+	bad := dial("wrong")
+	defer bad.Close()
+	_, err = bad.Lookup(ctx, KeyHostname, "build.ci.lan")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("unexpected error with a bad token: %v", err)
+	}
+}
+
+func TestFromStatus(t *testing.T) {
+	err := fromStatus(server.ToStatus(fmt.Errorf("%w: x.test.lan", server.ErrEditConflict)))
+	if !errors.Is(err, ErrConflict) || errors.Is(err, ErrMismatch) {
+		t.Errorf("unexpected error for an edit conflict: %v", err)
+	}
+	err = fromStatus(server.ToStatus(server.ErrWatcherLag))
+	if !errors.Is(err, ErrLagging) {
+		t.Errorf("unexpected error for a lagging watcher: %v", err)
+	}
+	err = fromStatus(server.ToStatus(server.ErrMismatch))
+	if !errors.Is(err, ErrMismatch) {
+		t.Errorf("unexpected error for a mismatch: %v", err)
+	}
+}
+
+func TestClientUnavailable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	attempts := 0
+	count := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		attempts++
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	ctx := context.Background()
+	c, err := Dial(ctx, Options{
+		Address:     addr,
+		Timeout:     time.Second,
+		Retries:     2,
+		Backoff:     time.Millisecond,
+		DialOptions: []grpc.DialOption{grpc.WithUnaryInterceptor(count)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error connecting: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Status(ctx)
+	if !errors.Is(err, ErrUnavailable) || attempts != 3 {
+		t.Errorf("unexpected error from a missing server: %v after %d attempts", err, attempts)
+	}
+
+	// the server may have applied the change, it must not be requested again
+	attempts = 0
+	_, err = c.Request(ctx, Address{Name: "build.ci.lan", Mac: "02:00:00:00:00:01"}, "")
+	if !errors.Is(err, ErrUnavailable) || attempts != 1 {
+		t.Errorf("unexpected error from a missing server: %v after %d attempts", err, attempts)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	k, err := ParseKey("id")
+	if err != nil || k != KeyClientID {
+		t.Errorf("unexpected key: %v %v", k, err)
+	}
+	_, err = ParseKey("serial")
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unexpected error parsing an unknown key: %v", err)
+	}
+}
+
+func TestPinKey(t *testing.T) {
+	for value, key := range map[string]Key{
+		"52:54:aa:11:bb:22": KeyMacaddr,
+		"id:01:02:03:04":    KeyClientID,
+		"192.168.1.61":      KeyIpaddr,
+		"fd00::100":         KeyIpaddr,
+		"client.test.lan":   KeyHostname,
+	} {
+		if k := pinKey(value); k != key {
+			t.Errorf("unexpected key for %v: %v", value, k)
+		}
+	}
+}
@@ -21,6 +21,8 @@
 
 // The dhcphosts package provide utilities to work files in dhcphosts format
 // (see man 8 dnsmasq)
+// The content is modeled as a document: comments and blank lines are kept verbatim,
+// and entries are rewritten only if changed, so unchanged entries stay byte-identical.
 // This package use naive linear search and no lookup optimizations (e.g. maps, skipslists).
 // Rationale:
 // - we expect to work with maximum ~1000 entries (*one* thousand, not thousand*S*)
@@ -37,6 +39,8 @@ import (
 	"io"
 	"log"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -49,22 +53,170 @@ var (
 	ErrDuplicateFound   error = errors.New("Entry already found")
 )
 
-// Binding represents the binding between a MAC and an IP
+// Binding represents a dhcp-host entry (see --dhcp-host in man 8 dnsmasq):
+// the binding between the identifiers of a client (hardware addresses, client-id, hostname)
+// and the IP address and settings dnsmasq will hand out to it.
 type Binding struct {
+	// HW is the first (primary) hardware address of the client, if any
 	HW net.HardwareAddr
+	// ExtraHW are the additional hardware addresses the binding applies to
+	ExtraHW []net.HardwareAddr
+	// HWPatterns are the hardware addresses with wildcards (e.g. 00:20:e0:*:*:*), kept verbatim
+	HWPatterns []string
+	// ClientID is the DHCP client identifier, without the "id:" prefix. "*" means ignore the client-id
+	ClientID string
+	// SetTags are the tags set when the binding is used ("set:" prefix)
+	SetTags []string
+	// Tags are the tags which must be set for the binding to be used ("tag:" prefix)
+	Tags []string
+	// IP is the IPv4 address given to the client
 	IP net.IP
+	// IP6 is the IPv6 address given to the client. It may be just the interface identifier
+	// (e.g. ::56), in which case dnsmasq completes it with the prefix of the dhcp-range.
+	IP6 net.IP
+	// Hostname is the name given to the client, not to be confused with the hosts file names
+	Hostname string
+	// LeaseTime is kept verbatim, e.g. "45m" or "infinite"
+	LeaseTime string
+	// Ignore tells dnsmasq to never offer a lease to the client
+	Ignore bool
 }
 
-// ParseBindingString parses a string in the dhcphosts format (man 8 dnsmasq) and returns a Binding
+const (
+	commentMarker  string = "#"
+	clientIDPrefix string = "id:"
+	setTagPrefix   string = "set:"
+	tagPrefix      string = "tag:"
+	ignoreKeyword  string = "ignore"
+	infiniteLease  string = "infinite"
+)
+
+func isLeaseTime(s string) bool {
+	if s == infiniteLease {
+		return true
+	}
+	digits := strings.TrimRight(s, "smhdw")
+	if digits == "" || len(s)-len(digits) > 1 {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHostname(s string) bool {
+	if s == "" || s[0] == '-' || s[0] == '.' {
+		return false
+	}
+	for _, c := range s {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// isHWPattern tells if s is a hardware address with some bytes replaced by wildcards
+func isHWPattern(s string) bool {
+	parts := strings.Split(s, ":")
+	if len(parts) != 6 || !strings.Contains(s, "*") {
+		return false
+	}
+	for _, part := range parts {
+		if part == "*" {
+			continue
+		}
+		if len(part) != 2 {
+			return false
+		}
+		if _, err := strconv.ParseUint(part, 16, 8); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseIPToken parses either a bare IPv4 address or a bracketed IPv6 address,
+// and tells which one it found
+func parseIPToken(s string) (ip net.IP, v6 bool, ok bool) {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		ip = net.ParseIP(s[1 : len(s)-1])
+		return ip, true, ip != nil && ip.To4() == nil
+	}
+	ip = net.ParseIP(s)
+	return ip, false, ip != nil && ip.To4() != nil
+}
+
+// ParseBindingString parses a string in the dhcphosts format (man 8 dnsmasq) and returns a Binding.
+// The supported grammar is
+// [<hwaddr>...][,id:<client_id>|*][,set:<tag>...][,tag:<tag>...][,<ipaddr>][,<hostname>][,<lease_time>][,ignore]
+// where <ipaddr> is an IPv4 address, a bracketed IPv6 address, or one of each,
+// and <hwaddr> may have wildcards in place of some bytes.
+// A token which fits nowhere is reported against the field expected at its position.
 func ParseBindingString(s string) (Binding, error) {
-	a := strings.Split(s, ",")
-	if len(a) != 2 {
+	items := strings.Split(s, ",")
+	if len(items) < 2 {
 		return Binding{}, ErrBadBindingFormat
 	}
-	return ParseBinding(a[0], a[1])
+
+	b := Binding{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == ignoreKeyword {
+			b.Ignore = true
+		} else if strings.HasPrefix(item, clientIDPrefix) {
+			b.ClientID = strings.TrimPrefix(item, clientIDPrefix)
+		} else if strings.HasPrefix(item, setTagPrefix) {
+			b.SetTags = append(b.SetTags, strings.TrimPrefix(item, setTagPrefix))
+		} else if strings.HasPrefix(item, tagPrefix) {
+			b.Tags = append(b.Tags, strings.TrimPrefix(item, tagPrefix))
+		} else if hwAddr, err := net.ParseMAC(item); err == nil {
+			if b.HW == nil {
+				b.HW = hwAddr
+			} else {
+				b.ExtraHW = append(b.ExtraHW, hwAddr)
+			}
+		} else if isHWPattern(item) {
+			b.HWPatterns = append(b.HWPatterns, item)
+		} else if ip, v6, ok := parseIPToken(item); ok {
+			if v6 {
+				if b.IP6 != nil {
+					return Binding{}, ErrBadBindingFormat
+				}
+				b.IP6 = ip
+			} else {
+				if b.IP != nil {
+					return Binding{}, ErrBadBindingFormat
+				}
+				b.IP = ip
+			}
+		} else if isLeaseTime(item) {
+			b.LeaseTime = item
+		} else if isHostname(item) {
+			if b.Hostname != "" {
+				return Binding{}, ErrBadBindingFormat
+			}
+			b.Hostname = item
+		} else if b.HW == nil && b.HWPatterns == nil && b.ClientID == "" {
+			return Binding{}, ErrBadHWAddrFormat
+		} else if b.IP == nil && b.IP6 == nil {
+			return Binding{}, ErrBadIPFormat
+		} else {
+			return Binding{}, ErrBadBindingFormat
+		}
+	}
+
+	if b.HW == nil && b.HWPatterns == nil && b.ClientID == "" && b.Hostname == "" {
+		return Binding{}, ErrBadBindingFormat
+	}
+	return b, nil
 }
 
-// ParseBinding creates a Binding between a MAC and a IP, expressed as strings
+// ParseBinding creates a Binding between a MAC and a IP, expressed as strings.
+// The IP may be either an IPv4 or an IPv6 address.
 func ParseBinding(hw, ip string) (Binding, error) {
 	hwAddr, err := net.ParseMAC(hw)
 	if err != nil {
@@ -74,10 +226,65 @@ func ParseBinding(hw, ip string) (Binding, error) {
 	if ipAddr == nil {
 		return Binding{}, ErrBadIPFormat
 	}
-	return Binding{
+	b := Binding{
 		HW: hwAddr,
-		IP: ipAddr,
-	}, nil
+	}
+	b.SetIP(ipAddr)
+	return b, nil
+}
+
+// SetIP sets the IPv4 or the IPv6 address of the Binding, depending on the family of ip
+func (b *Binding) SetIP(ip net.IP) {
+	if ip.To4() != nil {
+		b.IP = ip
+	} else {
+		b.IP6 = ip
+	}
+}
+
+// IPs returns the addresses of the Binding, IPv4 first
+func (b Binding) IPs() []net.IP {
+	var ret []net.IP
+	if b.IP != nil {
+		ret = append(ret, b.IP)
+	}
+	if b.IP6 != nil {
+		ret = append(ret, b.IP6)
+	}
+	return ret
+}
+
+// HWAddrs returns all the hardware addresses the Binding applies to
+func (b Binding) HWAddrs() []net.HardwareAddr {
+	if b.HW == nil {
+		return nil
+	}
+	return append([]net.HardwareAddr{b.HW}, b.ExtraHW...)
+}
+
+// Key returns the identifier of the Binding: the primary hardware address if any,
+// otherwise the first wildcard hardware address, the client-id or the hostname, whichever is set first.
+func (b Binding) Key() string {
+	if b.HW != nil {
+		return b.HW.String()
+	}
+	if len(b.HWPatterns) > 0 {
+		return b.HWPatterns[0]
+	}
+	if b.ClientID != "" && b.ClientID != "*" {
+		return clientIDPrefix + b.ClientID
+	}
+	return b.Hostname
+}
+
+// HasHW returns true if any of the hardware addresses of the Binding is equal to the argument, false otherwise
+func (b Binding) HasHW(x net.HardwareAddr) bool {
+	for _, hw := range b.HWAddrs() {
+		if bytes.Equal(hw, x) {
+			return true
+		}
+	}
+	return false
 }
 
 // EqualHW returns true if the Binding has the MAC part equal to the argument, false otherwise
@@ -85,36 +292,145 @@ func (b Binding) EqualHW(x net.HardwareAddr) bool {
 	return bytes.Equal(b.HW, x)
 }
 
-// EqualIP returns true if the Binding has the IP part equal to the argument, false otherwise
+// EqualIP returns true if either the IPv4 or the IPv6 address of the Binding is equal to the argument, false otherwise
 func (b Binding) EqualIP(x net.IP) bool {
-	return b.IP.Equal(x)
+	if x == nil {
+		return false
+	}
+	return b.IP.Equal(x) || b.IP6.Equal(x)
 }
 
 // Equal returns true if the Binding is equal to the argument, false otherwise
 func (b Binding) Equal(x Binding) bool {
-	return b.EqualHW(x.HW) && b.EqualIP(x.IP)
+	return b.EqualHW(x.HW) && b.IP.Equal(x.IP) && b.IP6.Equal(x.IP6)
 }
 
+// Duplicate returns true if the Binding identifies the same client as the argument, false otherwise
 func (b Binding) Duplicate(x Binding) bool {
-	return b.EqualHW(x.HW)
+	if b.Key() == x.Key() {
+		return true
+	}
+	for _, hw := range x.HWAddrs() {
+		if b.HasHW(hw) {
+			return true
+		}
+	}
+	return false
 }
 
 // String converts the binding in its dhcphosts (man 8 dnsmasq) representation
 func (b Binding) String() string {
-	return fmt.Sprintf("%s,%s", b.HW.String(), b.IP.String())
+	var items []string
+	for _, hw := range b.HWAddrs() {
+		items = append(items, hw.String())
+	}
+	items = append(items, b.HWPatterns...)
+	if b.ClientID != "" {
+		items = append(items, clientIDPrefix+b.ClientID)
+	}
+	for _, tag := range b.SetTags {
+		items = append(items, setTagPrefix+tag)
+	}
+	for _, tag := range b.Tags {
+		items = append(items, tagPrefix+tag)
+	}
+	if b.IP != nil {
+		items = append(items, b.IP.String())
+	}
+	if b.IP6 != nil {
+		// dnsmasq wants IPv6 addresses in brackets
+		items = append(items, fmt.Sprintf("[%s]", b.IP6.String()))
+	}
+	if b.Hostname != "" {
+		items = append(items, b.Hostname)
+	}
+	if b.LeaseTime != "" {
+		items = append(items, b.LeaseTime)
+	}
+	if b.Ignore {
+		items = append(items, ignoreKeyword)
+	}
+	return strings.Join(items, ",")
+}
+
+// Order tells how the Bindings are laid out when the Conf is converted to its text representation
+type Order int
+
+const (
+	// OrderInsertion keeps the Bindings in the order they were parsed or added
+	OrderInsertion Order = iota
+	// OrderByIP sorts the Bindings by IP address, IPv4 first. Bindings without IP address go last.
+	OrderByIP
+)
+
+var (
+	orderNames = map[string]Order{
+		"":          OrderInsertion,
+		"insertion": OrderInsertion,
+		"ip":        OrderByIP,
+	}
+)
+
+// ParseOrder converts the name of an Order ("insertion", "ip") to its value
+func ParseOrder(s string) (Order, error) {
+	o, ok := orderNames[s]
+	if !ok {
+		return OrderInsertion, fmt.Errorf("unknown order: %q", s)
+	}
+	return o, nil
 }
 
-// Conf represents the configured Bindings
+// line is a line of the dhcphosts file. Comments and blank lines are kept verbatim.
+type line struct {
+	// raw is the original text. It is cleared when the binding is changed.
+	raw     string
+	binding *Binding
+}
+
+func (l *line) String() string {
+	if l.binding == nil || l.raw != "" {
+		return l.raw
+	}
+	return l.binding.String()
+}
+
+// Conf represents the configured Bindings, as a document
 type Conf struct {
+	order Order
+	lines []*line
 	// this is not really for efficiency, even though it's a nice plus,
-	// but rather  because MAC (as string) is the key here.
-	bindings map[string]Binding
+	// but rather  because MAC (as string) is the key here. See Binding.Key.
+	bindings map[string]*line
 }
 
 func NewConf() *Conf {
 	return &Conf{
-		bindings: make(map[string]Binding),
+		bindings: make(map[string]*line),
+	}
+}
+
+// SetOrder sets the layout of the text representation of the Conf
+func (m *Conf) SetOrder(o Order) {
+	m.order = o
+}
+
+// Order returns the layout of the text representation of the Conf
+func (m *Conf) Order() Order {
+	return m.order
+}
+
+// Clone returns a copy of the Conf which can be changed independently.
+// Lines are never changed in place, so they can be shared.
+func (m *Conf) Clone() *Conf {
+	x := &Conf{
+		order:    m.order,
+		lines:    append([]*line(nil), m.lines...),
+		bindings: make(map[string]*line, len(m.bindings)),
 	}
+	for k, v := range m.bindings {
+		x.bindings[k] = v
+	}
+	return x
 }
 
 // Len returns the number of configured Bindings
@@ -122,57 +438,186 @@ func (m *Conf) Len() int {
 	return len(m.bindings)
 }
 
-// String converts all the registered bindings in the Conf in content in dhcphosts (man 8 dnsmasq) representation
+// Bindings returns all the configured Bindings, in insertion order
+func (m *Conf) Bindings() []Binding {
+	var ret []Binding
+	for _, l := range m.lines {
+		if l.binding != nil {
+			ret = append(ret, *l.binding)
+		}
+	}
+	return ret
+}
+
+// block is a binding line together with the comments and blank lines preceding it.
+type block []*line
+
+func (b block) ip() net.IP {
+	bd := b[len(b)-1].binding
+	if bd.IP != nil {
+		return bd.IP
+	}
+	return bd.IP6
+}
+
+func (m *Conf) sortedLines() []*line {
+	var blocks []block
+	var cur block
+	for _, l := range m.lines {
+		cur = append(cur, l)
+		if l.binding != nil {
+			blocks = append(blocks, cur)
+			cur = nil
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		ipI, ipJ := blocks[i].ip(), blocks[j].ip()
+		if ipI == nil || ipJ == nil {
+			return ipJ == nil && ipI != nil
+		}
+		return bytes.Compare(ipI.To16(), ipJ.To16()) < 0
+	})
+	var ret []*line
+	for _, b := range blocks {
+		ret = append(ret, b...)
+	}
+	// trailing comments stay at the end
+	return append(ret, cur...)
+}
+
+// String converts the Conf in content in dhcphosts (man 8 dnsmasq) representation.
+// Lines not changed since parsing are emitted exactly as they were read.
 func (m *Conf) String() string {
+	lines := m.lines
+	if m.order == OrderByIP {
+		lines = m.sortedLines()
+	}
 	var sb strings.Builder
-	for _, bi := range m.bindings {
-		sb.WriteString(fmt.Sprintf("%s\n", bi.String()))
+	for _, l := range lines {
+		sb.WriteString(fmt.Sprintf("%s\n", l.String()))
 	}
 	return sb.String()
 }
 
-func (m *Conf) duplicate(x Binding) *Binding {
-	for _, b := range m.bindings {
-		if b.Duplicate(x) {
-			return &b
+// duplicate returns the Binding x conflicts with, ignoring the line skip
+func (m *Conf) duplicate(x Binding, skip *line) *Binding {
+	for _, l := range m.lines {
+		if l.binding != nil && l != skip && l.binding.Duplicate(x) {
+			return l.binding
 		}
 	}
 	return nil
 }
 
-func (m *Conf) add(b Binding) error {
-	if x := m.duplicate(b); x != nil {
-		return fmt.Errorf("%s: %s", ErrDuplicateFound, x)
+func (m *Conf) add(b Binding, raw string) error {
+	if x := m.duplicate(b, nil); x != nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateFound, x)
+	}
+	l := &line{
+		raw:     raw,
+		binding: &b,
 	}
-	m.bindings[b.HW.String()] = b
+	m.lines = append(m.lines, l)
+	m.bindings[b.Key()] = l
 	log.Printf("dhcphosts: added [[%s]]", b)
 	return nil
 }
 
+func (m *Conf) addRaw(raw string) {
+	m.lines = append(m.lines, &line{raw: raw})
+}
+
 // Add registers a new Binding
 func (m *Conf) Add(mac, ip string) (Binding, error, bool) {
 	hwAddr, err := net.ParseMAC(mac)
 	if err != nil {
 		return Binding{}, err, false
 	}
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil {
+		return Binding{HW: hwAddr}, ErrBadIPFormat, false
+	}
 	ret := Binding{
 		HW: hwAddr,
-		IP: net.ParseIP(ip),
 	}
-	if ret.IP == nil {
-		return ret, ErrBadIPFormat, false
-	}
-	err = m.add(ret)
+	ret.SetIP(ipAddr)
+	err = m.add(ret, "")
 	return ret, err, err != nil
 }
 
-func (m *Conf) Remove(mac string) (Binding, bool) {
-	ret, removed := m.bindings[mac]
-	delete(m.bindings, mac)
+// AddBinding registers a new, fully specified, Binding
+func (m *Conf) AddBinding(b Binding) (Binding, error, bool) {
+	if b.Key() == "" {
+		return b, ErrBadBindingFormat, false
+	}
+	err := m.add(b, "")
+	return b, err, err != nil
+}
+
+// normalizeKey converts a hardware address to the form used by Binding.Key.
+// Any other key (e.g. "id:<client_id>") is returned unchanged.
+func normalizeKey(key string) string {
+	if hwAddr, err := net.ParseMAC(key); err == nil {
+		return hwAddr.String()
+	}
+	return key
+}
+
+// Remove drops the Binding identified by key, which is usually the primary hardware address.
+// See Binding.Key.
+func (m *Conf) Remove(key string) (Binding, bool) {
+	var ret Binding
+	key = normalizeKey(key)
+	l, removed := m.bindings[key]
+	if removed {
+		ret = *l.binding
+		delete(m.bindings, key)
+		for ix, x := range m.lines {
+			if x == l {
+				m.lines = append(m.lines[:ix], m.lines[ix+1:]...)
+				break
+			}
+		}
+	}
 	log.Printf("dhcphosts: removed [[%s]] -> %v", ret, removed)
 	return ret, removed
 }
 
+// Replace changes in place the Binding identified by key, which is usually the primary hardware address.
+// See Binding.Key.
+func (m *Conf) Replace(key string, b Binding) error {
+	key = normalizeKey(key)
+	l, ok := m.bindings[key]
+	if !ok {
+		return ErrHWAddrNotFound
+	}
+	if x := m.duplicate(b, l); x != nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateFound, x)
+	}
+	nl := &line{
+		binding: &b,
+	}
+	for ix, x := range m.lines {
+		if x == l {
+			m.lines[ix] = nl
+			break
+		}
+	}
+	delete(m.bindings, key)
+	m.bindings[b.Key()] = nl
+	log.Printf("dhcphosts: replaced [[%s]] -> [[%s]]", l.binding, b)
+	return nil
+}
+
+// GetByKey returns the Binding identified by key. See Binding.Key.
+func (m *Conf) GetByKey(key string) (Binding, error) {
+	l, ok := m.bindings[normalizeKey(key)]
+	if !ok {
+		return Binding{}, ErrHWAddrNotFound
+	}
+	return *l.binding, nil
+}
+
 func (m *Conf) GetByHWAddr(hw string) (Binding, error) {
 	err := ErrHWAddrNotFound
 	var ret Binding
@@ -181,9 +626,17 @@ func (m *Conf) GetByHWAddr(hw string) (Binding, error) {
 		log.Printf("dhcphosts: GetByHWAddr(%s) -> (%s, %v)", hw, ret, err)
 	}()
 
-	ret, ok := m.bindings[hw]
-	if ok {
-		err = nil
+	hwAddr, perr := net.ParseMAC(hw)
+	if perr != nil {
+		err = ErrBadHWAddrFormat
+		return ret, err
+	}
+	for _, l := range m.lines {
+		if l.binding != nil && l.binding.HasHW(hwAddr) {
+			ret = *l.binding
+			err = nil
+			break
+		}
 	}
 	return ret, err
 }
@@ -200,9 +653,9 @@ func (m *Conf) GetByIP(ip string) (Binding, error) {
 	if x == nil {
 		return Binding{}, ErrBadIPFormat
 	}
-	for _, b := range m.bindings {
-		if b.EqualIP(x) {
-			ret = b
+	for _, l := range m.lines {
+		if l.binding != nil && l.binding.EqualIP(x) {
+			ret = *l.binding
 			err = nil
 			break
 		}
@@ -211,15 +664,26 @@ func (m *Conf) GetByIP(ip string) (Binding, error) {
 }
 
 // Parse creates a Conf from a reader, which must return content in dhcphosts (man 8 dnsmasq) format
+// Comments and blank lines are kept verbatim, duplicate entries are logged and kept verbatim.
 func Parse(r io.Reader) (*Conf, error) {
 	m := NewConf()
 	s := bufio.NewScanner(r)
 	for s.Scan() {
-		b, err := ParseBindingString(s.Text())
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, commentMarker) {
+			m.addRaw(line)
+			continue
+		}
+		b, err := ParseBindingString(trimmed)
 		if err != nil {
 			return nil, err
 		}
-		m.add(b)
+		err = m.add(b, line)
+		if err != nil {
+			log.Printf("dhcphosts: error adding '%s': %v", b, err)
+			m.addRaw(line)
+		}
 	}
-	return m, nil
+	return m, s.Err()
 }
@@ -23,6 +23,7 @@ package dhcphosts
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 	"strings"
 
@@ -47,8 +48,17 @@ func TestBindingParseError(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	// legal in dnsmasq.conf, but unsupported yet
-	_, err = ParseBindingString("01:23:45:67:89:ab,1.1.1.1,extra")
+	_, err = ParseBindingString("01:23:45:67:89:ab,1.1.1.1,2.2.2.2")
+	if err != ErrBadBindingFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = ParseBindingString("01:23:45:67:89:ab,1.1.1.1,host1,host2")
+	if err != ErrBadBindingFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = ParseBindingString("1.1.1.1,45m")
 	if err != ErrBadBindingFormat {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -76,6 +86,111 @@ func TestBindingParseRoundTrip(t *testing.T) {
 	}
 }
 
+func TestBindingParseFullSyntax(t *testing.T) {
+	b, err := ParseBindingString("01:23:45:67:89:ab,fe:dc:ba:98:76:54,id:01:02:03,set:red,tag:lan,1.1.1.1,laptop,45m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.HW.String() != "01:23:45:67:89:ab" || len(b.ExtraHW) != 1 || b.ExtraHW[0].String() != "fe:dc:ba:98:76:54" {
+		t.Errorf("unexpected hardware addresses: %v %v", b.HW, b.ExtraHW)
+	}
+	if b.ClientID != "01:02:03" {
+		t.Errorf("unexpected client-id: %v", b.ClientID)
+	}
+	if len(b.SetTags) != 1 || b.SetTags[0] != "red" || len(b.Tags) != 1 || b.Tags[0] != "lan" {
+		t.Errorf("unexpected tags: set=%v tag=%v", b.SetTags, b.Tags)
+	}
+	if b.IP.String() != "1.1.1.1" || b.Hostname != "laptop" || b.LeaseTime != "45m" || b.Ignore {
+		t.Errorf("unexpected binding: %#v", b)
+	}
+
+	b, err = ParseBindingString("id:*,01:23:45:67:89:ab,ignore")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.ClientID != "*" || !b.Ignore || b.IP != nil {
+		t.Errorf("unexpected binding: %#v", b)
+	}
+
+	b, err = ParseBindingString("laptop,[fd00::56],infinite")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.HW != nil || b.Hostname != "laptop" || b.IP != nil || b.IP6.String() != "fd00::56" || b.LeaseTime != "infinite" {
+		t.Errorf("unexpected binding: %#v", b)
+	}
+	if b.Key() != "laptop" {
+		t.Errorf("unexpected key: %v", b.Key())
+	}
+
+	b, err = ParseBindingString("00:20:e0:*:*:*,set:red,ignore")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.HW != nil || len(b.HWPatterns) != 1 || b.Key() != "00:20:e0:*:*:*" || !b.Ignore {
+		t.Errorf("unexpected binding: %#v", b)
+	}
+	if b.String() != "00:20:e0:*:*:*,set:red,ignore" {
+		t.Errorf("failed roundtrip: %s", b)
+	}
+
+	b, err = ParseBindingString("01:23:45:67:89:ab,id:00:01:00:01:16:d2:83:fc,192.168.0.5,[::56],laptop")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.IP.String() != "192.168.0.5" || b.IP6.String() != "::56" || b.ClientID != "00:01:00:01:16:d2:83:fc" {
+		t.Errorf("unexpected binding: %#v", b)
+	}
+	if !b.EqualIP(net.ParseIP("::56")) || !b.EqualIP(net.ParseIP("192.168.0.5")) {
+		t.Errorf("addresses not matched: %#v", b)
+	}
+
+	for _, s := range []string{
+		"01:23:45:67:89:ab,192.168.0.5,192.168.0.6",
+		"01:23:45:67:89:ab,[fd00::5],[fd00::6]",
+		"01:23:45:67:89:ab,fd00::5",
+	} {
+		if _, err = ParseBindingString(s); err == nil {
+			t.Errorf("parsing %q: unexpected success", s)
+		}
+	}
+}
+
+func TestBindingParseBindingIPv6(t *testing.T) {
+	b, err := ParseBinding("01:23:45:67:89:ab", "fd00::56")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.IP != nil || b.IP6.String() != "fd00::56" {
+		t.Errorf("unexpected binding: %#v", b)
+	}
+	if b.String() != "01:23:45:67:89:ab,[fd00::56]" {
+		t.Errorf("unexpected representation: %s", b)
+	}
+}
+
+func TestBindingParseFullSyntaxRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"01:23:45:67:89:ab,1.1.1.1,laptop",
+		"01:23:45:67:89:ab,fe:dc:ba:98:76:54,1.1.1.1,laptop,12h",
+		"01:23:45:67:89:ab,id:*,set:red,tag:lan,1.1.1.1,infinite",
+		"id:00:01:00:01:16:d2:83:fc:92:d4:19:e2:d8:b2,[fd00::56],server",
+		"01:23:45:67:89:ab,192.168.0.5,[fd00::5],laptop",
+		"id:00:01:00:01:16:d2:83:fc,[::56],server",
+		"01:23:45:67:89:ab,ignore",
+	} {
+		b, err := ParseBindingString(s)
+		if err != nil {
+			t.Errorf("parsing %q: unexpected error: %v", s, err)
+			continue
+		}
+		x := b.String()
+		if s != x {
+			t.Errorf("failed roundtrip: %s %s", s, x)
+		}
+	}
+}
+
 func TestBindingEqual(t *testing.T) {
 	b1, err := ParseBinding("01:23:45:67:89:ab", "1.1.1.1")
 	if err != nil {
@@ -208,6 +323,108 @@ func TestConfDumpFile(t *testing.T) {
 	}
 }
 
+const testDataAnnotated string = "" +
+	"# servers\n" +
+	"52:54:AA:11:BB:22,192.168.1.63\n" +
+	"\n" +
+	"# laptop, wired and wireless\n" +
+	"01:23:45:67:89:ab,fe:dc:ba:98:76:54,192.168.1.9,laptop,12h\n" +
+	"52:54:31:AB:44:CD,192.168.1.121\n" +
+	"# end\n" +
+	""
+
+func TestConfPreservesContent(t *testing.T) {
+	m, err := Parse(strings.NewReader(testDataAnnotated))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	L := m.Len()
+	if L != 3 {
+		t.Errorf("unexpected number of entries: %v", L)
+	}
+	if x := m.String(); x != testDataAnnotated {
+		t.Errorf("inconsistent content:\n%v\n%v\n", x, testDataAnnotated)
+	}
+
+	_, removed := m.Remove("52:54:31:ab:44:cd")
+	if !removed {
+		t.Errorf("failed to remove existing entry")
+	}
+	_, err, _ = m.Add("02:00:00:00:00:01", "192.168.1.2")
+	if err != nil {
+		t.Errorf("unexpected error adding: %v", err)
+	}
+	expected := "" +
+		"# servers\n" +
+		"52:54:AA:11:BB:22,192.168.1.63\n" +
+		"\n" +
+		"# laptop, wired and wireless\n" +
+		"01:23:45:67:89:ab,fe:dc:ba:98:76:54,192.168.1.9,laptop,12h\n" +
+		"# end\n" +
+		"02:00:00:00:00:01,192.168.1.2\n" +
+		""
+	if x := m.String(); x != expected {
+		t.Errorf("inconsistent content:\n%v\n%v\n", x, expected)
+	}
+}
+
+func TestConfOrderByIP(t *testing.T) {
+	m, err := Parse(strings.NewReader(testDataAnnotated))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	m.SetOrder(OrderByIP)
+	expected := "" +
+		"\n" +
+		"# laptop, wired and wireless\n" +
+		"01:23:45:67:89:ab,fe:dc:ba:98:76:54,192.168.1.9,laptop,12h\n" +
+		"# servers\n" +
+		"52:54:AA:11:BB:22,192.168.1.63\n" +
+		"52:54:31:AB:44:CD,192.168.1.121\n" +
+		"# end\n" +
+		""
+	if x := m.String(); x != expected {
+		t.Errorf("inconsistent content:\n%v\n%v\n", x, expected)
+	}
+
+	_, err = ParseOrder("random")
+	if err == nil {
+		t.Errorf("unexpected success parsing a bogus order")
+	}
+}
+
+func TestConfClientID(t *testing.T) {
+	m := NewConf()
+	b := Binding{
+		ClientID: "00:01:00:01:16:d2:83:fc",
+		IP6:      net.ParseIP("fd00::56"),
+		Hostname: "server",
+	}
+	_, err, _ := m.AddBinding(b)
+	if err != nil {
+		t.Fatalf("unexpected error adding: %v", err)
+	}
+	key := "id:00:01:00:01:16:d2:83:fc"
+	x, err := m.GetByKey(key)
+	if err != nil || !x.IP6.Equal(b.IP6) {
+		t.Errorf("unexpected lookup result: %v %v", x, err)
+	}
+	x, err = m.GetByIP("fd00::56")
+	if err != nil || x.Key() != key {
+		t.Errorf("unexpected lookup result: %v %v", x, err)
+	}
+	b.IP = net.ParseIP("192.168.1.5")
+	if err = m.Replace(key, b); err != nil {
+		t.Errorf("unexpected error replacing: %v", err)
+	}
+	if s := m.String(); s != "id:00:01:00:01:16:d2:83:fc,192.168.1.5,[fd00::56],server\n" {
+		t.Errorf("unexpected content: %q", s)
+	}
+	if _, removed := m.Remove(key); !removed || m.Len() != 0 {
+		t.Errorf("failed to remove by client-id")
+	}
+}
+
 func cleanup(tmpfile *os.File) {
 	tmpfile.Close()
 	os.Remove(tmpfile.Name())
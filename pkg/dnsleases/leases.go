@@ -0,0 +1,277 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// The dnsleases package reads the file where dnsmasq records the leases it handed out
+// (see --dhcp-leasefile in man 8 dnsmasq), usually /var/lib/misc/dnsmasq.leases.
+// The file is owned by dnsmasq, which rewrites it at every change: this package never writes it.
+// Each line is a lease:
+// <expiry> <hwaddr> <ipaddr> <hostname>|* <client-id>|*
+// The DHCPv6 leases follow a line holding the DUID of the server, and are
+// <expiry> [T]<iaid> <ip6addr> <hostname>|* <client-duid>|*
+// Expiry is in seconds since the epoch, 0 meaning never.
+
+package dnsleases
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadLeaseFormat  error = errors.New("Malformed lease")
+	ErrBadExpiry       error = errors.New("Malformed lease expiry")
+	ErrBadHWAddrFormat error = errors.New("Malformed hardware address")
+	ErrBadIPFormat     error = errors.New("Malformed IP address")
+	ErrBadIAID         error = errors.New("Malformed IAID")
+)
+
+const (
+	duidMarker  string = "duid"
+	noValue     string = "*"
+	tempPrefix  string = "T"
+	leaseFields int    = 5
+)
+
+type Lease struct {
+	// Expiry is when the lease ends, the zero time if never
+	Expiry time.Time
+	// HW is the hardware address of the client, DHCPv4 only
+	HW net.HardwareAddr
+	// HWType is the ARP hardware type of HW, if dnsmasq recorded it (i.e. not ethernet)
+	HWType int
+	IP     net.IP
+	// Hostname is the name the client sent, empty if none
+	Hostname string
+	// ClientID is the DHCP client identifier, or the DUID of DHCPv6 clients, empty if none
+	ClientID string
+	// IAID is the identity association of the DHCPv6 lease
+	IAID uint32
+	// Temporary is true for the DHCPv6 temporary addresses
+	Temporary bool
+}
+
+// IsV6 returns true for the DHCPv6 leases
+func (l Lease) IsV6() bool {
+	return l.IP.To4() == nil
+}
+
+// Expired returns true if the lease ended before now
+func (l Lease) Expired(now time.Time) bool {
+	return !l.Expiry.IsZero() && l.Expiry.Before(now)
+}
+
+func orNoValue(s string) string {
+	if s == "" {
+		return noValue
+	}
+	return s
+}
+
+func (l Lease) String() string {
+	var expiry int64
+	if !l.Expiry.IsZero() {
+		expiry = l.Expiry.Unix()
+	}
+	if l.IsV6() {
+		temp := ""
+		if l.Temporary {
+			temp = tempPrefix
+		}
+		return fmt.Sprintf("%d %s%d %s %s %s", expiry, temp, l.IAID, l.IP, orNoValue(l.Hostname), orNoValue(l.ClientID))
+	}
+	hw := l.HW.String()
+	if l.HWType != 0 || l.HW == nil {
+		hw = fmt.Sprintf("%02x-%s", l.HWType, hw)
+	}
+	return fmt.Sprintf("%d %s %s %s %s", expiry, hw, l.IP, orNoValue(l.Hostname), orNoValue(l.ClientID))
+}
+
+func fromNoValue(s string) string {
+	if s == noValue {
+		return ""
+	}
+	return s
+}
+
+// ParseLeaseString parses a line of the leases file, either a DHCPv4 or a DHCPv6 lease, as told by v6
+func ParseLeaseString(s string, v6 bool) (Lease, error) {
+	items := strings.Fields(s)
+	if len(items) != leaseFields {
+		return Lease{}, ErrBadLeaseFormat
+	}
+	l := Lease{
+		Hostname: fromNoValue(items[3]),
+		ClientID: fromNoValue(items[4]),
+	}
+	expiry, err := strconv.ParseInt(items[0], 10, 64)
+	if err != nil || expiry < 0 {
+		return Lease{}, ErrBadExpiry
+	}
+	if expiry > 0 {
+		l.Expiry = time.Unix(expiry, 0)
+	}
+	l.IP = net.ParseIP(items[2])
+	if l.IP == nil || (l.IP.To4() == nil) != v6 {
+		return Lease{}, ErrBadIPFormat
+	}
+
+	if v6 {
+		iaid := items[1]
+		if strings.HasPrefix(iaid, tempPrefix) {
+			l.Temporary = true
+			iaid = strings.TrimPrefix(iaid, tempPrefix)
+		}
+		v, err := strconv.ParseUint(iaid, 10, 32)
+		if err != nil {
+			return Lease{}, ErrBadIAID
+		}
+		l.IAID = uint32(v)
+		return l, nil
+	}
+
+	hw := items[1]
+	// hardware types other than ethernet are recorded as "<type>-<address>"
+	if pos := strings.Index(hw, "-"); pos != -1 && pos <= 2 {
+		t, err := strconv.ParseUint(hw[:pos], 16, 8)
+		if err != nil {
+			return Lease{}, ErrBadHWAddrFormat
+		}
+		l.HWType = int(t)
+		hw = hw[pos+1:]
+	}
+	l.HW, err = parseHWAddr(hw)
+	if err != nil {
+		return Lease{}, ErrBadHWAddrFormat
+	}
+	return l, nil
+}
+
+// parseHWAddr accepts the addresses of any length, as dnsmasq records them.
+// The clients without one (e.g. known by client-id only) are recorded as "00-", which gives nil.
+func parseHWAddr(s string) (net.HardwareAddr, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var hw net.HardwareAddr
+	for _, b := range strings.Split(s, ":") {
+		v, err := strconv.ParseUint(b, 16, 8)
+		if err != nil || len(b) > 2 {
+			return nil, ErrBadHWAddrFormat
+		}
+		hw = append(hw, byte(v))
+	}
+	return hw, nil
+}
+
+// Leases is the content of a leases file
+type Leases struct {
+	// ServerDUID is the DUID of the dnsmasq DHCPv6 server, empty if it serves no DHCPv6 leases
+	ServerDUID string
+	leases     []Lease
+}
+
+func (ls *Leases) Len() int {
+	return len(ls.leases)
+}
+
+// Leases returns a copy of all the leases, in file order
+func (ls *Leases) Leases() []Lease {
+	return append([]Lease{}, ls.leases...)
+}
+
+// Active returns the leases which did not expire at the given time
+func (ls *Leases) Active(now time.Time) []Lease {
+	var ret []Lease
+	for _, l := range ls.leases {
+		if !l.Expired(now) {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+func (ls *Leases) String() string {
+	var sb strings.Builder
+	for _, l := range ls.leases {
+		if !l.IsV6() {
+			fmt.Fprintf(&sb, "%s\n", l)
+		}
+	}
+	if ls.ServerDUID != "" {
+		fmt.Fprintf(&sb, "%s %s\n", duidMarker, ls.ServerDUID)
+	}
+	for _, l := range ls.leases {
+		if l.IsV6() {
+			fmt.Fprintf(&sb, "%s\n", l)
+		}
+	}
+	return sb.String()
+}
+
+// Parse reads a leases file. Blank lines are skipped.
+func Parse(r io.Reader) (*Leases, error) {
+	ls := Leases{}
+	v6 := false
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		if items := strings.Fields(s); items[0] == duidMarker {
+			if len(items) != 2 {
+				return nil, fmt.Errorf("line %d: %w", lineno, ErrBadLeaseFormat)
+			}
+			ls.ServerDUID = items[1]
+			v6 = true
+			continue
+		}
+		l, err := ParseLeaseString(s, v6)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		ls.leases = append(ls.leases, l)
+	}
+	return &ls, scanner.Err()
+}
+
+// ParseFile reads the leases file at path. A missing file has no leases,
+// because dnsmasq creates it only when it hands out the first lease.
+func ParseFile(path string) (*Leases, error) {
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Leases{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return Parse(fh)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package dnsleases
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLeases string = "" +
+	"1700003600 52:54:aa:11:bb:22 192.168.1.63 client 01:52:54:aa:11:bb:22\n" +
+	"0 02:00:00:00:00:01 192.168.1.70 * *\n" +
+	"1600000000 20-80:00:02:08:fe:80:00:00:00:00:00:00:00:02:c9:03:00:0a:bb:cc 192.168.1.71 ib *\n" +
+	"duid 00:01:00:01:2a:bb:cc:dd:52:54:00:00:00:01\n" +
+	"1700003600 1193046 fd00::63 client 00:01:00:01:2a:11:22:33:52:54:aa:11:bb:22\n" +
+	"1700003600 T12 fd00::64 * 00:03:00:01:52:54:aa:11:bb:33\n" +
+	""
+
+func TestLeaseParseError(t *testing.T) {
+	cases := []struct {
+		line string
+		v6   bool
+		err  error
+	}{
+		{"", false, ErrBadLeaseFormat},
+		{"1700003600 52:54:aa:11:bb:22 192.168.1.63 client", false, ErrBadLeaseFormat},
+		{"soon 52:54:aa:11:bb:22 192.168.1.63 client *", false, ErrBadExpiry},
+		{"-1 52:54:aa:11:bb:22 192.168.1.63 client *", false, ErrBadExpiry},
+		{"0 52:54:aa:11:bb:zz 192.168.1.63 client *", false, ErrBadHWAddrFormat},
+		{"0 52:54:aa:11:bb:22 192.168.1.300 client *", false, ErrBadIPFormat},
+		{"0 52:54:aa:11:bb:22 fd00::63 client *", false, ErrBadIPFormat},
+		{"0 12 192.168.1.63 client *", true, ErrBadIPFormat},
+		{"0 X12 fd00::63 client *", true, ErrBadIAID},
+	}
+	for _, c := range cases {
+		_, err := ParseLeaseString(c.line, c.v6)
+		if err != c.err {
+			t.Errorf("%q: unexpected error: %v", c.line, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	ls, err := Parse(strings.NewReader(testLeases))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ls.Len() != 5 || ls.ServerDUID != "00:01:00:01:2a:bb:cc:dd:52:54:00:00:00:01" {
+		t.Fatalf("unexpected leases: %v %v", ls.Len(), ls.ServerDUID)
+	}
+	leases := ls.Leases()
+
+	l := leases[0]
+	if l.IsV6() || l.HW.String() != "52:54:aa:11:bb:22" || l.IP.String() != "192.168.1.63" ||
+		l.Hostname != "client" || l.ClientID != "01:52:54:aa:11:bb:22" || l.Expiry.Unix() != 1700003600 {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+	l = leases[1]
+	if !l.Expiry.IsZero() || l.Hostname != "" || l.ClientID != "" {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+	l = leases[2]
+	if l.HWType != 0x20 || len(l.HW) != 20 {
+		t.Errorf("unexpected infiniband lease: %+v", l)
+	}
+	l = leases[3]
+	if !l.IsV6() || l.IAID != 1193046 || l.Temporary || l.IP.String() != "fd00::63" ||
+		l.ClientID != "00:01:00:01:2a:11:22:33:52:54:aa:11:bb:22" || l.HW != nil {
+		t.Errorf("unexpected v6 lease: %+v", l)
+	}
+	l = leases[4]
+	if !l.Temporary || l.IAID != 12 {
+		t.Errorf("unexpected temporary lease: %+v", l)
+	}
+
+	if ls.String() != testLeases {
+		t.Errorf("unexpected roundtrip:\n%s", ls.String())
+	}
+
+	active := ls.Active(time.Unix(1700000000, 0))
+	if len(active) != 4 {
+		t.Errorf("unexpected active leases: %v", active)
+	}
+	if len(ls.Active(time.Unix(1800000000, 0))) != 1 {
+		t.Errorf("only the infinite lease should be active")
+	}
+}
+
+func TestParseNoHWAddr(t *testing.T) {
+	s := "1700003600 00- 192.168.1.72 * 01:02:03"
+	l, err := ParseLeaseString(s, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.HW != nil || l.HWType != 0 || l.ClientID != "01:02:03" {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+	if l.String() != s {
+		t.Errorf("unexpected roundtrip: %s", l)
+	}
+}
+
+func TestParseErrorLine(t *testing.T) {
+	_, err := Parse(strings.NewReader("0 02:00:00:00:00:01 192.168.1.70 * *\nduid\n"))
+	if !errors.Is(err, ErrBadLeaseFormat) || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the v6 leases must follow the duid line
+	_, err = Parse(strings.NewReader("0 12 fd00::63 client *\n"))
+	if !errors.Is(err, ErrBadIPFormat) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseFileMissing(t *testing.T) {
+	ls, err := ParseFile(filepath.Join(t.TempDir(), "dnsmasq.leases"))
+	if err != nil || ls.Len() != 0 {
+		t.Errorf("unexpected result for a missing file: %v %v", ls, err)
+	}
+}
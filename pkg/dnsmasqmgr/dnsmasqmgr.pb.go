@@ -28,18 +28,22 @@ const (
 	Key_HOSTNAME Key = 0
 	Key_MACADDR  Key = 1
 	Key_IPADDR   Key = 2
+	// the alias is the first item of Address.aliases
+	Key_ALIAS Key = 3
 )
 
 var Key_name = map[int32]string{
 	0: "HOSTNAME",
 	1: "MACADDR",
 	2: "IPADDR",
+	3: "ALIAS",
 }
 
 var Key_value = map[string]int32{
 	"HOSTNAME": 0,
 	"MACADDR":  1,
 	"IPADDR":   2,
+	"ALIAS":    3,
 }
 
 func (x Key) String() string {
@@ -85,6 +89,10 @@ const (
 	Error_NOTFOUND  Error = 1
 	Error_DUPLICATE Error = 2
 	Error_MISMATCH  Error = 3
+	// the watcher fell behind the changes and must resume from the last revision it got
+	Error_LAGGING Error = 4
+	// the request conflicts with edits made outside dnsmasqmgrd, not merged yet
+	Error_CONFLICT Error = 5
 )
 
 var Error_name = map[int32]string{
@@ -92,6 +100,8 @@ var Error_name = map[int32]string{
 	1: "NOTFOUND",
 	2: "DUPLICATE",
 	3: "MISMATCH",
+	4: "LAGGING",
+	5: "CONFLICT",
 }
 
 var Error_value = map[string]int32{
@@ -99,6 +109,8 @@ var Error_value = map[string]int32{
 	"NOTFOUND":  1,
 	"DUPLICATE": 2,
 	"MISMATCH":  3,
+	"LAGGING":   4,
+	"CONFLICT":  5,
 }
 
 func (x Error) String() string {
@@ -109,10 +121,164 @@ func (Error) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_b3815698c51f4a73, []int{2}
 }
 
+type Action int32
+
+const (
+	Action_ADD    Action = 0
+	Action_DELETE Action = 1
+	Action_UPDATE Action = 2
+	// the managed files were changed outside dnsmasqmgrd: addr is empty, watchers should list the entries again
+	Action_EXTERNAL Action = 3
+	// Check fixed the problems found in the managed files: addr is empty, as for EXTERNAL
+	Action_FIX Action = 4
+)
+
+var Action_name = map[int32]string{
+	0: "ADD",
+	1: "DELETE",
+	2: "UPDATE",
+	3: "EXTERNAL",
+	4: "FIX",
+}
+
+var Action_value = map[string]int32{
+	"ADD":      0,
+	"DELETE":   1,
+	"UPDATE":   2,
+	"EXTERNAL": 3,
+	"FIX":      4,
+}
+
+func (x Action) String() string {
+	return proto.EnumName(Action_name, int32(x))
+}
+
+func (Action) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{3}
+}
+
+// the inconsistencies between the managed files Check looks for
+type Problem int32
+
+const (
+	// a dhcp-host line binding addresses no hosts line names, and giving no hostname
+	Problem_ORPHAN_BINDING Problem = 0
+	// a hosts line naming an address of a pool no dhcp-host line binds
+	Problem_ORPHAN_HOST Problem = 1
+	// a hosts or a dhcp-host line about an address out of all the pools, but the loopback ones
+	Problem_OUT_OF_RANGE Problem = 2
+	// an address bound by the dhcp-host lines of different clients
+	Problem_SHARED_ADDRESS Problem = 3
+	// a name given by hosts lines to different addresses of the same family
+	Problem_NAME_CONFLICT Problem = 4
+)
+
+var Problem_name = map[int32]string{
+	0: "ORPHAN_BINDING",
+	1: "ORPHAN_HOST",
+	2: "OUT_OF_RANGE",
+	3: "SHARED_ADDRESS",
+	4: "NAME_CONFLICT",
+}
+
+var Problem_value = map[string]int32{
+	"ORPHAN_BINDING": 0,
+	"ORPHAN_HOST":    1,
+	"OUT_OF_RANGE":   2,
+	"SHARED_ADDRESS": 3,
+	"NAME_CONFLICT":  4,
+}
+
+func (x Problem) String() string {
+	return proto.EnumName(Problem_name, int32(x))
+}
+
+func (Problem) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{4}
+}
+
+// how Check fixes the problems found. The orphan lines are dropped, and the out of range ones
+// only if OUT_OF_RANGE is among the problems requested, because they may be static entries;
+// of the lines sharing an address or a name, only the first, or the last, is kept.
+type Resolution int32
+
+const (
+	Resolution_REPORT     Resolution = 0
+	Resolution_KEEP_FIRST Resolution = 1
+	Resolution_KEEP_LAST  Resolution = 2
+)
+
+var Resolution_name = map[int32]string{
+	0: "REPORT",
+	1: "KEEP_FIRST",
+	2: "KEEP_LAST",
+}
+
+var Resolution_value = map[string]int32{
+	"REPORT":     0,
+	"KEEP_FIRST": 1,
+	"KEEP_LAST":  2,
+}
+
+func (x Resolution) String() string {
+	return proto.EnumName(Resolution_name, int32(x))
+}
+
+func (Resolution) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{5}
+}
+
+// ErrorDetail is attached to the errors sharing a gRPC code with others, to tell them apart
+type ErrorDetail struct {
+	Error                Error    `protobuf:"varint,1,opt,name=error,proto3,enum=dnsmasqmgr.Error" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ErrorDetail) Reset()         { *m = ErrorDetail{} }
+func (m *ErrorDetail) String() string { return proto.CompactTextString(m) }
+func (*ErrorDetail) ProtoMessage()    {}
+func (*ErrorDetail) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{0}
+}
+
+func (m *ErrorDetail) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ErrorDetail.Unmarshal(m, b)
+}
+func (m *ErrorDetail) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ErrorDetail.Marshal(b, m, deterministic)
+}
+func (m *ErrorDetail) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ErrorDetail.Merge(m, src)
+}
+func (m *ErrorDetail) XXX_Size() int {
+	return xxx_messageInfo_ErrorDetail.Size(m)
+}
+func (m *ErrorDetail) XXX_DiscardUnknown() {
+	xxx_messageInfo_ErrorDetail.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ErrorDetail proto.InternalMessageInfo
+
+func (m *ErrorDetail) GetError() Error {
+	if m != nil {
+		return m.Error
+	}
+	return Error_SUCCESS
+}
+
 type Address struct {
-	Hostname             string   `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
-	Macaddr              string   `protobuf:"bytes,2,opt,name=macaddr,proto3" json:"macaddr,omitempty"`
-	Ipaddr               string   `protobuf:"bytes,3,opt,name=ipaddr,proto3" json:"ipaddr,omitempty"`
+	Hostname string   `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Macaddr  string   `protobuf:"bytes,2,opt,name=macaddr,proto3" json:"macaddr,omitempty"`
+	Ipaddr   string   `protobuf:"bytes,3,opt,name=ipaddr,proto3" json:"ipaddr,omitempty"`
+	Aliases  []string `protobuf:"bytes,4,rep,name=aliases,proto3" json:"aliases,omitempty"`
+	// ipaddr is the IPv4 address, ip6addr the IPv6 address. Either may be empty.
+	Ip6Addr string `protobuf:"bytes,5,opt,name=ip6addr,proto3" json:"ip6addr,omitempty"`
+	// DHCP client identifier (dhcp-host "id:"), without the prefix
+	Clientid string `protobuf:"bytes,6,opt,name=clientid,proto3" json:"clientid,omitempty"`
+	// dnsmasq tags set by the dhcp-host line (set:<tag>)
+	Tags                 []string `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -122,7 +288,7 @@ func (m *Address) Reset()         { *m = Address{} }
 func (m *Address) String() string { return proto.CompactTextString(m) }
 func (*Address) ProtoMessage()    {}
 func (*Address) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b3815698c51f4a73, []int{0}
+	return fileDescriptor_b3815698c51f4a73, []int{1}
 }
 
 func (m *Address) XXX_Unmarshal(b []byte) error {
@@ -164,9 +330,40 @@ func (m *Address) GetIpaddr() string {
 	return ""
 }
 
+func (m *Address) GetAliases() []string {
+	if m != nil {
+		return m.Aliases
+	}
+	return nil
+}
+
+func (m *Address) GetIp6Addr() string {
+	if m != nil {
+		return m.Ip6Addr
+	}
+	return ""
+}
+
+func (m *Address) GetClientid() string {
+	if m != nil {
+		return m.Clientid
+	}
+	return ""
+}
+
+func (m *Address) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+// RequestAddress takes the addresses from the named pool. If pool is empty, the pool is the first one
+// matching the hardware address, then the tags, then the requested IPv4 address; the default pool otherwise.
 type AddressRequest struct {
 	Key                  Key      `protobuf:"varint,1,opt,name=key,proto3,enum=dnsmasqmgr.Key" json:"key,omitempty"`
 	Addr                 *Address `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	Pool                 string   `protobuf:"bytes,3,opt,name=pool,proto3" json:"pool,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -176,7 +373,7 @@ func (m *AddressRequest) Reset()         { *m = AddressRequest{} }
 func (m *AddressRequest) String() string { return proto.CompactTextString(m) }
 func (*AddressRequest) ProtoMessage()    {}
 func (*AddressRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b3815698c51f4a73, []int{1}
+	return fileDescriptor_b3815698c51f4a73, []int{2}
 }
 
 func (m *AddressRequest) XXX_Unmarshal(b []byte) error {
@@ -211,6 +408,13 @@ func (m *AddressRequest) GetAddr() *Address {
 	return nil
 }
 
+func (m *AddressRequest) GetPool() string {
+	if m != nil {
+		return m.Pool
+	}
+	return ""
+}
+
 type AddressReply struct {
 	Key                  Key      `protobuf:"varint,1,opt,name=key,proto3,enum=dnsmasqmgr.Key" json:"key,omitempty"`
 	Match                Match    `protobuf:"varint,2,opt,name=match,proto3,enum=dnsmasqmgr.Match" json:"match,omitempty"`
@@ -224,7 +428,7 @@ func (m *AddressReply) Reset()         { *m = AddressReply{} }
 func (m *AddressReply) String() string { return proto.CompactTextString(m) }
 func (*AddressReply) ProtoMessage()    {}
 func (*AddressReply) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b3815698c51f4a73, []int{2}
+	return fileDescriptor_b3815698c51f4a73, []int{3}
 }
 
 func (m *AddressReply) XXX_Unmarshal(b []byte) error {
@@ -266,150 +470,1381 @@ func (m *AddressReply) GetAddr() *Address {
 	return nil
 }
 
-func init() {
-	proto.RegisterEnum("dnsmasqmgr.Key", Key_name, Key_value)
-	proto.RegisterEnum("dnsmasqmgr.Match", Match_name, Match_value)
-	proto.RegisterEnum("dnsmasqmgr.Error", Error_name, Error_value)
-	proto.RegisterType((*Address)(nil), "dnsmasqmgr.Address")
-	proto.RegisterType((*AddressRequest)(nil), "dnsmasqmgr.AddressRequest")
-	proto.RegisterType((*AddressReply)(nil), "dnsmasqmgr.AddressReply")
+// key and current.<key> select the entry to update.
+// The other non-empty fields of current must match the entry, otherwise the update is aborted.
+// The non-empty fields of updated replace the values of the entry.
+type UpdateRequest struct {
+	Key                  Key      `protobuf:"varint,1,opt,name=key,proto3,enum=dnsmasqmgr.Key" json:"key,omitempty"`
+	Current              *Address `protobuf:"bytes,2,opt,name=current,proto3" json:"current,omitempty"`
+	Updated              *Address `protobuf:"bytes,3,opt,name=updated,proto3" json:"updated,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func init() { proto.RegisterFile("dnsmasqmgr.proto", fileDescriptor_b3815698c51f4a73) }
+func (m *UpdateRequest) Reset()         { *m = UpdateRequest{} }
+func (m *UpdateRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateRequest) ProtoMessage()    {}
+func (*UpdateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{4}
+}
 
-var fileDescriptor_b3815698c51f4a73 = []byte{
-	// 413 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x93, 0xdf, 0x6a, 0xdb, 0x30,
-	0x14, 0x87, 0xa3, 0xb8, 0xf9, 0xd3, 0xd3, 0xd6, 0xd5, 0x34, 0x18, 0xa1, 0x30, 0xd8, 0x72, 0xb3,
-	0x12, 0x46, 0x2e, 0xbc, 0x07, 0x18, 0xaa, 0x9d, 0x2e, 0xa1, 0xb6, 0x63, 0x6c, 0x87, 0xdd, 0xec,
-	0x46, 0x8d, 0x85, 0xd3, 0x35, 0x8e, 0x1c, 0xc9, 0x19, 0xf8, 0x7a, 0xaf, 0xbb, 0x87, 0x18, 0x56,
-	0x1d, 0xcf, 0x17, 0x85, 0x0d, 0xd6, 0x3b, 0x1f, 0x7d, 0x3f, 0xbe, 0x73, 0x2c, 0x71, 0x00, 0x27,
-	0x3b, 0x95, 0x31, 0xb5, 0xcf, 0x52, 0x39, 0xcd, 0xa5, 0x28, 0x04, 0x81, 0x3f, 0x27, 0xe3, 0xaf,
-	0x30, 0xa0, 0x49, 0x22, 0xb9, 0x52, 0xe4, 0x0a, 0x86, 0x1b, 0xa1, 0x8a, 0x1d, 0xcb, 0xf8, 0x08,
-	0xbd, 0x43, 0xd7, 0xa7, 0x61, 0x53, 0x93, 0x11, 0x0c, 0x32, 0xb6, 0x66, 0x49, 0x22, 0x47, 0x5d,
-	0x8d, 0x8e, 0x25, 0x79, 0x03, 0xfd, 0x87, 0x5c, 0x03, 0x43, 0x83, 0xba, 0x1a, 0x7f, 0x03, 0xb3,
-	0x16, 0x87, 0x7c, 0x7f, 0xe0, 0xaa, 0x20, 0xef, 0xc1, 0x78, 0xe4, 0xa5, 0x56, 0x9b, 0xd6, 0xe5,
-	0xb4, 0x35, 0xd6, 0x1d, 0x2f, 0xc3, 0x8a, 0x91, 0x0f, 0x70, 0xd2, 0xf4, 0x38, 0xb3, 0x5e, 0xb7,
-	0x33, 0x47, 0x99, 0x0e, 0x8c, 0x7f, 0x22, 0x38, 0x6f, 0xf4, 0xf9, 0xb6, 0xfc, 0x37, 0x79, 0x2f,
-	0x63, 0xc5, 0x7a, 0xa3, 0xed, 0xa6, 0xf5, 0xaa, 0x1d, 0xf2, 0x2a, 0x10, 0x3e, 0xf1, 0x66, 0x0a,
-	0xe3, 0x2f, 0x53, 0x4c, 0x3e, 0x82, 0x71, 0xc7, 0x4b, 0x72, 0x0e, 0xc3, 0xf9, 0x32, 0x8a, 0x7d,
-	0xea, 0xcd, 0x70, 0x87, 0x9c, 0xc1, 0xc0, 0xa3, 0x36, 0x75, 0x9c, 0x10, 0x23, 0x02, 0xd0, 0x5f,
-	0x04, 0xfa, 0xbb, 0x3b, 0xb9, 0x86, 0x9e, 0x6e, 0x43, 0x86, 0x70, 0xe2, 0x2f, 0xfd, 0x3a, 0x1b,
-	0xd0, 0x30, 0x5e, 0x50, 0x17, 0xa3, 0xea, 0xf8, 0x76, 0xe5, 0xba, 0xb8, 0x3b, 0xf9, 0x0c, 0xbd,
-	0x99, 0x94, 0x42, 0x56, 0x3c, 0x5a, 0xd9, 0xf6, 0x2c, 0x8a, 0x70, 0xa7, 0x6a, 0xe3, 0x2f, 0xe3,
-	0xdb, 0xe5, 0xca, 0x77, 0x30, 0x22, 0x17, 0x70, 0xea, 0xac, 0x02, 0x77, 0x61, 0xd3, 0x78, 0x86,
-	0xbb, 0x15, 0xf4, 0x16, 0x91, 0x47, 0x63, 0x7b, 0x8e, 0x0d, 0xeb, 0x17, 0x02, 0xd3, 0xf1, 0x23,
-	0x8f, 0xa9, 0xbd, 0xc7, 0x76, 0x2c, 0xe5, 0x92, 0xcc, 0xc1, 0xac, 0x1f, 0xa2, 0x79, 0xef, 0xe7,
-	0x7e, 0xec, 0x29, 0x72, 0x35, 0x7a, 0x96, 0xe5, 0xdb, 0x72, 0xdc, 0x21, 0x5f, 0xe0, 0xc2, 0xe1,
-	0x5b, 0x5e, 0xf0, 0x17, 0x10, 0xb9, 0x42, 0x3c, 0x1e, 0xf2, 0xff, 0x14, 0xdd, 0x58, 0xf0, 0x76,
-	0x2d, 0xb2, 0x69, 0xfa, 0x50, 0x6c, 0x0e, 0xf7, 0xd3, 0x4c, 0x7c, 0x67, 0x3f, 0xb8, 0x6a, 0xe5,
-	0x6f, 0x2e, 0x8f, 0x97, 0x91, 0xca, 0xa0, 0x5a, 0x81, 0x00, 0xdd, 0xf7, 0xf5, 0x2e, 0x7c, 0xfa,
-	0x1d, 0x00, 0x00, 0xff, 0xff, 0xe9, 0xfc, 0x94, 0x95, 0x1f, 0x03, 0x00, 0x00,
+func (m *UpdateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateRequest.Unmarshal(m, b)
+}
+func (m *UpdateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateRequest.Marshal(b, m, deterministic)
+}
+func (m *UpdateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateRequest.Merge(m, src)
+}
+func (m *UpdateRequest) XXX_Size() int {
+	return xxx_messageInfo_UpdateRequest.Size(m)
+}
+func (m *UpdateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateRequest.DiscardUnknown(m)
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
+var xxx_messageInfo_UpdateRequest proto.InternalMessageInfo
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+func (m *UpdateRequest) GetKey() Key {
+	if m != nil {
+		return m.Key
+	}
+	return Key_HOSTNAME
+}
 
-// DNSMasqManagerClient is the client API for DNSMasqManager service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type DNSMasqManagerClient interface {
-	RequestAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error)
-	DeleteAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error)
-	LookupAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error)
+func (m *UpdateRequest) GetCurrent() *Address {
+	if m != nil {
+		return m.Current
+	}
+	return nil
 }
 
-type dNSMasqManagerClient struct {
-	cc *grpc.ClientConn
+func (m *UpdateRequest) GetUpdated() *Address {
+	if m != nil {
+		return m.Updated
+	}
+	return nil
 }
 
-func NewDNSMasqManagerClient(cc *grpc.ClientConn) DNSMasqManagerClient {
-	return &dNSMasqManagerClient{cc}
+// key and addr select the entry, as in AddressRequest.
+type AliasRequest struct {
+	Key                  Key      `protobuf:"varint,1,opt,name=key,proto3,enum=dnsmasqmgr.Key" json:"key,omitempty"`
+	Addr                 *Address `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	Aliases              []string `protobuf:"bytes,3,rep,name=aliases,proto3" json:"aliases,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (c *dNSMasqManagerClient) RequestAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error) {
-	out := new(AddressReply)
-	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/RequestAddress", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *AliasRequest) Reset()         { *m = AliasRequest{} }
+func (m *AliasRequest) String() string { return proto.CompactTextString(m) }
+func (*AliasRequest) ProtoMessage()    {}
+func (*AliasRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{5}
+}
+
+func (m *AliasRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AliasRequest.Unmarshal(m, b)
+}
+func (m *AliasRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AliasRequest.Marshal(b, m, deterministic)
+}
+func (m *AliasRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AliasRequest.Merge(m, src)
+}
+func (m *AliasRequest) XXX_Size() int {
+	return xxx_messageInfo_AliasRequest.Size(m)
+}
+func (m *AliasRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AliasRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AliasRequest proto.InternalMessageInfo
+
+func (m *AliasRequest) GetKey() Key {
+	if m != nil {
+		return m.Key
 	}
-	return out, nil
+	return Key_HOSTNAME
 }
 
-func (c *dNSMasqManagerClient) DeleteAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error) {
-	out := new(AddressReply)
-	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/DeleteAddress", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *AliasRequest) GetAddr() *Address {
+	if m != nil {
+		return m.Addr
 	}
-	return out, nil
+	return nil
 }
 
-func (c *dNSMasqManagerClient) LookupAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error) {
-	out := new(AddressReply)
-	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/LookupAddress", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *AliasRequest) GetAliases() []string {
+	if m != nil {
+		return m.Aliases
 	}
-	return out, nil
+	return nil
 }
 
-// DNSMasqManagerServer is the server API for DNSMasqManager service.
-type DNSMasqManagerServer interface {
-	RequestAddress(context.Context, *AddressRequest) (*AddressReply, error)
-	DeleteAddress(context.Context, *AddressRequest) (*AddressReply, error)
-	LookupAddress(context.Context, *AddressRequest) (*AddressReply, error)
+type StatusRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func RegisterDNSMasqManagerServer(s *grpc.Server, srv DNSMasqManagerServer) {
-	s.RegisterService(&_DNSMasqManager_serviceDesc, srv)
+func (m *StatusRequest) Reset()         { *m = StatusRequest{} }
+func (m *StatusRequest) String() string { return proto.CompactTextString(m) }
+func (*StatusRequest) ProtoMessage()    {}
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{6}
 }
 
-func _DNSMasqManager_RequestAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(AddressRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *StatusRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StatusRequest.Unmarshal(m, b)
+}
+func (m *StatusRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StatusRequest.Marshal(b, m, deterministic)
+}
+func (m *StatusRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusRequest.Merge(m, src)
+}
+func (m *StatusRequest) XXX_Size() int {
+	return xxx_messageInfo_StatusRequest.Size(m)
+}
+func (m *StatusRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusRequest proto.InternalMessageInfo
+
+type Pool struct {
+	Name      string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Range     string `protobuf:"bytes,2,opt,name=range,proto3" json:"range,omitempty"`
+	Total     int64  `protobuf:"varint,3,opt,name=total,proto3" json:"total,omitempty"`
+	Remaining int64  `protobuf:"varint,4,opt,name=remaining,proto3" json:"remaining,omitempty"`
+	Subnet    string `protobuf:"bytes,5,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	Domain    string `protobuf:"bytes,6,opt,name=domain,proto3" json:"domain,omitempty"`
+	// the IPv6 range, if any
+	Range6               string   `protobuf:"bytes,7,opt,name=range6,proto3" json:"range6,omitempty"`
+	Total6               int64    `protobuf:"varint,8,opt,name=total6,proto3" json:"total6,omitempty"`
+	Remaining6           int64    `protobuf:"varint,9,opt,name=remaining6,proto3" json:"remaining6,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Pool) Reset()         { *m = Pool{} }
+func (m *Pool) String() string { return proto.CompactTextString(m) }
+func (*Pool) ProtoMessage()    {}
+func (*Pool) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{7}
+}
+
+func (m *Pool) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Pool.Unmarshal(m, b)
+}
+func (m *Pool) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Pool.Marshal(b, m, deterministic)
+}
+func (m *Pool) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Pool.Merge(m, src)
+}
+func (m *Pool) XXX_Size() int {
+	return xxx_messageInfo_Pool.Size(m)
+}
+func (m *Pool) XXX_DiscardUnknown() {
+	xxx_messageInfo_Pool.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Pool proto.InternalMessageInfo
+
+func (m *Pool) GetName() string {
+	if m != nil {
+		return m.Name
 	}
-	if interceptor == nil {
-		return srv.(DNSMasqManagerServer).RequestAddress(ctx, in)
+	return ""
+}
+
+func (m *Pool) GetRange() string {
+	if m != nil {
+		return m.Range
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/dnsmasqmgr.DNSMasqManager/RequestAddress",
+	return ""
+}
+
+func (m *Pool) GetTotal() int64 {
+	if m != nil {
+		return m.Total
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(DNSMasqManagerServer).RequestAddress(ctx, req.(*AddressRequest))
+	return 0
+}
+
+func (m *Pool) GetRemaining() int64 {
+	if m != nil {
+		return m.Remaining
 	}
-	return interceptor(ctx, in, info, handler)
+	return 0
 }
 
-func _DNSMasqManager_DeleteAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(AddressRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *Pool) GetSubnet() string {
+	if m != nil {
+		return m.Subnet
 	}
-	if interceptor == nil {
-		return srv.(DNSMasqManagerServer).DeleteAddress(ctx, in)
+	return ""
+}
+
+func (m *Pool) GetDomain() string {
+	if m != nil {
+		return m.Domain
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/dnsmasqmgr.DNSMasqManager/DeleteAddress",
+	return ""
+}
+
+func (m *Pool) GetRange6() string {
+	if m != nil {
+		return m.Range6
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(DNSMasqManagerServer).DeleteAddress(ctx, req.(*AddressRequest))
+	return ""
+}
+
+func (m *Pool) GetTotal6() int64 {
+	if m != nil {
+		return m.Total6
 	}
-	return interceptor(ctx, in, info, handler)
+	return 0
 }
 
-func _DNSMasqManager_LookupAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(AddressRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *Pool) GetRemaining6() int64 {
+	if m != nil {
+		return m.Remaining6
+	}
+	return 0
+}
+
+type StatusReply struct {
+	Pools    []*Pool `protobuf:"bytes,1,rep,name=pools,proto3" json:"pools,omitempty"`
+	Readonly bool    `protobuf:"varint,2,opt,name=readonly,proto3" json:"readonly,omitempty"`
+	// set while dnsmasqmgrd refuses to change the managed files, e.g. because they were edited outside of it
+	Alert                string   `protobuf:"bytes,3,opt,name=alert,proto3" json:"alert,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StatusReply) Reset()         { *m = StatusReply{} }
+func (m *StatusReply) String() string { return proto.CompactTextString(m) }
+func (*StatusReply) ProtoMessage()    {}
+func (*StatusReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{8}
+}
+
+func (m *StatusReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StatusReply.Unmarshal(m, b)
+}
+func (m *StatusReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StatusReply.Marshal(b, m, deterministic)
+}
+func (m *StatusReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusReply.Merge(m, src)
+}
+func (m *StatusReply) XXX_Size() int {
+	return xxx_messageInfo_StatusReply.Size(m)
+}
+func (m *StatusReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusReply proto.InternalMessageInfo
+
+func (m *StatusReply) GetPools() []*Pool {
+	if m != nil {
+		return m.Pools
+	}
+	return nil
+}
+
+func (m *StatusReply) GetReadonly() bool {
+	if m != nil {
+		return m.Readonly
+	}
+	return false
+}
+
+func (m *StatusReply) GetAlert() string {
+	if m != nil {
+		return m.Alert
+	}
+	return ""
+}
+
+// all the filters are optional and combined in AND.
+// match == NONE means any match.
+type ListRequest struct {
+	HostnameGlob         string   `protobuf:"bytes,1,opt,name=hostname_glob,json=hostnameGlob,proto3" json:"hostname_glob,omitempty"`
+	MacaddrPrefix        string   `protobuf:"bytes,2,opt,name=macaddr_prefix,json=macaddrPrefix,proto3" json:"macaddr_prefix,omitempty"`
+	Subnet               string   `protobuf:"bytes,3,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	Match                Match    `protobuf:"varint,4,opt,name=match,proto3,enum=dnsmasqmgr.Match" json:"match,omitempty"`
+	PageSize             int32    `protobuf:"varint,5,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken            string   `protobuf:"bytes,6,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListRequest) Reset()         { *m = ListRequest{} }
+func (m *ListRequest) String() string { return proto.CompactTextString(m) }
+func (*ListRequest) ProtoMessage()    {}
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{9}
+}
+
+func (m *ListRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRequest.Unmarshal(m, b)
+}
+func (m *ListRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRequest.Marshal(b, m, deterministic)
+}
+func (m *ListRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRequest.Merge(m, src)
+}
+func (m *ListRequest) XXX_Size() int {
+	return xxx_messageInfo_ListRequest.Size(m)
+}
+func (m *ListRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListRequest proto.InternalMessageInfo
+
+func (m *ListRequest) GetHostnameGlob() string {
+	if m != nil {
+		return m.HostnameGlob
+	}
+	return ""
+}
+
+func (m *ListRequest) GetMacaddrPrefix() string {
+	if m != nil {
+		return m.MacaddrPrefix
+	}
+	return ""
+}
+
+func (m *ListRequest) GetSubnet() string {
+	if m != nil {
+		return m.Subnet
+	}
+	return ""
+}
+
+func (m *ListRequest) GetMatch() Match {
+	if m != nil {
+		return m.Match
+	}
+	return Match_NONE
+}
+
+func (m *ListRequest) GetPageSize() int32 {
+	if m != nil {
+		return m.PageSize
+	}
+	return 0
+}
+
+func (m *ListRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
+	}
+	return ""
+}
+
+type ListReply struct {
+	Addrs                []*AddressReply `protobuf:"bytes,1,rep,name=addrs,proto3" json:"addrs,omitempty"`
+	NextPageToken        string          `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *ListReply) Reset()         { *m = ListReply{} }
+func (m *ListReply) String() string { return proto.CompactTextString(m) }
+func (*ListReply) ProtoMessage()    {}
+func (*ListReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{10}
+}
+
+func (m *ListReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListReply.Unmarshal(m, b)
+}
+func (m *ListReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListReply.Marshal(b, m, deterministic)
+}
+func (m *ListReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListReply.Merge(m, src)
+}
+func (m *ListReply) XXX_Size() int {
+	return xxx_messageInfo_ListReply.Size(m)
+}
+func (m *ListReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListReply proto.InternalMessageInfo
+
+func (m *ListReply) GetAddrs() []*AddressReply {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *ListReply) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
+	}
+	return ""
+}
+
+// from_revision == 0 means only the changes happening from now on.
+// Otherwise, the changes after from_revision are sent first, if still available:
+// the past changes are kept in memory only, not read back from the journal.
+type WatchRequest struct {
+	FromRevision         int64    `protobuf:"varint,1,opt,name=from_revision,json=fromRevision,proto3" json:"from_revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
+func (m *WatchRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchRequest) ProtoMessage()    {}
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{11}
+}
+
+func (m *WatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchRequest.Unmarshal(m, b)
+}
+func (m *WatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchRequest.Merge(m, src)
+}
+func (m *WatchRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchRequest.Size(m)
+}
+func (m *WatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchRequest proto.InternalMessageInfo
+
+func (m *WatchRequest) GetFromRevision() int64 {
+	if m != nil {
+		return m.FromRevision
+	}
+	return 0
+}
+
+type Event struct {
+	Revision int64    `protobuf:"varint,1,opt,name=revision,proto3" json:"revision,omitempty"`
+	Action   Action   `protobuf:"varint,2,opt,name=action,proto3,enum=dnsmasqmgr.Action" json:"action,omitempty"`
+	Addr     *Address `protobuf:"bytes,3,opt,name=addr,proto3" json:"addr,omitempty"`
+	// set only for UPDATE
+	Previous *Address `protobuf:"bytes,4,opt,name=previous,proto3" json:"previous,omitempty"`
+	// seconds since the epoch
+	Timestamp            int64    `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+func (*Event) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{12}
+}
+
+func (m *Event) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Event.Unmarshal(m, b)
+}
+func (m *Event) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Event.Marshal(b, m, deterministic)
+}
+func (m *Event) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Event.Merge(m, src)
+}
+func (m *Event) XXX_Size() int {
+	return xxx_messageInfo_Event.Size(m)
+}
+func (m *Event) XXX_DiscardUnknown() {
+	xxx_messageInfo_Event.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Event proto.InternalMessageInfo
+
+func (m *Event) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *Event) GetAction() Action {
+	if m != nil {
+		return m.Action
+	}
+	return Action_ADD
+}
+
+func (m *Event) GetAddr() *Address {
+	if m != nil {
+		return m.Addr
+	}
+	return nil
+}
+
+func (m *Event) GetPrevious() *Address {
+	if m != nil {
+		return m.Previous
+	}
+	return nil
+}
+
+func (m *Event) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+// expired == true includes the leases which already ended
+type LeasesRequest struct {
+	Expired              bool     `protobuf:"varint,1,opt,name=expired,proto3" json:"expired,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeasesRequest) Reset()         { *m = LeasesRequest{} }
+func (m *LeasesRequest) String() string { return proto.CompactTextString(m) }
+func (*LeasesRequest) ProtoMessage()    {}
+func (*LeasesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{13}
+}
+
+func (m *LeasesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeasesRequest.Unmarshal(m, b)
+}
+func (m *LeasesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeasesRequest.Marshal(b, m, deterministic)
+}
+func (m *LeasesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeasesRequest.Merge(m, src)
+}
+func (m *LeasesRequest) XXX_Size() int {
+	return xxx_messageInfo_LeasesRequest.Size(m)
+}
+func (m *LeasesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeasesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeasesRequest proto.InternalMessageInfo
+
+func (m *LeasesRequest) GetExpired() bool {
+	if m != nil {
+		return m.Expired
+	}
+	return false
+}
+
+// a lease dnsmasq handed out, as recorded in its leases file
+type Lease struct {
+	// seconds since the epoch, 0 means never
+	Expiry int64 `protobuf:"varint,1,opt,name=expiry,proto3" json:"expiry,omitempty"`
+	// empty for DHCPv6 leases
+	Macaddr  string `protobuf:"bytes,2,opt,name=macaddr,proto3" json:"macaddr,omitempty"`
+	Ipaddr   string `protobuf:"bytes,3,opt,name=ipaddr,proto3" json:"ipaddr,omitempty"`
+	Hostname string `protobuf:"bytes,4,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	// DHCP client identifier, or the DUID of DHCPv6 clients
+	Clientid string `protobuf:"bytes,5,opt,name=clientid,proto3" json:"clientid,omitempty"`
+	// set only for DHCPv6 leases
+	Iaid                 uint32   `protobuf:"varint,6,opt,name=iaid,proto3" json:"iaid,omitempty"`
+	Temporary            bool     `protobuf:"varint,7,opt,name=temporary,proto3" json:"temporary,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Lease) Reset()         { *m = Lease{} }
+func (m *Lease) String() string { return proto.CompactTextString(m) }
+func (*Lease) ProtoMessage()    {}
+func (*Lease) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{14}
+}
+
+func (m *Lease) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Lease.Unmarshal(m, b)
+}
+func (m *Lease) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Lease.Marshal(b, m, deterministic)
+}
+func (m *Lease) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Lease.Merge(m, src)
+}
+func (m *Lease) XXX_Size() int {
+	return xxx_messageInfo_Lease.Size(m)
+}
+func (m *Lease) XXX_DiscardUnknown() {
+	xxx_messageInfo_Lease.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Lease proto.InternalMessageInfo
+
+func (m *Lease) GetExpiry() int64 {
+	if m != nil {
+		return m.Expiry
+	}
+	return 0
+}
+
+func (m *Lease) GetMacaddr() string {
+	if m != nil {
+		return m.Macaddr
+	}
+	return ""
+}
+
+func (m *Lease) GetIpaddr() string {
+	if m != nil {
+		return m.Ipaddr
+	}
+	return ""
+}
+
+func (m *Lease) GetHostname() string {
+	if m != nil {
+		return m.Hostname
+	}
+	return ""
+}
+
+func (m *Lease) GetClientid() string {
+	if m != nil {
+		return m.Clientid
+	}
+	return ""
+}
+
+func (m *Lease) GetIaid() uint32 {
+	if m != nil {
+		return m.Iaid
+	}
+	return 0
+}
+
+func (m *Lease) GetTemporary() bool {
+	if m != nil {
+		return m.Temporary
+	}
+	return false
+}
+
+// a dynamic lease next to the static binding of the same client, either may be missing
+type LeaseEntry struct {
+	Lease                *Lease   `protobuf:"bytes,1,opt,name=lease,proto3" json:"lease,omitempty"`
+	Static               *Address `protobuf:"bytes,2,opt,name=static,proto3" json:"static,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseEntry) Reset()         { *m = LeaseEntry{} }
+func (m *LeaseEntry) String() string { return proto.CompactTextString(m) }
+func (*LeaseEntry) ProtoMessage()    {}
+func (*LeaseEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{15}
+}
+
+func (m *LeaseEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseEntry.Unmarshal(m, b)
+}
+func (m *LeaseEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseEntry.Marshal(b, m, deterministic)
+}
+func (m *LeaseEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseEntry.Merge(m, src)
+}
+func (m *LeaseEntry) XXX_Size() int {
+	return xxx_messageInfo_LeaseEntry.Size(m)
+}
+func (m *LeaseEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseEntry proto.InternalMessageInfo
+
+func (m *LeaseEntry) GetLease() *Lease {
+	if m != nil {
+		return m.Lease
+	}
+	return nil
+}
+
+func (m *LeaseEntry) GetStatic() *Address {
+	if m != nil {
+		return m.Static
+	}
+	return nil
+}
+
+type LeasesReply struct {
+	Entries []*LeaseEntry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	// the DUID of the DHCPv6 server, if any
+	ServerDuid           string   `protobuf:"bytes,2,opt,name=server_duid,json=serverDuid,proto3" json:"server_duid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeasesReply) Reset()         { *m = LeasesReply{} }
+func (m *LeasesReply) String() string { return proto.CompactTextString(m) }
+func (*LeasesReply) ProtoMessage()    {}
+func (*LeasesReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{16}
+}
+
+func (m *LeasesReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeasesReply.Unmarshal(m, b)
+}
+func (m *LeasesReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeasesReply.Marshal(b, m, deterministic)
+}
+func (m *LeasesReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeasesReply.Merge(m, src)
+}
+func (m *LeasesReply) XXX_Size() int {
+	return xxx_messageInfo_LeasesReply.Size(m)
+}
+func (m *LeasesReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeasesReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeasesReply proto.InternalMessageInfo
+
+func (m *LeasesReply) GetEntries() []*LeaseEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *LeasesReply) GetServerDuid() string {
+	if m != nil {
+		return m.ServerDuid
+	}
+	return ""
+}
+
+// turns the active lease selected by key and addr into a static entry.
+// key: MACADDR matches addr.macaddr or addr.clientid, IPADDR addr.ipaddr or addr.ip6addr, HOSTNAME addr.hostname.
+// The address of the lease is kept if it is in a pool, otherwise a new one is taken from the pool,
+// chosen as for AddressRequest.
+type PinRequest struct {
+	Key  Key      `protobuf:"varint,1,opt,name=key,proto3,enum=dnsmasqmgr.Key" json:"key,omitempty"`
+	Addr *Address `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	// the hostname of the new entry, defaults to the one of the lease
+	Hostname             string   `protobuf:"bytes,3,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Pool                 string   `protobuf:"bytes,4,opt,name=pool,proto3" json:"pool,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PinRequest) Reset()         { *m = PinRequest{} }
+func (m *PinRequest) String() string { return proto.CompactTextString(m) }
+func (*PinRequest) ProtoMessage()    {}
+func (*PinRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{17}
+}
+
+func (m *PinRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PinRequest.Unmarshal(m, b)
+}
+func (m *PinRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PinRequest.Marshal(b, m, deterministic)
+}
+func (m *PinRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PinRequest.Merge(m, src)
+}
+func (m *PinRequest) XXX_Size() int {
+	return xxx_messageInfo_PinRequest.Size(m)
+}
+func (m *PinRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PinRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PinRequest proto.InternalMessageInfo
+
+func (m *PinRequest) GetKey() Key {
+	if m != nil {
+		return m.Key
+	}
+	return Key_HOSTNAME
+}
+
+func (m *PinRequest) GetAddr() *Address {
+	if m != nil {
+		return m.Addr
+	}
+	return nil
+}
+
+func (m *PinRequest) GetHostname() string {
+	if m != nil {
+		return m.Hostname
+	}
+	return ""
+}
+
+func (m *PinRequest) GetPool() string {
+	if m != nil {
+		return m.Pool
+	}
+	return ""
+}
+
+// problems selects the problems to look for, all of them if empty
+type CheckRequest struct {
+	Fix                  Resolution `protobuf:"varint,1,opt,name=fix,proto3,enum=dnsmasqmgr.Resolution" json:"fix,omitempty"`
+	Problems             []Problem  `protobuf:"varint,2,rep,packed,name=problems,proto3,enum=dnsmasqmgr.Problem" json:"problems,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *CheckRequest) Reset()         { *m = CheckRequest{} }
+func (m *CheckRequest) String() string { return proto.CompactTextString(m) }
+func (*CheckRequest) ProtoMessage()    {}
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{18}
+}
+
+func (m *CheckRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckRequest.Unmarshal(m, b)
+}
+func (m *CheckRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckRequest.Marshal(b, m, deterministic)
+}
+func (m *CheckRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckRequest.Merge(m, src)
+}
+func (m *CheckRequest) XXX_Size() int {
+	return xxx_messageInfo_CheckRequest.Size(m)
+}
+func (m *CheckRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckRequest proto.InternalMessageInfo
+
+func (m *CheckRequest) GetFix() Resolution {
+	if m != nil {
+		return m.Fix
+	}
+	return Resolution_REPORT
+}
+
+func (m *CheckRequest) GetProblems() []Problem {
+	if m != nil {
+		return m.Problems
+	}
+	return nil
+}
+
+type Finding struct {
+	Problem Problem `protobuf:"varint,1,opt,name=problem,proto3,enum=dnsmasqmgr.Problem" json:"problem,omitempty"`
+	// MISMATCH if the hosts and the dhcp-host lines disagree, DUPLICATE if lines of the same file clash
+	Error Error `protobuf:"varint,2,opt,name=error,proto3,enum=dnsmasqmgr.Error" json:"error,omitempty"`
+	// the address, the name or the client the problem is about
+	Subject string `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	// the lines involved, as found in the managed files
+	Hosts  []string `protobuf:"bytes,4,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	Leases []string `protobuf:"bytes,5,rep,name=leases,proto3" json:"leases,omitempty"`
+	// the lines the fix dropped, if any
+	Dropped              []string `protobuf:"bytes,6,rep,name=dropped,proto3" json:"dropped,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Finding) Reset()         { *m = Finding{} }
+func (m *Finding) String() string { return proto.CompactTextString(m) }
+func (*Finding) ProtoMessage()    {}
+func (*Finding) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{19}
+}
+
+func (m *Finding) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Finding.Unmarshal(m, b)
+}
+func (m *Finding) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Finding.Marshal(b, m, deterministic)
+}
+func (m *Finding) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Finding.Merge(m, src)
+}
+func (m *Finding) XXX_Size() int {
+	return xxx_messageInfo_Finding.Size(m)
+}
+func (m *Finding) XXX_DiscardUnknown() {
+	xxx_messageInfo_Finding.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Finding proto.InternalMessageInfo
+
+func (m *Finding) GetProblem() Problem {
+	if m != nil {
+		return m.Problem
+	}
+	return Problem_ORPHAN_BINDING
+}
+
+func (m *Finding) GetError() Error {
+	if m != nil {
+		return m.Error
+	}
+	return Error_SUCCESS
+}
+
+func (m *Finding) GetSubject() string {
+	if m != nil {
+		return m.Subject
+	}
+	return ""
+}
+
+func (m *Finding) GetHosts() []string {
+	if m != nil {
+		return m.Hosts
+	}
+	return nil
+}
+
+func (m *Finding) GetLeases() []string {
+	if m != nil {
+		return m.Leases
+	}
+	return nil
+}
+
+func (m *Finding) GetDropped() []string {
+	if m != nil {
+		return m.Dropped
+	}
+	return nil
+}
+
+type CheckReply struct {
+	Findings             []*Finding `protobuf:"bytes,1,rep,name=findings,proto3" json:"findings,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *CheckReply) Reset()         { *m = CheckReply{} }
+func (m *CheckReply) String() string { return proto.CompactTextString(m) }
+func (*CheckReply) ProtoMessage()    {}
+func (*CheckReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b3815698c51f4a73, []int{20}
+}
+
+func (m *CheckReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckReply.Unmarshal(m, b)
+}
+func (m *CheckReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckReply.Marshal(b, m, deterministic)
+}
+func (m *CheckReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckReply.Merge(m, src)
+}
+func (m *CheckReply) XXX_Size() int {
+	return xxx_messageInfo_CheckReply.Size(m)
+}
+func (m *CheckReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckReply proto.InternalMessageInfo
+
+func (m *CheckReply) GetFindings() []*Finding {
+	if m != nil {
+		return m.Findings
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("dnsmasqmgr.Key", Key_name, Key_value)
+	proto.RegisterEnum("dnsmasqmgr.Match", Match_name, Match_value)
+	proto.RegisterEnum("dnsmasqmgr.Error", Error_name, Error_value)
+	proto.RegisterEnum("dnsmasqmgr.Action", Action_name, Action_value)
+	proto.RegisterEnum("dnsmasqmgr.Problem", Problem_name, Problem_value)
+	proto.RegisterEnum("dnsmasqmgr.Resolution", Resolution_name, Resolution_value)
+	proto.RegisterType((*ErrorDetail)(nil), "dnsmasqmgr.ErrorDetail")
+	proto.RegisterType((*Address)(nil), "dnsmasqmgr.Address")
+	proto.RegisterType((*AddressRequest)(nil), "dnsmasqmgr.AddressRequest")
+	proto.RegisterType((*AddressReply)(nil), "dnsmasqmgr.AddressReply")
+	proto.RegisterType((*UpdateRequest)(nil), "dnsmasqmgr.UpdateRequest")
+	proto.RegisterType((*AliasRequest)(nil), "dnsmasqmgr.AliasRequest")
+	proto.RegisterType((*StatusRequest)(nil), "dnsmasqmgr.StatusRequest")
+	proto.RegisterType((*Pool)(nil), "dnsmasqmgr.Pool")
+	proto.RegisterType((*StatusReply)(nil), "dnsmasqmgr.StatusReply")
+	proto.RegisterType((*ListRequest)(nil), "dnsmasqmgr.ListRequest")
+	proto.RegisterType((*ListReply)(nil), "dnsmasqmgr.ListReply")
+	proto.RegisterType((*WatchRequest)(nil), "dnsmasqmgr.WatchRequest")
+	proto.RegisterType((*Event)(nil), "dnsmasqmgr.Event")
+	proto.RegisterType((*LeasesRequest)(nil), "dnsmasqmgr.LeasesRequest")
+	proto.RegisterType((*Lease)(nil), "dnsmasqmgr.Lease")
+	proto.RegisterType((*LeaseEntry)(nil), "dnsmasqmgr.LeaseEntry")
+	proto.RegisterType((*LeasesReply)(nil), "dnsmasqmgr.LeasesReply")
+	proto.RegisterType((*PinRequest)(nil), "dnsmasqmgr.PinRequest")
+	proto.RegisterType((*CheckRequest)(nil), "dnsmasqmgr.CheckRequest")
+	proto.RegisterType((*Finding)(nil), "dnsmasqmgr.Finding")
+	proto.RegisterType((*CheckReply)(nil), "dnsmasqmgr.CheckReply")
+}
+
+func init() { proto.RegisterFile("dnsmasqmgr.proto", fileDescriptor_b3815698c51f4a73) }
+
+var fileDescriptor_b3815698c51f4a73 = []byte{
+	// 1534 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x57, 0xdd, 0x6e, 0xdb, 0xc6,
+	0x12, 0x36, 0x45, 0x51, 0x3f, 0xa3, 0x1f, 0x33, 0x7b, 0xce, 0x49, 0x78, 0x7c, 0x4e, 0x5a, 0x97,
+	0x45, 0x13, 0xd7, 0x45, 0x9c, 0xc0, 0x01, 0x9c, 0x02, 0x45, 0x8b, 0xd0, 0xfa, 0xb1, 0x85, 0xc8,
+	0x12, 0xb1, 0x92, 0xd1, 0xa0, 0x37, 0x2a, 0x25, 0x6e, 0x64, 0xc6, 0x12, 0xc9, 0x90, 0x94, 0x11,
+	0xe7, 0xb6, 0x37, 0xbd, 0xec, 0xfb, 0xb4, 0x77, 0xbd, 0xe8, 0x1b, 0xf4, 0x09, 0xfa, 0x20, 0xc5,
+	0x2c, 0x97, 0x34, 0x19, 0x3b, 0x4e, 0xda, 0x34, 0x77, 0x9c, 0x99, 0x6f, 0xe7, 0x7f, 0x67, 0x96,
+	0xa0, 0xda, 0x6e, 0xb8, 0xb4, 0xc2, 0x17, 0xcb, 0x79, 0xb0, 0xe3, 0x07, 0x5e, 0xe4, 0x11, 0xb8,
+	0xe0, 0xe8, 0x7b, 0x50, 0xeb, 0x04, 0x81, 0x17, 0xb4, 0x59, 0x64, 0x39, 0x0b, 0x72, 0x17, 0x14,
+	0x86, 0xa4, 0x26, 0x6d, 0x4a, 0x5b, 0xcd, 0xdd, 0x1b, 0x3b, 0x99, 0xc3, 0x1c, 0x47, 0x63, 0xb9,
+	0xfe, 0xb3, 0x04, 0x65, 0xc3, 0xb6, 0x03, 0x16, 0x86, 0x64, 0x03, 0x2a, 0x27, 0x5e, 0x18, 0xb9,
+	0xd6, 0x92, 0xf1, 0x73, 0x55, 0x9a, 0xd2, 0x44, 0x83, 0xf2, 0xd2, 0x9a, 0x59, 0xb6, 0x1d, 0x68,
+	0x05, 0x2e, 0x4a, 0x48, 0x72, 0x13, 0x4a, 0x8e, 0xcf, 0x05, 0x32, 0x17, 0x08, 0x0a, 0x4f, 0x58,
+	0x0b, 0xc7, 0x0a, 0x59, 0xa8, 0x15, 0x37, 0x65, 0x3c, 0x21, 0x48, 0x94, 0x38, 0xfe, 0x1e, 0x3f,
+	0xa2, 0xc4, 0xba, 0x04, 0x89, 0x1e, 0xcc, 0x16, 0x0e, 0x73, 0x23, 0xc7, 0xd6, 0x4a, 0xb1, 0x07,
+	0x09, 0x4d, 0x08, 0x14, 0x23, 0x6b, 0x1e, 0x6a, 0x65, 0xae, 0x8c, 0x7f, 0xeb, 0x3e, 0x34, 0x85,
+	0xf3, 0x94, 0xbd, 0x58, 0xb1, 0x30, 0x22, 0x9f, 0x80, 0x7c, 0xca, 0xce, 0x45, 0xd8, 0xeb, 0xd9,
+	0xb0, 0x9f, 0xb0, 0x73, 0x8a, 0x32, 0x72, 0x17, 0x8a, 0x69, 0x1c, 0xb5, 0xdd, 0x7f, 0x65, 0x31,
+	0x89, 0x32, 0x0e, 0x40, 0x8b, 0xbe, 0xe7, 0x2d, 0x44, 0x5c, 0xfc, 0x5b, 0xff, 0x41, 0x82, 0x7a,
+	0x6a, 0xd2, 0x5f, 0x9c, 0xbf, 0x9b, 0x41, 0x65, 0x69, 0x45, 0xb3, 0x13, 0xad, 0x70, 0xb9, 0x18,
+	0x47, 0x28, 0xa0, 0xb1, 0x3c, 0xf5, 0x4c, 0x7e, 0x8b, 0x67, 0xfa, 0x4f, 0x12, 0x34, 0x8e, 0x7d,
+	0xdb, 0x8a, 0xd8, 0x5f, 0x88, 0xfb, 0x1e, 0x94, 0x67, 0xab, 0x20, 0x60, 0x6e, 0x74, 0x5d, 0xe8,
+	0x09, 0x06, 0xe1, 0x2b, 0x6e, 0xc2, 0xbe, 0xce, 0x9f, 0x04, 0xa3, 0x47, 0x50, 0x37, 0xb0, 0xbe,
+	0x1f, 0xa2, 0x10, 0x99, 0x56, 0x92, 0x73, 0xad, 0xa4, 0xaf, 0x43, 0x63, 0x14, 0x59, 0xd1, 0x2a,
+	0x31, 0xab, 0xff, 0x21, 0x41, 0xd1, 0xf4, 0xbc, 0x05, 0x16, 0x2f, 0xd3, 0xc8, 0xfc, 0x9b, 0xfc,
+	0x1b, 0x94, 0xc0, 0x72, 0xe7, 0x4c, 0xb4, 0x70, 0x4c, 0x20, 0x37, 0xf2, 0x22, 0x2b, 0xae, 0xb3,
+	0x4c, 0x63, 0x82, 0xfc, 0x1f, 0xaa, 0x01, 0x5b, 0x5a, 0x8e, 0xeb, 0xb8, 0x73, 0xad, 0xc8, 0x25,
+	0x17, 0x0c, 0x6c, 0xfa, 0x70, 0x35, 0x75, 0x59, 0x24, 0x3a, 0x58, 0x50, 0xc8, 0xb7, 0x3d, 0x04,
+	0x89, 0xf6, 0x15, 0x14, 0xf2, 0xb9, 0xb1, 0x3d, 0xad, 0x1c, 0xf3, 0x63, 0x0a, 0xf9, 0xdc, 0xdc,
+	0x9e, 0x56, 0xe1, 0x26, 0x04, 0x45, 0x3e, 0x02, 0x48, 0x8d, 0xed, 0x69, 0x55, 0x2e, 0xcb, 0x70,
+	0xf4, 0x39, 0xd4, 0x92, 0xb8, 0xb1, 0x09, 0xef, 0x80, 0x82, 0xdd, 0x19, 0x6a, 0xd2, 0xa6, 0xbc,
+	0x55, 0xdb, 0x55, 0xb3, 0xa9, 0xc4, 0x6c, 0xd0, 0x58, 0x8c, 0xf7, 0x2b, 0x60, 0x96, 0xed, 0xb9,
+	0x8b, 0x73, 0x9e, 0x83, 0x0a, 0x4d, 0x69, 0x4c, 0x83, 0xb5, 0x60, 0x41, 0x24, 0xda, 0x3d, 0x26,
+	0xf4, 0xdf, 0x25, 0xa8, 0xf5, 0x9d, 0x30, 0x4a, 0xca, 0xfa, 0x29, 0x34, 0x92, 0x99, 0x30, 0x99,
+	0x2f, 0xbc, 0xa9, 0xc8, 0x6f, 0x3d, 0x61, 0x1e, 0x2c, 0xbc, 0x29, 0xf9, 0x0c, 0x9a, 0x62, 0x3a,
+	0x4c, 0xfc, 0x80, 0x3d, 0x73, 0x5e, 0x8a, 0x84, 0x37, 0x04, 0xd7, 0xe4, 0xcc, 0x4c, 0x12, 0xe5,
+	0x5c, 0x12, 0xd3, 0xfb, 0x52, 0x7c, 0xcb, 0x7d, 0xf9, 0x1f, 0x54, 0x7d, 0x6b, 0xce, 0x26, 0xa1,
+	0xf3, 0x8a, 0xf1, 0x42, 0x28, 0xb4, 0x82, 0x8c, 0x91, 0xf3, 0x8a, 0x91, 0xdb, 0x00, 0x5c, 0x18,
+	0x79, 0xa7, 0x2c, 0x29, 0x07, 0x87, 0x8f, 0x91, 0xa1, 0xcf, 0xa0, 0x1a, 0xc7, 0x85, 0xf9, 0xdb,
+	0x01, 0x05, 0xfd, 0x4a, 0xf2, 0xa7, 0x5d, 0xd5, 0x8a, 0x08, 0xa4, 0x31, 0x8c, 0xdc, 0x81, 0x75,
+	0x97, 0xbd, 0x8c, 0x26, 0x19, 0x03, 0x22, 0x42, 0x64, 0x9b, 0xa9, 0x91, 0x87, 0x50, 0xff, 0x96,
+	0x3b, 0x7c, 0x91, 0xbd, 0x67, 0x81, 0xb7, 0x9c, 0x04, 0xec, 0xcc, 0x09, 0x1d, 0xcf, 0xe5, 0xd9,
+	0x93, 0x69, 0x1d, 0x99, 0x54, 0xf0, 0xf4, 0xdf, 0x24, 0x50, 0x3a, 0x67, 0x78, 0x05, 0x79, 0xb9,
+	0x72, 0xc8, 0x94, 0x26, 0xdb, 0x50, 0xb2, 0x66, 0x91, 0xe3, 0xc5, 0x96, 0x9b, 0xbb, 0x24, 0xe7,
+	0x33, 0x97, 0x50, 0x81, 0x78, 0xe7, 0xb9, 0x42, 0xee, 0x43, 0xc5, 0x47, 0x0b, 0xde, 0x2a, 0xd4,
+	0x8a, 0x6f, 0x06, 0xa7, 0x20, 0xbc, 0x25, 0x91, 0xb3, 0x64, 0x61, 0x64, 0x2d, 0x7d, 0x5e, 0x01,
+	0x99, 0x5e, 0x30, 0xf4, 0xcf, 0xa1, 0xd1, 0x67, 0x78, 0x4f, 0x93, 0xf8, 0x35, 0x28, 0xb3, 0x97,
+	0xbe, 0x13, 0x30, 0x9b, 0xc7, 0x53, 0xa1, 0x09, 0x89, 0x7b, 0x48, 0xe1, 0x58, 0xec, 0x0a, 0xce,
+	0x3c, 0x17, 0x21, 0x0b, 0xea, 0x6f, 0x6c, 0xa0, 0xec, 0x3e, 0x2b, 0xbe, 0xb6, 0xcf, 0xb2, 0x9b,
+	0x46, 0xb9, 0xbc, 0x69, 0x1c, 0x4b, 0x6c, 0xa0, 0x06, 0xe5, 0xdf, 0x3c, 0x50, 0xb6, 0xf4, 0xbd,
+	0xc0, 0x0a, 0xce, 0xf9, 0x1d, 0xae, 0xd0, 0x0b, 0x86, 0x3e, 0x05, 0xe0, 0xce, 0x77, 0xdc, 0x28,
+	0xe0, 0xf3, 0x7e, 0x81, 0x14, 0x0f, 0xa0, 0x96, 0xef, 0x5f, 0x0e, 0xa3, 0xb1, 0x9c, 0x7c, 0x01,
+	0xa5, 0x30, 0xb2, 0x22, 0x67, 0x76, 0xdd, 0x08, 0x14, 0x10, 0xfd, 0x7b, 0xa8, 0x25, 0xc9, 0xc4,
+	0x96, 0x7d, 0x00, 0x65, 0xe6, 0x46, 0x81, 0xc3, 0x92, 0xa6, 0xbd, 0x79, 0xc9, 0x0c, 0xf7, 0x86,
+	0x26, 0x30, 0xf2, 0x31, 0xd4, 0x42, 0x16, 0x9c, 0xb1, 0x60, 0x62, 0xaf, 0x1c, 0x5b, 0x24, 0x11,
+	0x62, 0x56, 0x7b, 0xe5, 0xd8, 0xfa, 0x8f, 0x12, 0x80, 0xe9, 0xb8, 0x1f, 0x62, 0x82, 0x67, 0x4b,
+	0x21, 0xbf, 0x56, 0x8a, 0x64, 0xcd, 0x16, 0x33, 0x6b, 0xd6, 0x81, 0x7a, 0xeb, 0x84, 0xcd, 0x4e,
+	0x13, 0x5f, 0xb6, 0x40, 0xc6, 0x31, 0x12, 0xfb, 0x92, 0x8b, 0x94, 0xb2, 0xd0, 0x5b, 0xac, 0x78,
+	0xbb, 0x23, 0x24, 0x6e, 0x61, 0x6f, 0xba, 0x60, 0xcb, 0x50, 0x2b, 0x6c, 0xca, 0x5b, 0xcd, 0xbc,
+	0x5b, 0x66, 0x2c, 0xa3, 0x29, 0x48, 0xff, 0x55, 0x82, 0x72, 0xd7, 0x71, 0x6d, 0x1c, 0xeb, 0xf7,
+	0xa0, 0x2c, 0xf8, 0xc2, 0xd4, 0x95, 0x67, 0x13, 0xcc, 0xc5, 0x2b, 0xab, 0x70, 0xfd, 0x2b, 0x0b,
+	0x7b, 0x37, 0x5c, 0x4d, 0x9f, 0xb3, 0x59, 0x32, 0xea, 0x12, 0x12, 0xa7, 0x2e, 0x26, 0x22, 0x79,
+	0x23, 0xc5, 0x04, 0x76, 0x34, 0xef, 0x90, 0x50, 0x53, 0x38, 0x5b, 0x50, 0xa8, 0xc7, 0x0e, 0x3c,
+	0xdf, 0x67, 0xd8, 0x9c, 0x7c, 0x11, 0x0a, 0x52, 0xff, 0x1a, 0x40, 0x24, 0x0c, 0x9b, 0xe3, 0x3e,
+	0x54, 0x9e, 0xc5, 0x21, 0x25, 0xdd, 0x91, 0x0b, 0x44, 0x84, 0x4b, 0x53, 0xd0, 0xf6, 0x23, 0x90,
+	0x9f, 0xb0, 0x73, 0x52, 0x87, 0xca, 0xe1, 0x70, 0x34, 0x1e, 0x18, 0x47, 0x1d, 0x75, 0x8d, 0xd4,
+	0xa0, 0x7c, 0x64, 0xb4, 0x8c, 0x76, 0x9b, 0xaa, 0x12, 0x01, 0x28, 0xf5, 0x4c, 0xfe, 0x5d, 0x20,
+	0x55, 0x50, 0x8c, 0x7e, 0xcf, 0x18, 0xa9, 0xf2, 0xf6, 0x16, 0x28, 0x7c, 0x24, 0x93, 0x0a, 0x14,
+	0x07, 0xc3, 0x81, 0x38, 0x66, 0x1a, 0x74, 0xdc, 0x33, 0xfa, 0xaa, 0x84, 0xec, 0xee, 0x71, 0xbf,
+	0xaf, 0x16, 0xb6, 0xbf, 0x03, 0x85, 0xe7, 0x04, 0xe5, 0xa3, 0xe3, 0x56, 0xab, 0x33, 0x1a, 0xa9,
+	0x6b, 0x68, 0x71, 0x30, 0x1c, 0x77, 0x87, 0xc7, 0x83, 0xb6, 0x2a, 0x91, 0x06, 0x54, 0xdb, 0xc7,
+	0x66, 0xbf, 0xd7, 0x32, 0xc6, 0x1d, 0xb5, 0x80, 0xc2, 0xa3, 0xde, 0xe8, 0xc8, 0x18, 0xb7, 0x0e,
+	0x55, 0x19, 0xcf, 0xf5, 0x8d, 0x83, 0x83, 0xde, 0xe0, 0x40, 0x2d, 0xa2, 0xa8, 0x35, 0x1c, 0x74,
+	0xfb, 0xbd, 0xd6, 0x58, 0x55, 0xb6, 0x1f, 0x43, 0x29, 0x1e, 0x79, 0xa4, 0x0c, 0xb2, 0xd1, 0x6e,
+	0xab, 0x6b, 0xe8, 0x6f, 0xbb, 0xd3, 0xef, 0x8c, 0x3b, 0xb1, 0xef, 0xc7, 0x66, 0x3b, 0xd5, 0xd9,
+	0x79, 0x3a, 0xee, 0xd0, 0x81, 0xd1, 0x57, 0x65, 0x84, 0x77, 0x7b, 0x4f, 0xd5, 0xe2, 0xf6, 0x1c,
+	0xca, 0xa2, 0xbc, 0x84, 0x40, 0x73, 0x48, 0xcd, 0x43, 0x63, 0x30, 0xd9, 0xef, 0x0d, 0xda, 0x68,
+	0x6e, 0x8d, 0xac, 0x43, 0x4d, 0xf0, 0x30, 0x3f, 0xaa, 0x44, 0x54, 0xa8, 0x0f, 0x8f, 0xc7, 0x93,
+	0x61, 0x77, 0x42, 0x8d, 0xc1, 0x01, 0x2a, 0x26, 0xd0, 0x1c, 0x1d, 0x1a, 0xb4, 0xd3, 0x9e, 0x60,
+	0x96, 0x30, 0x3a, 0x99, 0xdc, 0x80, 0x06, 0xe6, 0x72, 0x92, 0xba, 0x5a, 0xdc, 0x7e, 0x04, 0x70,
+	0xd1, 0xb2, 0xe8, 0x19, 0xed, 0x98, 0x43, 0x3a, 0x56, 0xd7, 0x48, 0x13, 0xe0, 0x49, 0xa7, 0x63,
+	0x4e, 0xba, 0x3d, 0xca, 0x4d, 0x34, 0xa0, 0xca, 0xe9, 0xbe, 0x31, 0x1a, 0xab, 0x85, 0xdd, 0x5f,
+	0x4a, 0xd0, 0x6c, 0x0f, 0x46, 0x47, 0x56, 0xf8, 0xe2, 0xc8, 0x72, 0xad, 0x39, 0x0b, 0xc8, 0x21,
+	0x34, 0xc5, 0x05, 0x49, 0x9f, 0xf0, 0x57, 0x6e, 0x2e, 0x0e, 0xd9, 0x78, 0xe3, 0x56, 0xd3, 0xd7,
+	0xc8, 0x01, 0x34, 0xda, 0x6c, 0xc1, 0x22, 0xf6, 0x0f, 0x28, 0xea, 0x7b, 0xde, 0xe9, 0xca, 0x7f,
+	0x5f, 0x45, 0x06, 0x54, 0x0f, 0x58, 0x14, 0x3f, 0x72, 0xc8, 0x7f, 0xb3, 0xc0, 0xdc, 0x83, 0x6f,
+	0xe3, 0xd6, 0x55, 0xa2, 0x44, 0x45, 0x03, 0x57, 0xbc, 0x50, 0xcc, 0x42, 0x92, 0xc3, 0x66, 0x5e,
+	0x35, 0x1b, 0xff, 0xb9, 0x2c, 0x88, 0x55, 0x7c, 0x09, 0x0a, 0x5f, 0xe0, 0x24, 0xe7, 0x6a, 0x76,
+	0xa7, 0x6f, 0xe4, 0x6f, 0x3d, 0xee, 0x6d, 0x7d, 0xed, 0x81, 0x44, 0xba, 0xc9, 0x0b, 0x3d, 0x49,
+	0x44, 0x2e, 0x86, 0xdc, 0xe3, 0xfd, 0xda, 0x3c, 0x3c, 0x86, 0x8a, 0x61, 0xdb, 0xfc, 0x69, 0x9d,
+	0x77, 0x22, 0xfb, 0xda, 0xbe, 0x56, 0x43, 0x0b, 0x6a, 0x94, 0x2d, 0xbd, 0x33, 0xf6, 0x3e, 0x4a,
+	0xf6, 0x01, 0x30, 0x2f, 0xf1, 0x06, 0xca, 0xc7, 0x92, 0x5b, 0xf1, 0x1b, 0xb7, 0xae, 0x12, 0xc5,
+	0x3a, 0xbe, 0x81, 0x8a, 0xe9, 0xb8, 0x62, 0xcb, 0xe7, 0x06, 0xab, 0xe3, 0xbe, 0x8b, 0x0f, 0x5f,
+	0x81, 0xc2, 0x67, 0x5c, 0x3e, 0x84, 0xec, 0x9e, 0xd8, 0xb8, 0x79, 0x85, 0x84, 0x1f, 0xde, 0xdf,
+	0x85, 0xdb, 0x33, 0x6f, 0xb9, 0x33, 0x77, 0xa2, 0x93, 0xd5, 0x74, 0x67, 0xe9, 0x3d, 0xb7, 0xce,
+	0x58, 0x98, 0x41, 0xef, 0xaf, 0x27, 0x97, 0x6b, 0x1e, 0x98, 0xf8, 0x7b, 0x6d, 0x4a, 0xd3, 0x12,
+	0xff, 0xcf, 0x7e, 0xf8, 0xe7, 0x00, 0x9d, 0x84, 0x10, 0x4f, 0x7b, 0x0f, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// DNSMasqManagerClient is the client API for DNSMasqManager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type DNSMasqManagerClient interface {
+	RequestAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	DeleteAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	LookupAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	ListAddresses(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListReply, error)
+	// Watch can resume only from the last 1024 changes since dnsmasqmgrd started: older revisions
+	// get OutOfRange, and the watchers must list the addresses again.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (DNSMasqManager_WatchClient, error)
+	UpdateAddress(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	AddAlias(ctx context.Context, in *AliasRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	RemoveAlias(ctx context.Context, in *AliasRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	ListLeases(ctx context.Context, in *LeasesRequest, opts ...grpc.CallOption) (*LeasesReply, error)
+	PinLease(ctx context.Context, in *PinRequest, opts ...grpc.CallOption) (*AddressReply, error)
+	Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckReply, error)
+}
+
+type dNSMasqManagerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewDNSMasqManagerClient(cc *grpc.ClientConn) DNSMasqManagerClient {
+	return &dNSMasqManagerClient{cc}
+}
+
+func (c *dNSMasqManagerClient) RequestAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/RequestAddress", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) DeleteAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/DeleteAddress", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) LookupAddress(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/LookupAddress", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/GetStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) ListAddresses(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListReply, error) {
+	out := new(ListReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/ListAddresses", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (DNSMasqManager_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_DNSMasqManager_serviceDesc.Streams[0], "/dnsmasqmgr.DNSMasqManager/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dNSMasqManagerWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DNSMasqManager_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type dNSMasqManagerWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *dNSMasqManagerWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *dNSMasqManagerClient) UpdateAddress(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/UpdateAddress", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) AddAlias(ctx context.Context, in *AliasRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/AddAlias", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) RemoveAlias(ctx context.Context, in *AliasRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/RemoveAlias", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) ListLeases(ctx context.Context, in *LeasesRequest, opts ...grpc.CallOption) (*LeasesReply, error) {
+	out := new(LeasesReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/ListLeases", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) PinLease(ctx context.Context, in *PinRequest, opts ...grpc.CallOption) (*AddressReply, error) {
+	out := new(AddressReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/PinLease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dNSMasqManagerClient) Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckReply, error) {
+	out := new(CheckReply)
+	err := c.cc.Invoke(ctx, "/dnsmasqmgr.DNSMasqManager/Check", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DNSMasqManagerServer is the server API for DNSMasqManager service.
+type DNSMasqManagerServer interface {
+	RequestAddress(context.Context, *AddressRequest) (*AddressReply, error)
+	DeleteAddress(context.Context, *AddressRequest) (*AddressReply, error)
+	LookupAddress(context.Context, *AddressRequest) (*AddressReply, error)
+	GetStatus(context.Context, *StatusRequest) (*StatusReply, error)
+	ListAddresses(context.Context, *ListRequest) (*ListReply, error)
+	// Watch can resume only from the last 1024 changes since dnsmasqmgrd started: older revisions
+	// get OutOfRange, and the watchers must list the addresses again.
+	Watch(*WatchRequest, DNSMasqManager_WatchServer) error
+	UpdateAddress(context.Context, *UpdateRequest) (*AddressReply, error)
+	AddAlias(context.Context, *AliasRequest) (*AddressReply, error)
+	RemoveAlias(context.Context, *AliasRequest) (*AddressReply, error)
+	ListLeases(context.Context, *LeasesRequest) (*LeasesReply, error)
+	PinLease(context.Context, *PinRequest) (*AddressReply, error)
+	Check(context.Context, *CheckRequest) (*CheckReply, error)
+}
+
+func RegisterDNSMasqManagerServer(s *grpc.Server, srv DNSMasqManagerServer) {
+	s.RegisterService(&_DNSMasqManager_serviceDesc, srv)
+}
+
+func _DNSMasqManager_RequestAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).RequestAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/RequestAddress",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).RequestAddress(ctx, req.(*AddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_DeleteAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).DeleteAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/DeleteAddress",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).DeleteAddress(ctx, req.(*AddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_LookupAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
 	}
 	if interceptor == nil {
 		return srv.(DNSMasqManagerServer).LookupAddress(ctx, in)
@@ -424,6 +1859,171 @@ func _DNSMasqManager_LookupAddress_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DNSMasqManager_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).GetStatus(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_ListAddresses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).ListAddresses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/ListAddresses",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).ListAddresses(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DNSMasqManagerServer).Watch(m, &dNSMasqManagerWatchServer{stream})
+}
+
+type DNSMasqManager_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type dNSMasqManagerWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *dNSMasqManagerWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DNSMasqManager_UpdateAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).UpdateAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/UpdateAddress",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).UpdateAddress(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_AddAlias_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AliasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).AddAlias(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/AddAlias",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).AddAlias(ctx, req.(*AliasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_RemoveAlias_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AliasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).RemoveAlias(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/RemoveAlias",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).RemoveAlias(ctx, req.(*AliasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_ListLeases_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeasesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).ListLeases(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/ListLeases",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).ListLeases(ctx, req.(*LeasesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_PinLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).PinLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/PinLease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).PinLease(ctx, req.(*PinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DNSMasqManager_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DNSMasqManagerServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dnsmasqmgr.DNSMasqManager/Check",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DNSMasqManagerServer).Check(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _DNSMasqManager_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "dnsmasqmgr.DNSMasqManager",
 	HandlerType: (*DNSMasqManagerServer)(nil),
@@ -440,7 +2040,45 @@ var _DNSMasqManager_serviceDesc = grpc.ServiceDesc{
 			MethodName: "LookupAddress",
 			Handler:    _DNSMasqManager_LookupAddress_Handler,
 		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _DNSMasqManager_GetStatus_Handler,
+		},
+		{
+			MethodName: "ListAddresses",
+			Handler:    _DNSMasqManager_ListAddresses_Handler,
+		},
+		{
+			MethodName: "UpdateAddress",
+			Handler:    _DNSMasqManager_UpdateAddress_Handler,
+		},
+		{
+			MethodName: "AddAlias",
+			Handler:    _DNSMasqManager_AddAlias_Handler,
+		},
+		{
+			MethodName: "RemoveAlias",
+			Handler:    _DNSMasqManager_RemoveAlias_Handler,
+		},
+		{
+			MethodName: "ListLeases",
+			Handler:    _DNSMasqManager_ListLeases_Handler,
+		},
+		{
+			MethodName: "PinLease",
+			Handler:    _DNSMasqManager_PinLease_Handler,
+		},
+		{
+			MethodName: "Check",
+			Handler:    _DNSMasqManager_Check_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _DNSMasqManager_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "dnsmasqmgr.proto",
 }
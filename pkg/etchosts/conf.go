@@ -20,11 +20,18 @@
  */
 
 // The etchosts package provide utilities manage /etc/hosts-like file (see man 5 hosts)
+// The content is modeled as a document: comments, blank lines and lines we can't
+// make sense of are kept verbatim and in order, and entries are rewritten only if changed.
 // This package use naive linear search and no lookup optimizations (e.g. maps, skipslists).
 // Rationale:
 // - we expect to work with maximum ~1000 entries (*one* thousand, not thousand*S*)
 // - everything is in memory anyway
 // - linear search is simpler to implement/maintain
+// The only exception is the index of the names, canonical and aliases alike, which
+// ensures any name resolves to exactly one entry per address family.
+// Like dnsmasq does with addn-hosts files, an address can be listed on many lines,
+// and a name can be listed once for IPv4 and once for IPv6 (e.g. localhost).
+// Reverse lookups return the first entry in file order.
 
 package etchosts
 
@@ -43,11 +50,30 @@ var (
 	ErrBadIPFormat      error = errors.New("Malformed IP address")
 	ErrBadEntryFormat   error = errors.New("Malformed entry")
 	ErrMissingHostname  error = errors.New("Missing hostname")
+	ErrBadHostname      error = errors.New("Malformed hostname")
 	ErrDuplicate        error = errors.New("Duplicated entry")
 	ErrNotFoundHostname error = errors.New("Hostname not found in the hostsfile")
 	ErrNotFoundAddress  error = errors.New("Address not found in the hostsfile")
+	ErrNotFoundAlias    error = errors.New("Alias not found in the hostsfile")
 )
 
+// ConflictError reports a name or an address already used by another entry
+type ConflictError struct {
+	// What is the name or the address in conflict
+	What string
+	// Entry is the entry already using it
+	Entry Host
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %s already used by [%s]", ErrDuplicate, e.What, e.Entry)
+}
+
+// Unwrap makes errors.Is(err, ErrDuplicate) work
+func (e *ConflictError) Unwrap() error {
+	return ErrDuplicate
+}
+
 // Host represents a single entry in the /etc/hosts file
 type Host struct {
 	Address           net.IP
@@ -55,9 +81,43 @@ type Host struct {
 	Aliases           []string
 }
 
+const (
+	commentMarker  string = "#"
+	maxNameLength  int    = 253
+	maxLabelLength int    = 63
+)
+
+// CheckHostname returns an error if name is not a valid hostname (RFC 1123): labels of letters, digits
+// and hyphens, not starting or ending with a hyphen, separated by dots. A trailing dot is allowed.
+func CheckHostname(name string) error {
+	s := strings.TrimSuffix(name, ".")
+	if s == "" {
+		return ErrMissingHostname
+	}
+	if len(s) > maxNameLength {
+		return fmt.Errorf("%w: %q: longer than %d characters", ErrBadHostname, name, maxNameLength)
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > maxLabelLength {
+			return fmt.Errorf("%w: %q: labels must be 1 to %d characters long", ErrBadHostname, name, maxLabelLength)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%w: %q: labels must not start or end with a hyphen", ErrBadHostname, name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("%w: %q: bad character %q", ErrBadHostname, name, c)
+			}
+		}
+	}
+	return nil
+}
+
 func ParseHostString(s string) (Host, error) {
-	s = strings.Replace(s, "\t", " ", -1)
-	items := strings.Split(s, " ")
+	if pos := strings.Index(s, commentMarker); pos != -1 {
+		s = s[:pos]
+	}
+	items := strings.Fields(s)
 	if len(items) < 2 {
 		return Host{}, ErrBadEntryFormat
 	}
@@ -99,71 +159,222 @@ func (h Host) Duplicate(x Host) bool {
 	return h.findDuplicate(x) != ""
 }
 
-func (h Host) findDuplicate(x Host) string {
-	if h.CanonicalHostname == x.CanonicalHostname {
-		return x.CanonicalHostname
-	}
-	if h.Address.Equal(x.Address) {
-		return x.Address.String()
+// Names returns the canonical hostname followed by the aliases
+func (h Host) Names() []string {
+	return append([]string{h.CanonicalHostname}, h.Aliases...)
+}
+
+// HasName returns true if name is either the canonical hostname or an alias. Names are case-insensitive.
+func (h Host) HasName(name string) bool {
+	for _, n := range h.Names() {
+		if strings.EqualFold(n, name) {
+			return true
+		}
 	}
-	numAliases := len(h.Aliases)
-	if len(x.Aliases) < len(h.Aliases) {
-		numAliases = len(x.Aliases)
+	return false
+}
+
+// IsIPv4 returns true if the Host has an IPv4 address, thus answers A queries
+func (h Host) IsIPv4() bool {
+	return h.Address.To4() != nil
+}
+
+// SameFamily returns true if h and x answer the same kind of queries (A or AAAA)
+func (h Host) SameFamily(x Host) bool {
+	return h.IsIPv4() == x.IsIPv4()
+}
+
+// findDuplicate returns any name, canonical or alias, x shares with h in the same address family.
+// Sharing the address is fine.
+func (h Host) findDuplicate(x Host) string {
+	if !h.SameFamily(x) {
+		return ""
 	}
-	for ix := 0; ix < numAliases; ix++ {
-		if h.Aliases[ix] == x.Aliases[ix] {
-			return x.Aliases[ix]
+	for _, name := range x.Names() {
+		if h.HasName(name) {
+			return name
 		}
 	}
 	return ""
 }
 
-// Conf represents the configured Bindings
+// line is a line of the hosts file. Lines which are not host entries
+// (comments, blank lines, malformed or conflicting entries) are kept verbatim.
+type line struct {
+	// raw is the original text. It is cleared when the host entry is changed.
+	raw  string
+	host *Host
+}
+
+func (l *line) String() string {
+	if l.host == nil || l.raw != "" {
+		return l.raw
+	}
+	return l.host.String()
+}
+
+// Conf represents the configured Hosts, as a document
 type Conf struct {
+	lines []*line
+	// names maps every name, canonical hostname or alias, lowercased, to the line of its entry.
 	// this is not really for efficiency, even though it's a nice plus,
-	// but rather  because Hostname is the key here.
-	hosts map[string]Host
+	// but rather because a name must resolve to one entry only.
+	names map[string][]*line
+	count int
 }
 
 func NewConf() *Conf {
 	return &Conf{
-		hosts: make(map[string]Host),
+		names: make(map[string][]*line),
+	}
+}
+
+// Clone returns a copy of the Conf which can be changed independently.
+// Lines are never changed in place, so they can be shared.
+func (m *Conf) Clone() *Conf {
+	x := &Conf{
+		lines: append([]*line(nil), m.lines...),
+		names: make(map[string][]*line, len(m.names)),
+		count: m.count,
+	}
+	for k, v := range m.names {
+		x.names[k] = append([]*line(nil), v...)
+	}
+	return x
+}
+
+func (m *Conf) index(l *line) {
+	for _, name := range l.host.Names() {
+		name = strings.ToLower(name)
+		m.names[name] = append(m.names[name], l)
+	}
+	m.count++
+}
+
+func (m *Conf) unindex(l *line) {
+	for _, name := range l.host.Names() {
+		name = strings.ToLower(name)
+		var rest []*line
+		for _, x := range m.names[name] {
+			if x != l {
+				rest = append(rest, x)
+			}
+		}
+		if len(rest) == 0 {
+			delete(m.names, name)
+		} else {
+			m.names[name] = rest
+		}
+	}
+	m.count--
+}
+
+// position returns the index of the line in the document
+func (m *Conf) position(l *line) int {
+	for ix, x := range m.lines {
+		if x == l {
+			return ix
+		}
+	}
+	return -1
+}
+
+// find returns the first line in file order carrying name and satisfying the predicate
+func (m *Conf) find(name string, pred func(h *Host) bool) (*line, bool) {
+	var ret *line
+	pos := -1
+	for _, l := range m.names[strings.ToLower(name)] {
+		if !pred(l.host) {
+			continue
+		}
+		if p := m.position(l); ret == nil || p < pos {
+			ret, pos = l, p
+		}
+	}
+	return ret, ret != nil
+}
+
+// lookup returns the first line whose canonical hostname is name
+func (m *Conf) lookup(name string) (*line, bool) {
+	return m.find(name, func(h *Host) bool {
+		return strings.EqualFold(h.CanonicalHostname, name)
+	})
+}
+
+// lookupAll returns all the lines whose canonical hostname is name, in file order
+func (m *Conf) lookupAll(name string) []*line {
+	var ret []*line
+	for _, l := range m.lines {
+		if l.host != nil && strings.EqualFold(l.host.CanonicalHostname, name) {
+			ret = append(ret, l)
+		}
 	}
+	return ret
 }
 
-// Len returns the number of configured Bindings
+// Len returns the number of configured Hosts
 func (m *Conf) Len() int {
-	return len(m.hosts)
+	return m.count
+}
+
+// Hosts returns all the configured Hosts, in file order
+func (m *Conf) Hosts() []Host {
+	var ret []Host
+	for _, l := range m.lines {
+		if l.host != nil {
+			ret = append(ret, *l.host)
+		}
+	}
+	return ret
 }
 
-// String converts all the registered hosts in the Conf in content in etchosts (man 8 dnsmasq) representation
+// String converts the Conf in content in etchosts (man 5 hosts) representation.
+// Lines not changed since parsing are emitted exactly as they were read.
 func (m *Conf) String() string {
 	var sb strings.Builder
-	for _, h := range m.hosts {
-		sb.WriteString(fmt.Sprintf("%s\n", h.String()))
+	for _, l := range m.lines {
+		sb.WriteString(fmt.Sprintf("%s\n", l.String()))
 	}
 	return sb.String()
 }
 
-func (m *Conf) duplicate(x Host) *Host {
-	for _, h := range m.hosts {
-		if what := h.findDuplicate(x); what != "" {
-			log.Printf("etchosts: [%s] duplicates [%s] on %s", x, h, what)
-			return &h
+// duplicate returns the conflict between x and any entry but the one in the line skip
+func (m *Conf) duplicate(x Host, skip *line) error {
+	var err *ConflictError
+	for _, name := range x.Names() {
+		l, ok := m.find(name, func(h *Host) bool {
+			return h.SameFamily(x)
+		})
+		if ok && l != skip {
+			err = &ConflictError{What: name, Entry: *l.host}
+			break
 		}
 	}
-	return nil
+	if err == nil {
+		return nil
+	}
+	log.Printf("etchosts: [%s] duplicates [%s] on %s", x, err.Entry, err.What)
+	return err
 }
 
-func (m *Conf) add(h Host) error {
-	if x := m.duplicate(h); x != nil {
-		return fmt.Errorf("%s: %s", ErrDuplicate, x)
+func (m *Conf) add(h Host, raw string) error {
+	if err := m.duplicate(h, nil); err != nil {
+		return err
 	}
-	m.hosts[h.CanonicalHostname] = h
+	l := &line{
+		raw:  raw,
+		host: &h,
+	}
+	m.lines = append(m.lines, l)
+	m.index(l)
 	log.Printf("etchosts: added [[%s]]", h)
 	return nil
 }
 
+func (m *Conf) addRaw(raw string) {
+	m.lines = append(m.lines, &line{raw: raw})
+}
+
 func (m *Conf) Add(name, addr string, aliases []string) (Host, error, bool) {
 	if name == "" {
 		return Host{}, ErrMissingHostname, false
@@ -178,17 +389,69 @@ func (m *Conf) Add(name, addr string, aliases []string) (Host, error, bool) {
 	for _, alias := range aliases {
 		ret.Aliases = append(ret.Aliases, alias)
 	}
-	err := m.add(ret)
+	err := m.add(ret, "")
 	return ret, err, err != nil
 }
 
+// Remove drops all the Hosts whose canonical hostname is name, IPv4 and IPv6 alike,
+// and returns the first one in file order.
 func (m *Conf) Remove(name string) (Host, bool) {
-	ret, removed := m.hosts[name]
-	delete(m.hosts, name)
-	log.Printf("etchosts: removed [[%s]] -> %v", ret, removed)
+	var ret Host
+	ls := m.lookupAll(name)
+	removed := len(ls) > 0
+	if removed {
+		ret = *ls[0].host
+	}
+	for _, l := range ls {
+		m.unindex(l)
+		ix := m.position(l)
+		m.lines = append(m.lines[:ix], m.lines[ix+1:]...)
+		log.Printf("etchosts: removed [[%s]]", l.host)
+	}
+	log.Printf("etchosts: removed %s -> %v", name, removed)
 	return ret, removed
 }
 
+// Replace changes in place the Host whose canonical hostname is name.
+// If name has both an IPv4 and an IPv6 entry, the one in the same family as h is replaced.
+func (m *Conf) Replace(name string, h Host) error {
+	l, ok := m.find(name, func(x *Host) bool {
+		return strings.EqualFold(x.CanonicalHostname, name) && x.SameFamily(h)
+	})
+	if !ok {
+		l, ok = m.lookup(name)
+	}
+	if !ok {
+		return ErrNotFoundHostname
+	}
+	return m.replace(l, h)
+}
+
+func (m *Conf) replace(l *line, h Host) error {
+	if h.CanonicalHostname == "" {
+		return ErrMissingHostname
+	}
+	if h.Address == nil {
+		return ErrBadIPFormat
+	}
+	if err := m.duplicate(h, l); err != nil {
+		return err
+	}
+	nl := &line{
+		host: &h,
+	}
+	for ix, x := range m.lines {
+		if x == l {
+			m.lines[ix] = nl
+			break
+		}
+	}
+	m.unindex(l)
+	m.index(nl)
+	log.Printf("etchosts: replaced [[%s]] -> [[%s]]", l.host, h)
+	return nil
+}
+
 func (m *Conf) GetByAddress(addr string) (Host, error) {
 	var ret Host
 	var err error = ErrNotFoundAddress
@@ -200,9 +463,9 @@ func (m *Conf) GetByAddress(addr string) (Host, error) {
 	defer func() {
 		log.Printf("etchosts: GetByAddress(%s) -> (%s, %v)", addr, ret, err)
 	}()
-	for _, h := range m.hosts {
-		if h.Address.Equal(ipAddr) {
-			ret = h
+	for _, l := range m.lines {
+		if l.host != nil && l.host.Address.Equal(ipAddr) {
+			ret = *l.host
 			err = nil
 			break
 		}
@@ -210,41 +473,143 @@ func (m *Conf) GetByAddress(addr string) (Host, error) {
 	return ret, err
 }
 
+// GetAllByHostname returns all the Hosts whose canonical hostname is name, in file order
+func (m *Conf) GetAllByHostname(name string) []Host {
+	var ret []Host
+	for _, l := range m.lookupAll(name) {
+		ret = append(ret, *l.host)
+	}
+	return ret
+}
+
 func (m *Conf) GetByHostname(name string) (Host, error) {
 	var ret Host
 	var err error = ErrNotFoundHostname
 	defer func() {
 		log.Printf("etchosts: GetByHostname(%s) -> (%s, %v)", name, ret, err)
 	}()
-	ret, ok := m.hosts[name]
+	l, ok := m.lookup(name)
 	if ok {
+		ret = *l.host
 		err = nil
 	}
 	return ret, err
 }
 
 func (m *Conf) GetByAlias(alias string) (Host, error) {
-	return Host{}, nil
+	var ret Host
+	var err error = ErrNotFoundAlias
+	defer func() {
+		log.Printf("etchosts: GetByAlias(%s) -> (%s, %v)", alias, ret, err)
+	}()
+	l, ok := m.find(alias, func(h *Host) bool {
+		return !strings.EqualFold(h.CanonicalHostname, alias)
+	})
+	if ok {
+		ret = *l.host
+		err = nil
+	}
+	return ret, err
+}
+
+// Resolve returns all the addresses the name resolves to, in file order, like dnsmasq would answer
+func (m *Conf) Resolve(name string) []net.IP {
+	var ret []net.IP
+	for _, l := range m.lines {
+		if l.host != nil && l.host.HasName(name) {
+			ret = append(ret, l.host.Address)
+		}
+	}
+	return ret
+}
+
+// AddAlias adds the alias to the Hosts whose canonical hostname is name, IPv4 and IPv6 alike,
+// and returns the first one in file order.
+// Adding an alias the Host already has is not an error, and it is reported as present.
+func (m *Conf) AddAlias(name, alias string) (Host, error, bool) {
+	ls := m.lookupAll(name)
+	if len(ls) == 0 {
+		return Host{}, ErrNotFoundHostname, false
+	}
+	if alias == "" {
+		return *ls[0].host, ErrMissingHostname, false
+	}
+	if ls[0].host.HasName(alias) {
+		return *ls[0].host, nil, true
+	}
+	// check all the families first, so a conflict changes nothing
+	var hs []Host
+	for _, l := range ls {
+		h := *l.host
+		h.Aliases = append(append([]string{}, l.host.Aliases...), alias)
+		if err := m.duplicate(h, l); err != nil {
+			return *ls[0].host, err, false
+		}
+		hs = append(hs, h)
+	}
+	for ix, l := range ls {
+		m.replace(l, hs[ix])
+	}
+	return hs[0], nil, false
+}
+
+// RemoveAlias removes the alias from the Hosts whose canonical hostname is name, IPv4 and IPv6 alike,
+// and returns the first one in file order.
+func (m *Conf) RemoveAlias(name, alias string) (Host, bool) {
+	ls := m.lookupAll(name)
+	if len(ls) == 0 {
+		return Host{}, false
+	}
+	var ret Host
+	removed := false
+	for ix, l := range ls {
+		h := *l.host
+		h.Aliases = nil
+		for _, a := range l.host.Aliases {
+			if !strings.EqualFold(a, alias) {
+				h.Aliases = append(h.Aliases, a)
+			}
+		}
+		if ix == 0 {
+			ret = h
+		}
+		if len(h.Aliases) == len(l.host.Aliases) {
+			continue
+		}
+		// removing a name can't introduce duplicates
+		m.replace(l, h)
+		removed = true
+	}
+	return ret, removed
 }
 
 // Parse creates a Conf from a reader, which must return content in etchosts (man 5 hosts) format
+// Lines which can't be used as entries are logged and kept verbatim.
 func Parse(r io.Reader) (*Conf, error) {
 	m := NewConf()
 	s := bufio.NewScanner(r)
 	for s.Scan() {
 		var err error
 		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, commentMarker) {
+			m.addRaw(line)
+			continue
+		}
+
 		h, err := ParseHostString(line)
 		if err != nil {
 			log.Printf("etchosts: error parsing '%s': %v", line, err)
+			m.addRaw(line)
 			continue
 		}
 
-		err = m.add(h)
+		err = m.add(h, line)
 		if err != nil {
 			log.Printf("etchosts: error adding '%s': %v", h, err)
+			m.addRaw(line)
 			continue
 		}
 	}
-	return m, nil
+	return m, s.Err()
 }
@@ -0,0 +1,376 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package etchosts
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"testing"
+)
+
+func TestHostParseError(t *testing.T) {
+	var err error
+
+	_, err = ParseHostString("")
+	if err != ErrBadEntryFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = ParseHostString("1.1.1.1")
+	if err != ErrBadEntryFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = ParseHostString("1.1.1.1 # host")
+	if err != ErrBadEntryFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = ParseHostString("malformed_IP host")
+	if err != ErrBadIPFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHostParseWhitespaceAndComments(t *testing.T) {
+	h, err := ParseHostString("192.168.1.9\tserver.test.lan\t\tserver   nas # the NAS")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.CanonicalHostname != "server.test.lan" {
+		t.Errorf("unexpected hostname: %q", h.CanonicalHostname)
+	}
+	if len(h.Aliases) != 2 || h.Aliases[0] != "server" || h.Aliases[1] != "nas" {
+		t.Errorf("unexpected aliases: %v", h.Aliases)
+	}
+}
+
+const testData string = "" +
+	"# static entries\n" +
+	"127.0.0.1   localhost localhost.localdomain\n" +
+	"\n" +
+	"192.168.1.1\tgateway.test.lan\tgateway router # the router\n" +
+	"this line is garbage\n" +
+	"192.168.1.9\tserver.test.lan\t\tserver\n" +
+	"# end of static entries\n" +
+	""
+
+func TestConfRoundTrip(t *testing.T) {
+	m, err := Parse(strings.NewReader(testData))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	L := m.Len()
+	if L != 3 {
+		t.Errorf("unexpected number of entries: %v", L)
+	}
+	if x := m.String(); x != testData {
+		t.Errorf("inconsistent content:\n%v\n%v\n", x, testData)
+	}
+}
+
+func TestConfChangesOnlyTouchedEntries(t *testing.T) {
+	m, err := Parse(strings.NewReader(testData))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+
+	_, removed := m.Remove("gateway.test.lan")
+	if !removed {
+		t.Errorf("failed to remove existing entry")
+	}
+	_, err, _ = m.Add("client.test.lan", "192.168.1.63", []string{"client"})
+	if err != nil {
+		t.Errorf("unexpected error adding: %v", err)
+	}
+
+	expected := "" +
+		"# static entries\n" +
+		"127.0.0.1   localhost localhost.localdomain\n" +
+		"\n" +
+		"this line is garbage\n" +
+		"192.168.1.9\tserver.test.lan\t\tserver\n" +
+		"# end of static entries\n" +
+		"192.168.1.63\tclient.test.lan\tclient\n" +
+		""
+	if x := m.String(); x != expected {
+		t.Errorf("inconsistent content:\n%v\n%v\n", x, expected)
+	}
+}
+
+func TestConfLookup(t *testing.T) {
+	m, err := Parse(strings.NewReader(testData))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+
+	h, err := m.GetByAddress("192.168.1.1")
+	if err != nil || h.CanonicalHostname != "gateway.test.lan" {
+		t.Errorf("unexpected result: %v %v", h, err)
+	}
+	h, err = m.GetByHostname("server.test.lan")
+	if err != nil || h.Address.String() != "192.168.1.9" {
+		t.Errorf("unexpected result: %v %v", h, err)
+	}
+	_, err = m.GetByHostname("missing.test.lan")
+	if err != ErrNotFoundHostname {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = m.GetByAddress("192.168.1.254")
+	if err != ErrNotFoundAddress {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// names are case-insensitive
+	h, err = m.GetByHostname("Server.Test.LAN")
+	if err != nil || h.Address.String() != "192.168.1.9" {
+		t.Errorf("unexpected result: %v %v", h, err)
+	}
+	h, err = m.GetByAlias("ROUTER")
+	if err != nil || h.CanonicalHostname != "gateway.test.lan" {
+		t.Errorf("unexpected result: %v %v", h, err)
+	}
+	_, err, _ = m.Add("SERVER", "192.168.1.10", nil)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckHostname(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{"client", nil},
+		{"client.test.lan", nil},
+		{"client.test.lan.", nil},
+		{"Client-01.Test.lan", nil},
+		{"3com", nil},
+		{"", ErrMissingHostname},
+		{"my client", ErrBadHostname},
+		{"client\tnas", ErrBadHostname},
+		{"my_client", ErrBadHostname},
+		{"-client", ErrBadHostname},
+		{"client-.lan", ErrBadHostname},
+		{"client..lan", ErrBadHostname},
+		{strings.Repeat("a", 64) + ".lan", ErrBadHostname},
+		{strings.Repeat("a.", 127) + "lan", ErrBadHostname},
+	}
+	for _, tc := range testCases {
+		err := CheckHostname(tc.name)
+		if !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+			t.Errorf("%q: unexpected error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestConfAliases(t *testing.T) {
+	m, err := Parse(strings.NewReader(testData))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+
+	h, err := m.GetByAlias("server")
+	if err != nil || h.CanonicalHostname != "server.test.lan" {
+		t.Errorf("unexpected result: %v %v", h, err)
+	}
+	_, err = m.GetByAlias("server.test.lan")
+	if err != ErrNotFoundAlias {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// names clash regardless of being canonical or aliases
+	_, err, _ = m.Add("server", "192.168.1.70", nil)
+	if err == nil {
+		t.Errorf("unexpected success adding a name used as alias")
+	}
+	_, err, _ = m.Add("new.test.lan", "192.168.1.70", []string{"server.test.lan"})
+	if err == nil {
+		t.Errorf("unexpected success adding an alias used as name")
+	}
+	_, err, _ = m.AddAlias("gateway.test.lan", "server")
+	if err == nil {
+		t.Errorf("unexpected success adding an alias used by another entry")
+	}
+
+	h, err, present := m.AddAlias("gateway.test.lan", "gw")
+	if err != nil || present || len(h.Aliases) != 3 {
+		t.Errorf("unexpected result adding an alias: %v %v %v", h, err, present)
+	}
+	_, err, present = m.AddAlias("gateway.test.lan", "gw")
+	if err != nil || !present {
+		t.Errorf("unexpected result adding an alias twice: %v %v", err, present)
+	}
+	h, err = m.GetByAlias("gw")
+	if err != nil || h.CanonicalHostname != "gateway.test.lan" {
+		t.Errorf("unexpected result: %v %v", h, err)
+	}
+	h, removed := m.RemoveAlias("gateway.test.lan", "gateway")
+	if !removed || len(h.Aliases) != 2 || h.Aliases[0] != "router" {
+		t.Errorf("unexpected result removing an alias: %v %v", h, removed)
+	}
+	_, removed = m.RemoveAlias("gateway.test.lan", "gateway")
+	if removed {
+		t.Errorf("unexpected success removing a missing alias")
+	}
+}
+
+func TestConfNameConflicts(t *testing.T) {
+	m := NewConf()
+	_, err, _ := m.Add("a", "192.168.1.2", []string{"b", "c"})
+	if err != nil {
+		t.Fatalf("unexpected error adding: %v", err)
+	}
+
+	_, err, _ = m.Add("x", "192.168.1.3", []string{"c"})
+	ce, ok := err.(*ConflictError)
+	if !ok || ce.What != "c" || ce.Entry.CanonicalHostname != "a" {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("conflict is not a duplicate: %v", err)
+	}
+	_, err, _ = m.Add("b", "192.168.1.3", nil)
+	if ce, ok = err.(*ConflictError); !ok || ce.What != "b" {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+
+	// an alias is not a canonical hostname
+	_, err = m.GetByHostname("b")
+	if err != ErrNotFoundHostname {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, removed := m.Remove("c"); removed {
+		t.Errorf("unexpected removal by alias")
+	}
+
+	// names are released together with their entry
+	m.Remove("a")
+	_, err, _ = m.Add("x", "192.168.1.3", []string{"c"})
+	if err != nil || m.Len() != 1 {
+		t.Errorf("unexpected error adding after removal: %v", err)
+	}
+	_, err = m.GetByAlias("b")
+	if err != ErrNotFoundAlias {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConfMultipleLinesPerAddress(t *testing.T) {
+	data := "" +
+		"127.0.0.1   localhost localhost.localdomain localhost4\n" +
+		"::1         localhost localhost.localdomain localhost6\n" +
+		"192.168.1.1\tgateway.test.lan\tgateway\n" +
+		"192.168.1.1\trouter.test.lan\trouter\n" +
+		""
+	m, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	if m.Len() != 4 {
+		t.Errorf("unexpected number of entries: %v", m.Len())
+	}
+
+	ips := m.Resolve("localhost")
+	if len(ips) != 2 || ips[0].String() != "127.0.0.1" || ips[1].String() != "::1" {
+		t.Errorf("unexpected resolution: %v", ips)
+	}
+	h, err := m.GetByAddress("192.168.1.1")
+	if err != nil || h.CanonicalHostname != "gateway.test.lan" {
+		t.Errorf("unexpected reverse lookup: %v %v", h, err)
+	}
+	h, err = m.GetByAlias("router")
+	if err != nil || h.CanonicalHostname != "router.test.lan" {
+		t.Errorf("unexpected lookup by alias: %v %v", h, err)
+	}
+
+	// one name per address family
+	_, err, _ = m.Add("localhost", "127.0.1.1", nil)
+	if err == nil {
+		t.Errorf("unexpected success adding an IPv4 name twice")
+	}
+	_, err, _ = m.Add("gateway.test.lan", "fd00::1", nil)
+	if err != nil {
+		t.Errorf("unexpected error adding an IPv6 address to a name: %v", err)
+	}
+
+	// untouched entries are kept verbatim
+	m.Remove("router.test.lan")
+	expected := "" +
+		"127.0.0.1   localhost localhost.localdomain localhost4\n" +
+		"::1         localhost localhost.localdomain localhost6\n" +
+		"192.168.1.1\tgateway.test.lan\tgateway\n" +
+		"fd00::1\tgateway.test.lan\n" +
+		""
+	if x := m.String(); x != expected {
+		t.Errorf("inconsistent content:\n%v\n%v\n", x, expected)
+	}
+}
+
+func TestConfDualStack(t *testing.T) {
+	data := "" +
+		"192.168.1.5\tlaptop.test.lan\tlaptop\n" +
+		"fd00::5\tlaptop.test.lan\tlaptop\n" +
+		""
+	m, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	hs := m.GetAllByHostname("laptop.test.lan")
+	if len(hs) != 2 || !hs[0].IsIPv4() || hs[1].IsIPv4() {
+		t.Fatalf("unexpected entries: %v", hs)
+	}
+
+	// each family is replaced on its own
+	err = m.Replace("laptop.test.lan", Host{
+		CanonicalHostname: "laptop.test.lan",
+		Address:           net.ParseIP("fd00::6"),
+		Aliases:           []string{"laptop"},
+	})
+	if err != nil {
+		t.Errorf("unexpected error replacing: %v", err)
+	}
+	ips := m.Resolve("laptop")
+	if len(ips) != 2 || ips[0].String() != "192.168.1.5" || ips[1].String() != "fd00::6" {
+		t.Errorf("unexpected resolution: %v", ips)
+	}
+
+	// aliases apply to both families
+	_, err, _ = m.AddAlias("laptop.test.lan", "notebook")
+	if err != nil {
+		t.Errorf("unexpected error adding alias: %v", err)
+	}
+	if ips = m.Resolve("notebook"); len(ips) != 2 {
+		t.Errorf("unexpected resolution: %v", ips)
+	}
+	if _, removed := m.RemoveAlias("laptop.test.lan", "notebook"); !removed {
+		t.Errorf("failed to remove alias")
+	}
+	if ips = m.Resolve("notebook"); len(ips) != 0 {
+		t.Errorf("unexpected resolution: %v", ips)
+	}
+
+	if _, removed := m.Remove("laptop.test.lan"); !removed || m.Len() != 0 {
+		t.Errorf("failed to remove both entries: %v", m.Hosts())
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// The filewatch package notices the changes to a set of files.
+// On linux it uses inotify on the directories holding the files, so files replaced
+// by a rename, as editors and dnsmasqmgrd itself do, are noticed as well.
+// Elsewhere, or if inotify is not available, the files are polled at a fixed interval.
+// Notifications only mean the files may have changed: receivers are expected to check.
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DefaultInterval time.Duration = 2 * time.Second
+)
+
+// stamp identifies a version of a watched file
+type stamp struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func takeStamp(path string) stamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+// Watcher sends on C when any of the watched files may have changed.
+// Notifications are coalesced: a receiver which falls behind gets only one.
+type Watcher struct {
+	C chan struct{}
+
+	paths    []string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New starts watching the given files. interval is how often the files are polled
+// when inotify is not available; DefaultInterval is used if zero.
+func New(paths []string, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	w := newWatcher(interval)
+	for _, path := range paths {
+		w.paths = append(w.paths, filepath.Clean(path))
+	}
+	// the files are watched before returning, so no change is missed
+	w.start(w.setup())
+	return w
+}
+
+func (w *Watcher) start(run func()) {
+	go func() {
+		run()
+		close(w.C)
+		close(w.done)
+	}()
+}
+
+func newWatcher(interval time.Duration) *Watcher {
+	return &Watcher{
+		C:        make(chan struct{}, 1),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Close stops watching, and closes C
+func (w *Watcher) Close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.C <- struct{}{}:
+	default:
+		// a notification is already pending
+	}
+}
+
+// polling takes the initial stamps of the files, and returns the loop which checks them
+// every interval until the watcher is closed
+func (w *Watcher) polling() func() {
+	stamps := make(map[string]stamp)
+	for _, path := range w.paths {
+		stamps[path] = takeStamp(path)
+	}
+
+	return func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				changed := false
+				for _, path := range w.paths {
+					st := takeStamp(path)
+					if st != stamps[path] {
+						changed = true
+					}
+					stamps[path] = st
+				}
+				if changed {
+					w.notify()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package filewatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func expect(t *testing.T, w *Watcher, want bool, what string) {
+	t.Helper()
+	timeout := 2 * time.Second
+	if !want {
+		timeout = 200 * time.Millisecond
+	}
+	select {
+	case <-w.C:
+		if !want {
+			t.Errorf("unexpected notification after %s", what)
+		}
+	case <-time.After(timeout):
+		if want {
+			t.Errorf("missing notification after %s", what)
+		}
+	}
+}
+
+func testWatcher(t *testing.T, start func(w *Watcher)) {
+	dir, err := ioutil.TempDir("", "filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644)
+
+	w := newWatcher(20 * time.Millisecond)
+	w.paths = []string{path}
+	start(w)
+
+	ioutil.WriteFile(filepath.Join(dir, "other"), []byte("unrelated\n"), 0644)
+	expect(t, w, false, "writing another file")
+
+	ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n10.0.0.1 router\n"), 0644)
+	expect(t, w, true, "writing the file")
+
+	tmp := filepath.Join(dir, ".hosts.tmp")
+	ioutil.WriteFile(tmp, []byte("127.0.0.1 localhost\n"), 0644)
+	os.Rename(tmp, path)
+	expect(t, w, true, "replacing the file")
+
+	w.Close()
+	if _, ok := <-w.C; ok {
+		t.Errorf("channel still open after Close")
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	testWatcher(t, func(w *Watcher) {
+		w.start(w.setup())
+	})
+}
+
+func TestWatcherPolling(t *testing.T) {
+	testWatcher(t, func(w *Watcher) {
+		w.start(w.polling())
+	})
+}
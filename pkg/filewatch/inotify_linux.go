@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package filewatch
+
+import (
+	"log"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask catches the files being written, replaced, created or removed
+const inotifyMask uint32 = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CREATE | unix.IN_DELETE | unix.IN_ATTRIB
+
+func (w *Watcher) setup() func() {
+	loop, err := w.inotify()
+	if err != nil {
+		log.Printf("filewatch: inotify not available (%v), polling every %v", err, w.interval)
+		return w.polling()
+	}
+	return loop
+}
+
+// inotify watches the directories of the files, and returns the loop which reads
+// the events until the watcher is closed
+func (w *Watcher) inotify() (func(), error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// the pipe wakes up the poll when the watcher is closed
+	wake := make([]int, 2)
+	err = unix.Pipe2(wake, unix.O_CLOEXEC|unix.O_NONBLOCK)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	closeAll := func() {
+		unix.Close(fd)
+		unix.Close(wake[0])
+		unix.Close(wake[1])
+	}
+
+	// names maps the watch descriptor of every directory to the names of the files watched in it
+	names := make(map[int]map[string]bool)
+	dirs := make(map[string]int)
+	for _, path := range w.paths {
+		dir, name := filepath.Split(path)
+		wd, ok := dirs[dir]
+		if !ok {
+			wd, err = unix.InotifyAddWatch(fd, dir, inotifyMask)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			dirs[dir] = wd
+			names[wd] = make(map[string]bool)
+		}
+		names[wd][name] = true
+	}
+
+	return func() {
+		defer closeAll()
+		go func() {
+			<-w.stop
+			unix.Write(wake[1], []byte{0})
+		}()
+
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		fds := []unix.PollFd{
+			{Fd: int32(fd), Events: unix.POLLIN},
+			{Fd: int32(wake[0]), Events: unix.POLLIN},
+		}
+		for {
+			_, err := unix.Poll(fds, -1)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Printf("filewatch: poll failed: %v", err)
+				return
+			}
+			if fds[1].Revents != 0 {
+				return
+			}
+			n, err := unix.Read(fd, buf)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Printf("filewatch: read failed: %v", err)
+				return
+			}
+			if changed(buf[:n], names) {
+				w.notify()
+			}
+		}
+	}, nil
+}
+
+// changed returns true if any of the inotify events in buf concerns a watched file
+func changed(buf []byte, names map[int]map[string]bool) bool {
+	ret := false
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		start := off + unix.SizeofInotifyEvent
+		end := start + int(ev.Len)
+		if end > len(buf) {
+			break
+		}
+		name := string(buf[start:end])
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 || names[int(ev.Wd)][name] {
+			ret = true
+		}
+		off = end
+	}
+	return ret
+}
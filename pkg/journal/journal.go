@@ -0,0 +1,189 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// The journal package reads and writes the log of the changes done by dnsmasqmgrd,
+// and the snapshots of the managed files the changes can be replayed on.
+// The journal is a sequence of JSON objects, one per line, each one carrying
+// the revision assigned to the change.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActionAdd     string = "add"
+	ActionDelete  string = "del"
+	ActionUpdate  string = "update"
+	ActionRestore string = "restore"
+	// ActionDeny records a request refused by the authorization policy, which changed nothing
+	ActionDeny string = "deny"
+	// ActionExternal records the edits done outside dnsmasqmgrd. Like restores,
+	// it refers to the snapshot with the same revision.
+	ActionExternal string = "external"
+	// ActionFix records the fixes of the problems found by Check, also through a snapshot
+	ActionFix string = "fix"
+)
+
+// legacyTimeLayout is the prefix log.LstdFlags added to the entries written by older releases
+const legacyTimeLayout string = "2006/01/02 15:04:05"
+
+var (
+	ErrBadEntry error = errors.New("Malformed journal entry")
+	ErrBadPoint error = errors.New("Malformed revision or time")
+)
+
+type Addr struct {
+	Hostname string `json:"hostname"`
+	Macaddr  string `json:"mac"`
+	Ipaddr   string `json:"ip"`
+	// Aliases is null in the entries written by older releases, which did not record them
+	Aliases  []string `json:"aliases"`
+	Ip6Addr  string   `json:"ip6,omitempty"`
+	ClientID string   `json:"clientid,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type Entry struct {
+	Revision int64     `json:"revision"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Address  *Addr     `json:"address,omitempty"`
+	// Previous is set only for updates
+	Previous *Addr `json:"previous,omitempty"`
+	// Until is the point the files were brought back to, set only for restores
+	Until string `json:"until,omitempty"`
+	// Identity and Method are the client and the RPC refused, set only for denials.
+	// Denials keep the revision of the last change.
+	Identity string `json:"identity,omitempty"`
+	Method   string `json:"method,omitempty"`
+	// Repeated counts the denials of the same identity and method left out since the previous one recorded
+	Repeated int `json:"repeated,omitempty"`
+}
+
+// ParseEntry decodes a line of the journal. Entries written by older releases,
+// prefixed by a timestamp, are accepted as well.
+func ParseEntry(s string) (Entry, error) {
+	var e Entry
+	pos := strings.Index(s, "{")
+	if pos == -1 {
+		return e, ErrBadEntry
+	}
+	err := json.Unmarshal([]byte(s[pos:]), &e)
+	if err != nil || e.Action == "" {
+		return e, ErrBadEntry
+	}
+	if e.Time.IsZero() && pos > 0 {
+		e.Time, _ = time.ParseInLocation(legacyTimeLayout, strings.TrimSpace(s[:pos]), time.Local)
+	}
+	return e, nil
+}
+
+// Parse decodes all the entries of a journal. The entries written by older releases, which have
+// no revision, are numbered in order after the last revision seen.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var rev int64
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		e, err := ParseEntry(s)
+		if err != nil {
+			return entries, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if e.Revision == 0 && e.Action != ActionDeny {
+			// the entries written by older releases have no revision: number them in order
+			e.Revision = rev + 1
+		}
+		if e.Revision > rev {
+			rev = e.Revision
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// LastRevision returns the highest revision found in the entries
+func LastRevision(entries []Entry) int64 {
+	var rev int64
+	for _, e := range entries {
+		if e.Revision > rev {
+			rev = e.Revision
+		}
+	}
+	return rev
+}
+
+// Point is a point in the history of the managed files, either a revision or a time
+type Point struct {
+	Revision int64
+	Time     time.Time
+}
+
+// Latest is the Point which includes all the changes
+var Latest = Point{Revision: math.MaxInt64}
+
+// ParsePoint accepts either a revision number or a RFC3339 time
+func ParsePoint(s string) (Point, error) {
+	if rev, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if rev < 0 {
+			return Point{}, ErrBadPoint
+		}
+		return Point{Revision: rev}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Point{}, ErrBadPoint
+	}
+	return Point{Time: t}, nil
+}
+
+// Includes returns true if a change done at the given revision and time happened before the point
+func (p Point) Includes(rev int64, t time.Time) bool {
+	if !p.Time.IsZero() {
+		return !t.After(p.Time)
+	}
+	return rev <= p.Revision
+}
+
+func (p Point) String() string {
+	if !p.Time.IsZero() {
+		return p.Time.Format(time.RFC3339)
+	}
+	if p == Latest {
+		return "latest"
+	}
+	return strconv.FormatInt(p.Revision, 10)
+}
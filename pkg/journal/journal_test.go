@@ -0,0 +1,238 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	data := "" +
+		`2019/05/12 10:11:12 {"revision":1,"action":"add","address":{"hostname":"a.test.lan","mac":"02:00:00:00:00:01","ip":"192.168.1.2"}}` + "\n" +
+		"\n" +
+		`{"revision":2,"time":"2019-05-12T10:20:00Z","action":"del","address":{"hostname":"a.test.lan","mac":"02:00:00:00:00:01","ip":"192.168.1.2"}}` + "\n"
+	entries, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error parsing the journal: %v", err)
+	}
+	if len(entries) != 2 || LastRevision(entries) != 2 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if entries[0].Time.IsZero() || entries[0].Address.Hostname != "a.test.lan" {
+		t.Errorf("unexpected legacy entry: %v", entries[0])
+	}
+	if entries[1].Action != ActionDelete || entries[1].Time.Minute() != 20 {
+		t.Errorf("unexpected entry: %v", entries[1])
+	}
+
+	// the entries written by older releases have no revision
+	data = "" +
+		`2019/05/12 10:11:12 {"action":"add","address":{"hostname":"a.test.lan","mac":"02:00:00:00:00:01","ip":"192.168.1.2"}}` + "\n" +
+		`2019/05/12 10:11:13 {"action":"del","address":{"hostname":"a.test.lan","mac":"02:00:00:00:00:01","ip":"192.168.1.2"}}` + "\n" +
+		`{"revision":0,"time":"2019-05-12T10:20:00Z","action":"deny","identity":"anonymous","method":"DeleteAddress"}` + "\n"
+	entries, err = Parse(strings.NewReader(data))
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("unexpected legacy entries: %v %v", entries, err)
+	}
+	if entries[0].Revision != 1 || entries[1].Revision != 2 || entries[2].Revision != 0 {
+		t.Errorf("unexpected revisions of the legacy entries: %v", entries)
+	}
+
+	_, err = Parse(strings.NewReader("garbage\n"))
+	if err == nil {
+		t.Errorf("unexpected success parsing garbage")
+	}
+}
+
+func TestParsePoint(t *testing.T) {
+	p, err := ParsePoint("42")
+	if err != nil || p.Revision != 42 || !p.Includes(42, time.Now()) || p.Includes(43, time.Time{}) {
+		t.Errorf("unexpected revision point: %v %v", p, err)
+	}
+	p, err = ParsePoint("2019-05-12T10:15:00Z")
+	if err != nil {
+		t.Fatalf("unexpected error parsing a time: %v", err)
+	}
+	before := time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC)
+	if !p.Includes(1000, before) || p.Includes(1, before.Add(time.Hour)) {
+		t.Errorf("unexpected time point: %v", p)
+	}
+	if _, err = ParsePoint("yesterday"); err != ErrBadPoint {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !Latest.Includes(1<<40, time.Now()) {
+		t.Errorf("Latest does not include everything")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, rev := range []int64{0, 3, 10} {
+		err = WriteSnapshot(dir, &Snapshot{
+			Revision: rev,
+			Time:     time.Unix(rev*60, 0),
+			Hosts:    "127.0.0.1\tlocalhost\n",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error writing a snapshot: %v", err)
+		}
+	}
+
+	snap, err := FindSnapshot(dir, Point{Revision: 9})
+	if err != nil || snap.Revision != 3 {
+		t.Errorf("unexpected snapshot: %v %v", snap, err)
+	}
+	snap, err = FindSnapshot(dir, Latest)
+	if err != nil || snap.Revision != 10 {
+		t.Errorf("unexpected snapshot: %v %v", snap, err)
+	}
+	snap, err = FindSnapshot(dir, Point{Time: time.Unix(60, 0)})
+	if err != nil || snap.Revision != 0 {
+		t.Errorf("unexpected snapshot: %v %v", snap, err)
+	}
+	_, err = LoadSnapshot(dir, 4)
+	if err != ErrNoSnapshot {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRotateCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal.json")
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error opening the journal: %v", err)
+	}
+	w.MaxSize = 200
+	start := time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC)
+	for rev := int64(1); rev <= 6; rev++ {
+		err = w.Append(&Entry{
+			Revision: rev,
+			Time:     start.Add(time.Duration(rev) * time.Minute),
+			Action:   ActionAdd,
+			Address:  &Addr{Hostname: "a.test.lan", Macaddr: "02:00:00:00:00:01", Ipaddr: "192.168.1.2"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error appending: %v", err)
+		}
+	}
+	w.MaxSize = 0
+	w.MaxAge = time.Hour
+	if err = w.MaybeRotate(start.Add(30 * time.Minute)); err != nil {
+		t.Fatalf("unexpected error rotating: %v", err)
+	}
+	if err = w.MaybeRotate(start.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("unexpected error rotating: %v", err)
+	}
+	w.Close()
+
+	segments, _ := Segments(path)
+	if len(segments) != 3 {
+		t.Fatalf("unexpected segments: %v", segments)
+	}
+	entries, err := Read(path)
+	if err != nil || len(entries) != 6 || entries[5].Revision != 6 {
+		t.Fatalf("unexpected entries across segments: %v %v", entries, err)
+	}
+
+	snapDir := filepath.Join(dir, "snapshots")
+	os.Mkdir(snapDir, 0755)
+	for _, rev := range []int64{0, 2, 4} {
+		WriteSnapshot(snapDir, &Snapshot{Revision: rev})
+	}
+	err = Compact(path, snapDir, 2)
+	if err != nil {
+		t.Fatalf("unexpected error compacting: %v", err)
+	}
+	snaps, _ := Snapshots(snapDir)
+	if len(snaps) != 2 {
+		t.Errorf("unexpected snapshots left: %v", snaps)
+	}
+	entries, err = Read(path)
+	if err != nil || len(entries) == 0 || entries[0].Revision > 3 || entries[len(entries)-1].Revision != 6 {
+		t.Errorf("unexpected entries after compaction: %v %v", entries, err)
+	}
+	segments, _ = Segments(path)
+	if len(segments) != 2 {
+		t.Errorf("unexpected segments after compaction: %v", segments)
+	}
+}
+
+func TestRotateSameRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal.json")
+	start := time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		// reopening starts over from an empty active file, like a restart does
+		w, err := Open(path)
+		if err != nil {
+			t.Fatalf("unexpected error opening the journal: %v", err)
+		}
+		err = w.Append(&Entry{
+			Revision: 4,
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			Action:   ActionDeny,
+			Address:  &Addr{Hostname: "a.test.lan"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error appending: %v", err)
+		}
+		if err = w.Rotate(); err != nil {
+			t.Fatalf("unexpected error rotating: %v", err)
+		}
+		w.Close()
+	}
+
+	segments, _ := Segments(path)
+	if len(segments) != 3 {
+		t.Fatalf("unexpected segments: %v", segments)
+	}
+	entries, err := Read(path)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("unexpected entries across segments: %v %v", entries, err)
+	}
+	for i, e := range entries {
+		if !e.Time.Equal(start.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("entry %d out of order: %v", i, e)
+		}
+	}
+}
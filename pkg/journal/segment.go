@@ -0,0 +1,285 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentDigits is the width of the revision suffix of the segment names
+const segmentDigits int = 16
+
+// seqDigits is the width of the sequence number telling apart the segments ending at the same revision
+const seqDigits int = 6
+
+// the journal is made by the active file, where the new entries are appended,
+// plus the older segments rotated out of it. Each segment is named after the
+// active file plus the last revision it contains, so the names sort as the content.
+// Segments ending at the same revision, like the ones holding only denials,
+// get a sequence number after the first one.
+func segmentPath(path string, lastRev int64, seq int) string {
+	if seq == 0 {
+		return fmt.Sprintf("%s.%0*d", path, segmentDigits, lastRev)
+	}
+	return fmt.Sprintf("%s.%0*d-%0*d", path, segmentDigits, lastRev, seqDigits, seq)
+}
+
+func segmentRevision(path, segment string) (int64, bool) {
+	suffix := strings.TrimPrefix(segment, path+".")
+	if len(suffix) == segmentDigits+1+seqDigits && suffix[segmentDigits] == '-' {
+		if _, err := strconv.ParseUint(suffix[segmentDigits+1:], 10, 32); err != nil {
+			return 0, false
+		}
+		suffix = suffix[:segmentDigits]
+	}
+	if len(suffix) != segmentDigits {
+		return 0, false
+	}
+	rev, err := strconv.ParseInt(suffix, 10, 64)
+	return rev, err == nil
+}
+
+// Segments returns the paths of the segments rotated out of the journal at path, oldest first
+func Segments(path string) ([]string, error) {
+	candidates, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, candidate := range candidates {
+		if _, ok := segmentRevision(path, candidate); ok {
+			segments = append(segments, candidate)
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func readFile(path string) ([]Entry, error) {
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	entries, err := Parse(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// Read decodes all the entries of the journal stored at path, segments included. A missing journal is empty.
+func Read(path string) ([]Entry, error) {
+	segments, err := Segments(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, p := range append(segments, path) {
+		items, err := readFile(p)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, items...)
+	}
+	return entries, nil
+}
+
+// Writer appends entries to a journal, rotating the active file when it grows too big or too old
+type Writer struct {
+	// MaxSize is the size in bytes past which the active file is rotated. Zero means no limit.
+	MaxSize int64
+	// MaxAge is the age of the oldest entry past which the active file is rotated. Zero means no limit.
+	MaxAge time.Duration
+
+	path    string
+	fh      *os.File
+	size    int64
+	started time.Time
+	lastRev int64
+}
+
+// Open prepares the journal at path for appending, creating it if needed
+func Open(path string) (*Writer, error) {
+	w := Writer{path: path}
+	entries, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		w.started = entries[0].Time
+		w.lastRev = LastRevision(entries)
+	}
+	err = w.open()
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (w *Writer) open() error {
+	fh, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return err
+	}
+	w.fh = fh
+	w.size = info.Size()
+	return nil
+}
+
+// Append writes the entry as a single line
+func (w *Writer) Append(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := w.fh.Write(append(data, '\n'))
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if w.started.IsZero() {
+		w.started = e.Time
+	}
+	if e.Revision > w.lastRev {
+		w.lastRev = e.Revision
+	}
+	return w.MaybeRotate(e.Time)
+}
+
+// MaybeRotate rotates the active file if it is past the size or age limits
+func (w *Writer) MaybeRotate(now time.Time) error {
+	if w.MaxSize > 0 && w.size >= w.MaxSize {
+		return w.Rotate()
+	}
+	if w.MaxAge > 0 && !w.started.IsZero() && now.Sub(w.started) >= w.MaxAge {
+		return w.Rotate()
+	}
+	return nil
+}
+
+// Rotate moves the content of the active file in a new segment. An empty active file is left alone.
+// An existing segment is never overwritten.
+func (w *Writer) Rotate() error {
+	if w.size == 0 {
+		return nil
+	}
+	err := w.fh.Close()
+	if err != nil {
+		return err
+	}
+	segment, err := w.moveOut()
+	if err != nil {
+		// keep appending to the current file
+		if oerr := w.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	log.Printf("journal: rotated %v to %v", w.path, segment)
+	w.started = time.Time{}
+	return w.open()
+}
+
+// moveOut renames the active file to the first free segment name. Linking, unlike renaming, fails
+// if the name is taken, so a segment rotated at the same revision, or before a restart, is kept.
+func (w *Writer) moveOut() (string, error) {
+	for seq := 0; ; seq++ {
+		segment := segmentPath(w.path, w.lastRev, seq)
+		err := os.Link(w.path, segment)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err = os.Remove(w.path); err != nil {
+			// don't read the same entries twice
+			os.Remove(segment)
+			return "", err
+		}
+		return segment, nil
+	}
+}
+
+func (w *Writer) Close() error {
+	return w.fh.Close()
+}
+
+// Compact drops the snapshots in snapDir but the keep most recent ones (zero means keep all),
+// then drops the journal segments already covered by the oldest snapshot left.
+func Compact(path, snapDir string, keep int) error {
+	snaps, err := Snapshots(snapDir)
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		return nil
+	}
+	if keep > 0 && len(snaps) > keep {
+		for _, snap := range snaps[:len(snaps)-keep] {
+			err = os.Remove(snap)
+			if err != nil {
+				return err
+			}
+			log.Printf("journal: dropped snapshot %v", snap)
+		}
+		snaps = snaps[len(snaps)-keep:]
+	}
+	oldest, err := ReadSnapshot(snaps[0])
+	if err != nil {
+		return err
+	}
+
+	segments, err := Segments(path)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		rev, _ := segmentRevision(path, segment)
+		if rev > oldest.Revision {
+			break
+		}
+		err = os.Remove(segment)
+		if err != nil {
+			return err
+		}
+		log.Printf("journal: dropped segment %v, covered by the snapshot at revision %d", segment, oldest.Revision)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const snapshotPattern string = "snapshot-*.json"
+
+var (
+	ErrNoSnapshot error = errors.New("No suitable snapshot found")
+)
+
+// Snapshot is the content of the managed files at a given revision
+type Snapshot struct {
+	Revision int64     `json:"revision"`
+	Time     time.Time `json:"time"`
+	Hosts    string    `json:"hosts"`
+	Leases   string    `json:"leases"`
+}
+
+func snapshotPath(dir string, rev int64) string {
+	return filepath.Join(dir, fmt.Sprintf("snapshot-%016d.json", rev))
+}
+
+// WriteSnapshot stores atomically the snapshot in dir, replacing any previous one with the same revision
+func WriteSnapshot(dir string, snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	path := snapshotPath(dir, snap.Revision)
+	tmp := filepath.Join(dir, "."+filepath.Base(path)+".tmp")
+	fh, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadSnapshot loads the snapshot stored at path
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := Snapshot{}
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &snap, nil
+}
+
+// LoadSnapshot loads the snapshot taken at the given revision
+func LoadSnapshot(dir string, rev int64) (*Snapshot, error) {
+	snap, err := ReadSnapshot(snapshotPath(dir, rev))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSnapshot
+	}
+	return snap, err
+}
+
+// Snapshots returns the paths of the snapshots in dir, oldest first
+func Snapshots(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, snapshotPattern))
+	if err != nil {
+		return nil, err
+	}
+	// the revision is zero-padded, so the names sort as the revisions
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// FindSnapshot returns the most recent snapshot taken before the given point
+func FindSnapshot(dir string, p Point) (*Snapshot, error) {
+	paths, err := Snapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	for ix := len(paths) - 1; ix >= 0; ix-- {
+		snap, err := ReadSnapshot(paths[ix])
+		if err != nil {
+			return nil, err
+		}
+		if p.Includes(snap.Revision, snap.Time) {
+			return snap, nil
+		}
+	}
+	return nil, ErrNoSnapshot
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// The reloader package makes dnsmasq reload its configuration when the files
+// managed by dnsmasqmgrd change.
+// dnsmasq rereads the addn-hosts and dhcp-hostsfile files when it receives SIGHUP,
+// so all we need to do is to notice the changes and send the signal to the process
+// whose pid is recorded in the dnsmasq pidfile.
+// Writes from dnsmasqmgrd come in bursts (one per file, per request), so changes
+// are debounced, and reloads are rate-limited by a cooldown period.
+package reloader
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultPidFile  string        = "/var/run/dnsmasq.pid"
+	DefaultInterval time.Duration = 1 * time.Second
+	DefaultDebounce time.Duration = 2 * time.Second
+	DefaultCooldown time.Duration = 10 * time.Second
+)
+
+var (
+	ErrBadPidFile error = errors.New("Malformed pid file")
+)
+
+// Signaler delivers a signal to the process identified by pid
+type Signaler func(pid int, sig os.Signal) error
+
+// stamp identifies a version of a watched file
+type stamp struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func takeStamp(path string) stamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+// Reloader watches a set of files and signals dnsmasq when they change
+type Reloader struct {
+	// PidFile is the path of the pidfile written by dnsmasq
+	PidFile string
+	// Paths are the files to watch
+	Paths []string
+	// Interval is how often the files are checked for changes
+	Interval time.Duration
+	// Debounce is how long the files must stay unchanged before a reload is triggered
+	Debounce time.Duration
+	// Cooldown is the minimum time between two reloads
+	Cooldown time.Duration
+	// Signal delivers the reload signal. Tests can override it.
+	Signal Signaler
+
+	stamps     map[string]stamp
+	lastReload time.Time
+}
+
+// New creates a Reloader with default settings
+func New(pidFile string, paths []string) *Reloader {
+	return &Reloader{
+		PidFile:  pidFile,
+		Paths:    paths,
+		Interval: DefaultInterval,
+		Debounce: DefaultDebounce,
+		Cooldown: DefaultCooldown,
+		Signal:   signalProcess,
+		stamps:   make(map[string]stamp),
+	}
+}
+
+func signalProcess(pid int, sig os.Signal) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return proc.Signal(sig)
+}
+
+// ReadPidFile returns the pid stored in the given pidfile
+func ReadPidFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, ErrBadPidFile
+	}
+	return pid, nil
+}
+
+// Reload signals dnsmasq to reload its configuration, regardless of the cooldown
+func (r *Reloader) Reload() error {
+	pid, err := ReadPidFile(r.PidFile)
+	if err != nil {
+		return err
+	}
+	err = r.Signal(pid, syscall.SIGHUP)
+	if err != nil {
+		return err
+	}
+	r.lastReload = time.Now()
+	log.Printf("reloader: sent SIGHUP to dnsmasq (pid=%d)", pid)
+	return nil
+}
+
+// changed returns true if any of the watched files changed since the last check
+func (r *Reloader) changed() bool {
+	ret := false
+	for _, path := range r.Paths {
+		st := takeStamp(path)
+		if prev, ok := r.stamps[path]; ok && prev != st {
+			log.Printf("reloader: detected change on '%s'", path)
+			ret = true
+		}
+		r.stamps[path] = st
+	}
+	return ret
+}
+
+// Run watches the files until the stop channel is closed
+func (r *Reloader) Run(stop <-chan struct{}) {
+	// first round just records the initial state
+	r.changed()
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	pending := false
+	var lastChange time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if r.changed() {
+				pending = true
+				lastChange = now
+			}
+			if !pending || now.Sub(lastChange) < r.Debounce || now.Sub(r.lastReload) < r.Cooldown {
+				continue
+			}
+			err := r.Reload()
+			if err != nil {
+				// we will retry once the cooldown expires
+				log.Printf("reloader: reload failed: %v", err)
+				r.lastReload = now
+				continue
+			}
+			pending = false
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package reloader
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is not a real test: it is the fake dnsmasq process
+// the other tests send signals to.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("RELOADER_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	fmt.Println("ready")
+	for range sigs {
+		fmt.Println("reloaded")
+	}
+}
+
+type fakeDNSMasq struct {
+	cmd     *exec.Cmd
+	reloads chan string
+}
+
+func startFakeDNSMasq(t *testing.T, pidFile string) *fakeDNSMasq {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), "RELOADER_WANT_HELPER_PROCESS=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("unexpected error setting up the fake process: %v", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatalf("unexpected error starting the fake process: %v", err)
+	}
+
+	fd := &fakeDNSMasq{
+		cmd:     cmd,
+		reloads: make(chan string, 16),
+	}
+	go func() {
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			fd.reloads <- s.Text()
+		}
+	}()
+	if line := fd.wait(t, 5*time.Second); line != "ready" {
+		fd.stop()
+		t.Fatalf("unexpected output from the fake process: %q", line)
+	}
+
+	err = ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644)
+	if err != nil {
+		fd.stop()
+		t.Fatalf("unexpected error writing the pidfile: %v", err)
+	}
+	return fd
+}
+
+func (fd *fakeDNSMasq) wait(t *testing.T, timeout time.Duration) string {
+	select {
+	case line := <-fd.reloads:
+		return line
+	case <-time.After(timeout):
+		return ""
+	}
+}
+
+func (fd *fakeDNSMasq) stop() {
+	fd.cmd.Process.Kill()
+	fd.cmd.Wait()
+}
+
+func TestReadPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloader")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "dnsmasq.pid")
+	_, err = ReadPidFile(pidFile)
+	if err == nil {
+		t.Errorf("unexpected success reading a missing pidfile")
+	}
+
+	ioutil.WriteFile(pidFile, []byte("garbage\n"), 0644)
+	_, err = ReadPidFile(pidFile)
+	if err != ErrBadPidFile {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ioutil.WriteFile(pidFile, []byte("1234\n"), 0644)
+	pid, err := ReadPidFile(pidFile)
+	if err != nil || pid != 1234 {
+		t.Errorf("unexpected result: pid=%v err=%v", pid, err)
+	}
+}
+
+func TestReloadDebounced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloader")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "dnsmasq.pid")
+	fd := startFakeDNSMasq(t, pidFile)
+	defer fd.stop()
+
+	watched := filepath.Join(dir, "hosts")
+	ioutil.WriteFile(watched, []byte(""), 0644)
+
+	r := New(pidFile, []string{watched})
+	r.Interval = 10 * time.Millisecond
+	r.Debounce = 100 * time.Millisecond
+	r.Cooldown = 0
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Run(stop)
+
+	// let the reloader record the initial state
+	time.Sleep(50 * time.Millisecond)
+	for ix := 0; ix < 5; ix++ {
+		ioutil.WriteFile(watched, []byte(fmt.Sprintf("192.168.1.%d\thost%d\n", ix, ix)), 0644)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if line := fd.wait(t, 5*time.Second); line != "reloaded" {
+		t.Fatalf("fake dnsmasq not reloaded: %q", line)
+	}
+	if line := fd.wait(t, 500*time.Millisecond); line != "" {
+		t.Errorf("burst of changes not debounced: %q", line)
+	}
+}
+
+func TestReloadCooldown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloader")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "dnsmasq.pid")
+	fd := startFakeDNSMasq(t, pidFile)
+	defer fd.stop()
+
+	watched := filepath.Join(dir, "dhcphosts")
+	ioutil.WriteFile(watched, []byte(""), 0644)
+
+	r := New(pidFile, []string{watched})
+	r.Interval = 10 * time.Millisecond
+	r.Debounce = 0
+	r.Cooldown = time.Hour
+	err = r.Reload()
+	if err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if line := fd.wait(t, 5*time.Second); line != "reloaded" {
+		t.Fatalf("fake dnsmasq not reloaded: %q", line)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Run(stop)
+
+	time.Sleep(50 * time.Millisecond)
+	ioutil.WriteFile(watched, []byte("01:23:45:67:89:ab,1.1.1.1\n"), 0644)
+
+	if line := fd.wait(t, 500*time.Millisecond); line != "" {
+		t.Errorf("reloaded despite the cooldown: %q", line)
+	}
+}
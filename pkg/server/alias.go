@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+)
+
+// aliasOp changes the aliases of a single host entry
+type aliasOp func(dmm *DNSMasqMgr, name, alias string) (bool, error)
+
+func addAlias(dmm *DNSMasqMgr, name, alias string) (bool, error) {
+	_, err, present := dmm.nameMap.AddAlias(name, alias)
+	return !present, err
+}
+
+func removeAlias(dmm *DNSMasqMgr, name, alias string) (bool, error) {
+	_, removed := dmm.nameMap.RemoveAlias(name, alias)
+	return removed, nil
+}
+
+// alterAliases applies op to all the aliases in the request, all or none of them
+func (dmm *DNSMasqMgr) alterAliases(ctx context.Context, method string, req *pb.AliasRequest, op aliasOp) (*pb.AddressReply, error) {
+	if dmm.readOnly {
+		return nil, ErrReadOnly
+	}
+	if req == nil || req.Addr == nil || len(req.Aliases) == 0 {
+		return nil, ErrRequestData
+	}
+
+	dmm.lock.Lock()
+	defer dmm.lock.Unlock()
+
+	ret, err := dmm.lookupAddress(ctx, &pb.AddressRequest{Key: req.Key, Addr: req.Addr})
+	if err != nil {
+		return nil, err
+	}
+	if ret.Addr.Hostname == "" {
+		return nil, ErrIncomplete
+	}
+	cur := proto.Clone(ret.Addr).(*pb.Address)
+	target := dmm.target(cur)
+	target.Hostnames = append(target.Hostnames, policyNames(dmm.poolOf(cur), req.Aliases)...)
+	err = dmm.authorize(ctx, method, cur, target)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := dmm.checkpoint()
+	changed := false
+	for _, alias := range req.Aliases {
+		done, err := op(dmm, cur.Hostname, alias)
+		if err != nil {
+			dmm.rollback(cp)
+			return nil, err
+		}
+		changed = changed || done
+	}
+	if !changed {
+		return ret, nil
+	}
+
+	err = dmm.store()
+	if err != nil {
+		dmm.rollback(cp)
+		return nil, err
+	}
+	host, err := dmm.nameMap.GetByHostname(cur.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	ret.Addr.Aliases = host.Aliases
+	dmm.record(pb.Action_UPDATE, ret.Addr, cur)
+	return ret, nil
+}
+
+func (dmm *DNSMasqMgr) AddAlias(ctx context.Context, req *pb.AliasRequest) (*pb.AddressReply, error) {
+	if req != nil {
+		aliases, err := checkAliases(req.Aliases)
+		if err != nil {
+			return nil, err
+		}
+		req.Aliases = aliases
+	}
+	return dmm.alterAliases(ctx, "AddAlias", req, addAlias)
+}
+
+func (dmm *DNSMasqMgr) RemoveAlias(ctx context.Context, req *pb.AliasRequest) (*pb.AddressReply, error) {
+	return dmm.alterAliases(ctx, "RemoveAlias", req, removeAlias)
+}
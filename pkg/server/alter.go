@@ -25,36 +25,26 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"net"
+	"strings"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
 	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
 )
 
-type JournalAddr struct {
-	Hostname string `json:"hostname"`
-	Macaddr  string `json:"mac"`
-	Ipaddr   string `json:"ip"`
-}
-
-type JournalEntry struct {
-	Action  string      `json:"action"`
-	Address JournalAddr `json:"address"`
-}
-
-func (ja *JournalAddr) FromAddress(addr *pb.Address) {
-	ja.Hostname = addr.Hostname
-	ja.Macaddr = addr.Macaddr
-	ja.Ipaddr = addr.Ipaddr
-}
-
-func FromAddress(action string, addr *pb.Address) *JournalEntry {
-	je := JournalEntry{
-		Action: action,
-	}
-	je.Address.FromAddress(addr)
-	return &je
+var journalActions = map[pb.Action]string{
+	pb.Action_ADD:      journal.ActionAdd,
+	pb.Action_DELETE:   journal.ActionDelete,
+	pb.Action_UPDATE:   journal.ActionUpdate,
+	pb.Action_EXTERNAL: journal.ActionExternal,
+	pb.Action_FIX:      journal.ActionFix,
 }
 
 func handleDuplicate(ar *pb.AddressReply, key pb.Key, val string) {
@@ -70,80 +60,528 @@ func handleDuplicate(ar *pb.AddressReply, key pb.Key, val string) {
 	log.Printf("%s %s already present, skipped", pb.Key_name[int32(key)], val)
 }
 
+// checkAliases validates the aliases, and returns them lowercased
+func checkAliases(aliases []string) ([]string, error) {
+	var ret []string
+	for _, alias := range aliases {
+		err := etchosts.CheckHostname(alias)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, strings.ToLower(alias))
+	}
+	return ret, nil
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// renamedAliases returns the aliases of an entry renamed from hostname to renamed: the short name
+// requestAddress added for hostname, unless the aliases were given, is replaced by the one of renamed
+func (dmm *DNSMasqMgr) renamedAliases(hostname, renamed, domain string, aliases []string, given bool) []string {
+	_, short := normalizeName(hostname, domain)
+	_, newShort := normalizeName(renamed, domain)
+	ret := []string{}
+	for _, alias := range aliases {
+		if given || short == "" || !strings.EqualFold(alias, short) {
+			ret = append(ret, alias)
+		}
+	}
+	if newShort != "" && !hasName(ret, newShort) && !dmm.nameTaken(newShort, hostname) {
+		ret = append(ret, newShort)
+	}
+	return ret
+}
+
+// nameTaken returns true if any entry but the one of hostname has the name. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) nameTaken(name, hostname string) bool {
+	for _, h := range dmm.nameMap.Hosts() {
+		if h.HasName(name) && !strings.EqualFold(h.CanonicalHostname, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// allocate hands out the address requested in want, or a new one from the pool if want is empty.
+// Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) allocate(p *pool, v6 bool, want string) (net.IP, error) {
+	if want == "" {
+		alloc := p.alloc
+		if v6 {
+			alloc = p.alloc6
+		}
+		ipAddr := alloc.Allocate()
+		if ipAddr == nil {
+			return nil, ErrPoolExhaust
+		}
+		return ipAddr, nil
+	}
+	ipAddr := net.ParseIP(want)
+	if ipAddr == nil || (ipAddr.To4() == nil) != v6 {
+		return nil, ErrInvalidParam
+	}
+	if v6 {
+		ipAddr = p.expandIP6(ipAddr)
+	}
+	if !dmm.fits(p, ipAddr) {
+		return nil, ErrOutOfSubnet
+	}
+	// the hosts files allow many names per address, but we manage one entry per address
+	if dmm.inUse(ipAddr) {
+		return nil, ErrAddrInUse
+	}
+	dmm.reserve(ipAddr)
+	return ipAddr, nil
+}
+
+// RequestAddress adds a new entry in the pool chosen as described in pb.AddressRequest.
+// The IPv4 address is taken from the pool unless given; the IPv6 address as well, if the pool
+// has an IPv6 range. An IPv6 address can be given as interface identifier only (e.g. ::56),
+// and it is completed with the prefix of the pool.
+// The client is identified by hardware address, by DHCP client identifier, or both.
 func (dmm *DNSMasqMgr) RequestAddress(ctx context.Context, req *pb.AddressRequest) (*pb.AddressReply, error) {
-	if req == nil || req.Addr == nil || req.Addr.Hostname == "" || req.Addr.Macaddr == "" {
+	return dmm.requestAddress(ctx, "RequestAddress", req)
+}
+
+// requestAddress adds a new entry on behalf of method, which the policy checks
+func (dmm *DNSMasqMgr) requestAddress(ctx context.Context, method string, req *pb.AddressRequest) (*pb.AddressReply, error) {
+	if dmm.readOnly {
+		return nil, ErrReadOnly
+	}
+	if req == nil || req.Addr == nil || req.Addr.Hostname == "" || (req.Addr.Macaddr == "" && req.Addr.Clientid == "") {
 		return nil, ErrRequestData
 	}
 
 	dmm.lock.Lock()
 	defer dmm.lock.Unlock()
 
-	var err error
-	var ipAddr net.IP
-	if req.Addr.Ipaddr == "" {
-		ipAddr = dmm.ipAlloc.Allocate()
-		req.Addr.Ipaddr = ipAddr.String()
-	} else {
-		ipAddr = net.ParseIP(req.Addr.Ipaddr)
-		if ipAddr == nil {
+	p, err := dmm.selectPool(req)
+	if err != nil {
+		return nil, err
+	}
+	err = etchosts.CheckHostname(req.Addr.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := checkAliases(req.Addr.Aliases)
+	if err != nil {
+		return nil, err
+	}
+	hostname, short := p.normalize(req.Addr.Hostname)
+	// the short name is added unless taken, so it resolves like dnsmasq's expand-hosts does
+	if short != "" && !hasName(aliases, short) && len(dmm.nameMap.Resolve(short)) == 0 {
+		aliases = append(aliases, short)
+	}
+	req.Addr.Hostname, req.Addr.Aliases = hostname, aliases
+	err = dmm.authorize(ctx, method, req.Addr, policy.Target{
+		Pool:      p.Name,
+		Hostnames: policyNames(p, append([]string{req.Addr.Hostname}, req.Addr.Aliases...)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	binding := dhcphosts.Binding{
+		ClientID: req.Addr.Clientid,
+		SetTags:  p.tags(req.Addr.Tags),
+	}
+	if req.Addr.Macaddr != "" {
+		hw, err := net.ParseMAC(req.Addr.Macaddr)
+		if err != nil {
+			return nil, ErrInvalidParam
+		}
+		binding.HW = hw
+	}
+
+	ipAddr, err := dmm.allocate(p, false, req.Addr.Ipaddr)
+	if err != nil {
+		return nil, err
+	}
+	binding.IP = ipAddr
+	req.Addr.Ipaddr = ipAddr.String()
+	if p.alloc6 != nil || req.Addr.Ip6Addr != "" {
+		ip6Addr, err := dmm.allocate(p, true, req.Addr.Ip6Addr)
+		if err != nil {
+			dmm.releaseUnused(ipAddr)
 			return nil, err
 		}
-		dmm.ipAlloc.Reserve(ipAddr)
+		binding.IP6 = ip6Addr
+		req.Addr.Ip6Addr = ip6Addr.String()
+	}
+	release := func() {
+		for _, ip := range binding.IPs() {
+			dmm.releaseUnused(ip)
+		}
 	}
 
 	ret := pb.AddressReply{
 		Match: pb.Match_NONE,
 	}
+	cp := dmm.checkpoint()
 	var present bool
-	var aliases []string
-	_, err, present = dmm.nameMap.Add(req.Addr.Hostname, req.Addr.Ipaddr, aliases)
-	if err != nil {
-		return nil, err
+	for _, ip := range binding.IPs() {
+		_, err, present = dmm.nameMap.Add(req.Addr.Hostname, ip.String(), req.Addr.Aliases)
+		if err != nil {
+			dmm.rollback(cp)
+			release()
+			return nil, err
+		}
 	}
 	if present {
 		handleDuplicate(&ret, pb.Key_HOSTNAME, req.Addr.Hostname)
 	}
 
-	_, err, present = dmm.addrMap.Add(req.Addr.Macaddr, req.Addr.Ipaddr)
+	_, err, present = dmm.addrMap.AddBinding(binding)
 	if err != nil {
-		dmm.nameMap.Remove(req.Addr.Hostname)
+		dmm.rollback(cp)
+		release()
 		return nil, err
 	}
 	if present {
-		handleDuplicate(&ret, pb.Key_MACADDR, req.Addr.Macaddr)
+		handleDuplicate(&ret, pb.Key_MACADDR, binding.Key())
 	}
+	setBinding(req.Addr, binding)
 
-	dmm.toJournal("add", req.Addr)
-	defer dmm.requestStore()
+	err = dmm.store()
+	if err != nil {
+		dmm.rollback(cp)
+		release()
+		return nil, err
+	}
+	dmm.record(pb.Action_ADD, req.Addr, nil)
 
 	ret.Addr = req.Addr
 	return &ret, nil
 }
 
 func (dmm *DNSMasqMgr) DeleteAddress(ctx context.Context, req *pb.AddressRequest) (*pb.AddressReply, error) {
-	ret, err := dmm.LookupAddress(ctx, req)
+	if dmm.readOnly {
+		return nil, ErrReadOnly
+	}
+	if req == nil || req.Addr == nil {
+		return nil, ErrRequestData
+	}
+
+	dmm.lock.Lock()
+	defer dmm.lock.Unlock()
+
+	ret, err := dmm.lookupAddress(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	err = dmm.authorize(ctx, "DeleteAddress", ret.Addr, dmm.target(ret.Addr))
 	if err != nil {
 		return nil, err
 	}
 
+	cp := dmm.checkpoint()
+	dmm.addrMap.Remove(bindingKey(ret.Addr))
+	dmm.nameMap.Remove(ret.Addr.Hostname)
+
+	err = dmm.store()
+	if err != nil {
+		dmm.rollback(cp)
+		return nil, err
+	}
+	dmm.releaseUnused(net.ParseIP(ret.Addr.Ipaddr))
+	dmm.releaseUnused(net.ParseIP(ret.Addr.Ip6Addr))
+	dmm.record(pb.Action_DELETE, ret.Addr, nil)
+
+	return ret, nil
+}
+
+// sameIP returns true if want is empty, or it is the same address as have
+func sameIP(have, want string) bool {
+	return want == "" || net.ParseIP(want).Equal(net.ParseIP(have))
+}
+
+// sameName returns true if want names the same entry as have, also as short name in the domain of any pool
+func (dmm *DNSMasqMgr) sameName(have, want string) bool {
+	if strings.EqualFold(have, want) {
+		return true
+	}
+	for _, p := range dmm.pools {
+		if fqdn, _ := p.normalize(want); strings.EqualFold(have, fqdn) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameAddress returns true if the non-empty fields of want match the ones of have
+func (dmm *DNSMasqMgr) sameAddress(have, want *pb.Address) bool {
+	if want.Hostname != "" && !dmm.sameName(have.Hostname, want.Hostname) {
+		return false
+	}
+	if want.Macaddr != "" {
+		hw, err := net.ParseMAC(want.Macaddr)
+		if err != nil || hw.String() != have.Macaddr {
+			return false
+		}
+	}
+	if want.Clientid != "" && want.Clientid != have.Clientid {
+		return false
+	}
+	return sameIP(have.Ipaddr, want.Ipaddr) && sameIP(have.Ip6Addr, want.Ip6Addr)
+}
+
+// mergeAddress returns a copy of cur with the non-empty fields of upd applied
+func mergeAddress(cur, upd *pb.Address) (*pb.Address, error) {
+	next := proto.Clone(cur).(*pb.Address)
+	if upd.Hostname != "" {
+		next.Hostname = upd.Hostname
+	}
+	if upd.Macaddr != "" {
+		hw, err := net.ParseMAC(upd.Macaddr)
+		if err != nil {
+			return nil, ErrInvalidParam
+		}
+		next.Macaddr = hw.String()
+	}
+	if upd.Clientid != "" {
+		next.Clientid = upd.Clientid
+	}
+	if len(upd.Tags) > 0 {
+		next.Tags = upd.Tags
+	}
+	if upd.Ipaddr != "" {
+		ip := net.ParseIP(upd.Ipaddr)
+		if ip == nil || ip.To4() == nil {
+			return nil, ErrInvalidParam
+		}
+		next.Ipaddr = ip.String()
+	}
+	if upd.Ip6Addr != "" {
+		ip := net.ParseIP(upd.Ip6Addr)
+		if ip == nil || ip.To4() != nil {
+			return nil, ErrInvalidParam
+		}
+		next.Ip6Addr = ip.String()
+	}
+	if len(upd.Aliases) > 0 {
+		next.Aliases = upd.Aliases
+	}
+	return next, nil
+}
+
+// updateHost changes the host entry of cur in the family of ip, adding it if missing
+func updateHost(nameMap *etchosts.Conf, cur, next *pb.Address, ip net.IP, had bool) error {
+	host := etchosts.Host{
+		CanonicalHostname: next.Hostname,
+		Address:           ip,
+		Aliases:           next.Aliases,
+	}
+	for _, h := range nameMap.GetAllByHostname(cur.Hostname) {
+		if h.SameFamily(host) {
+			// nil means unchanged: entries journaled by older releases carry no aliases
+			if next.Aliases == nil {
+				host.Aliases = h.Aliases
+			}
+			return nameMap.Replace(cur.Hostname, host)
+		}
+	}
+	if had {
+		return etchosts.ErrNotFoundHostname
+	}
+	_, err, _ := nameMap.Add(next.Hostname, ip.String(), host.Aliases)
+	return err
+}
+
+// applyUpdate changes the entry described by cur to match next.
+// Entries without binding (e.g. with only aliases changed) have no binding to update.
+func applyUpdate(nameMap *etchosts.Conf, addrMap *dhcphosts.Conf, cur, next *pb.Address) error {
+	ip := net.ParseIP(next.Ipaddr)
+	ip6 := net.ParseIP(next.Ip6Addr)
+	if (ip == nil && next.Ipaddr != "") || (ip6 == nil && next.Ip6Addr != "") {
+		return ErrInvalidParam
+	}
+	// each family is looked up by the old name: the other one may be already renamed
+	if ip != nil {
+		err := updateHost(nameMap, cur, next, ip, cur.Ipaddr != "")
+		if err != nil {
+			return err
+		}
+	}
+	if ip6 != nil {
+		err := updateHost(nameMap, cur, next, ip6, cur.Ip6Addr != "")
+		if err != nil {
+			return err
+		}
+	}
+	key := bindingKey(cur)
+	if key == "" && next.Macaddr == "" {
+		return nil
+	}
+
+	binding, err := addrMap.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if next.Macaddr != "" {
+		hw, err := net.ParseMAC(next.Macaddr)
+		if err != nil {
+			return ErrInvalidParam
+		}
+		binding.HW = hw
+	}
+	binding.ClientID = next.Clientid
+	// nil means unchanged, like the aliases
+	if next.Tags != nil {
+		binding.SetTags = next.Tags
+	}
+	if binding.IP != nil && ip != nil {
+		binding.IP = ip
+	}
+	if ip6 != nil {
+		binding.IP6 = ip6
+	}
+	if strings.EqualFold(binding.Hostname, cur.Hostname) {
+		binding.Hostname = next.Hostname
+	}
+	return addrMap.Replace(key, binding)
+}
+
+// UpdateAddress changes hostname, client identifiers and/or IP addresses of an existing entry in one go
+func (dmm *DNSMasqMgr) UpdateAddress(ctx context.Context, req *pb.UpdateRequest) (*pb.AddressReply, error) {
+	if dmm.readOnly {
+		return nil, ErrReadOnly
+	}
+	if req == nil || req.Current == nil || req.Updated == nil {
+		return nil, ErrRequestData
+	}
+
 	dmm.lock.Lock()
 	defer dmm.lock.Unlock()
 
-	dmm.addrMap.Remove(ret.Addr.Macaddr)
-	dmm.nameMap.Remove(ret.Addr.Hostname)
+	ret, err := dmm.lookupAddress(ctx, &pb.AddressRequest{Key: req.Key, Addr: req.Current})
+	if err != nil {
+		return nil, err
+	}
+	if ret.Match != pb.Match_FULL {
+		return nil, ErrIncomplete
+	}
+	cur := ret.Addr
+	if !dmm.sameAddress(cur, req.Current) {
+		return nil, ErrMismatch
+	}
+	if req.Updated.Hostname != "" {
+		err = etchosts.CheckHostname(req.Updated.Hostname)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.Updated.Aliases, err = checkAliases(req.Updated.Aliases)
+	if err != nil {
+		return nil, err
+	}
+	p := dmm.poolOf(cur)
+	domain := ""
+	if p != nil {
+		domain = p.Domain
+	}
+	if req.Updated.Hostname != "" {
+		req.Updated.Hostname, _ = normalizeName(req.Updated.Hostname, domain)
+	}
+	if p != nil && req.Updated.Ip6Addr != "" {
+		if ip := net.ParseIP(req.Updated.Ip6Addr); ip != nil {
+			req.Updated.Ip6Addr = p.expandIP6(ip).String()
+		}
+	}
+	next, err := mergeAddress(cur, req.Updated)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(cur.Hostname, next.Hostname) {
+		next.Aliases = dmm.renamedAliases(cur.Hostname, next.Hostname, domain, next.Aliases, len(req.Updated.Aliases) > 0)
+	}
+	if proto.Equal(cur, next) {
+		return ret, nil
+	}
+	err = dmm.authorize(ctx, "UpdateAddress", cur, dmm.target(cur), dmm.target(next))
+	if err != nil {
+		return nil, err
+	}
+
+	var changed [][2]net.IP
+	for _, ips := range [][2]string{{cur.Ipaddr, next.Ipaddr}, {cur.Ip6Addr, next.Ip6Addr}} {
+		oldIP, newIP := net.ParseIP(ips[0]), net.ParseIP(ips[1])
+		if newIP == nil || oldIP.Equal(newIP) {
+			continue
+		}
+		if p != nil && !dmm.fits(p, newIP) {
+			err = ErrOutOfSubnet
+		} else if dmm.inUse(newIP) {
+			err = ErrAddrInUse
+		}
+		if err != nil {
+			for _, c := range changed {
+				dmm.releaseUnused(c[1])
+			}
+			return nil, err
+		}
+		dmm.reserve(newIP)
+		changed = append(changed, [2]net.IP{oldIP, newIP})
+	}
 
-	dmm.toJournal("del", ret.Addr)
-	defer dmm.requestStore()
+	cp := dmm.checkpoint()
+	undo := func() {
+		dmm.rollback(cp)
+		for _, c := range changed {
+			dmm.releaseUnused(c[1])
+		}
+	}
+	err = applyUpdate(dmm.nameMap, dmm.addrMap, cur, next)
+	if err != nil {
+		undo()
+		return nil, err
+	}
 
+	err = dmm.store()
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	for _, c := range changed {
+		dmm.releaseUnused(c[0])
+	}
+	dmm.record(pb.Action_UPDATE, next, cur)
+
+	ret.Addr = next
 	return ret, nil
 }
 
-func (dmm *DNSMasqMgr) toJournal(action string, req *pb.Address) {
-	entry, err := json.Marshal(FromAddress(action, req))
+// record publishes a change to the watchers and appends it to the journal. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) record(action pb.Action, addr, prev *pb.Address) {
+	var prevCopy *pb.Address
+	if prev != nil {
+		prevCopy = proto.Clone(prev).(*pb.Address)
+	}
+	ev := dmm.events.publish(action, proto.Clone(addr).(*pb.Address), prevCopy)
+	dmm.toJournal(ev)
+}
+
+func (dmm *DNSMasqMgr) toJournal(ev *pb.Event) {
+	if dmm.journal == nil {
+		return
+	}
+	je := journal.Entry{
+		Revision: ev.Revision,
+		Time:     time.Unix(ev.Timestamp, 0).UTC(),
+		Action:   journalActions[ev.Action],
+		Address:  toJournalAddr(ev.Addr),
+	}
+	if ev.Previous != nil {
+		je.Previous = toJournalAddr(ev.Previous)
+	}
+	err := dmm.journal.Append(&je)
 	if err != nil {
 		log.Printf("cannot add to journal: %v", err)
 		// intentionally do NOT abort
-	} else {
-		dmm.changes.Printf("%s", string(entry))
 	}
 }
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
+)
+
+// denialWindow is how long the repeated denials of the same identity and method are left out of the journal
+const denialWindow time.Duration = time.Minute
+
+// denial tracks the last denial recorded for an identity and a method
+type denial struct {
+	recorded time.Time
+	repeated int
+}
+
+// UnaryAuthInterceptor identifies the caller, and rejects the calls to the mutating methods
+// no rule of the policy allows to the caller. The handlers then check the entries they change.
+func (dmm *DNSMasqMgr) UnaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if dmm.policy == nil {
+		return handler(ctx, req)
+	}
+	identity, err := dmm.policy.Identity(ctx)
+	if err != nil {
+		log.Printf("server: %s: %v", info.FullMethod, err)
+		return nil, err
+	}
+	if policy.IsMutating(info.FullMethod) && !reportOnly(req) {
+		method := path.Base(info.FullMethod)
+		err = dmm.policy.AllowMethod(identity, method)
+		if err != nil {
+			dmm.lock.Lock()
+			dmm.recordDenied(identity, method, requestAddress(req), err)
+			dmm.lock.Unlock()
+			return nil, err
+		}
+	}
+	return handler(policy.NewContext(ctx, identity), req)
+}
+
+// StreamAuthInterceptor rejects the streaming calls carrying bad tokens. Streams change nothing,
+// so they are allowed to everyone else.
+func (dmm *DNSMasqMgr) StreamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if dmm.policy != nil {
+		if _, err := dmm.policy.Identity(ss.Context()); err != nil {
+			log.Printf("server: %s: %v", info.FullMethod, err)
+			return err
+		}
+	}
+	return handler(srv, ss)
+}
+
+// reportOnly returns true if the request of a mutating method is not going to change anything
+func reportOnly(req interface{}) bool {
+	r, ok := req.(*pb.CheckRequest)
+	return ok && r.Fix == pb.Resolution_REPORT
+}
+
+// requestAddress returns the address in the request of a mutating method, nil if none
+func requestAddress(req interface{}) *pb.Address {
+	switch r := req.(type) {
+	case *pb.AddressRequest:
+		return r.Addr
+	case *pb.UpdateRequest:
+		return r.Current
+	case *pb.AliasRequest:
+		return r.Addr
+	case *pb.PinRequest:
+		return r.Addr
+	}
+	return nil
+}
+
+// target returns what the policy checks about the entry
+func (dmm *DNSMasqMgr) target(addr *pb.Address) policy.Target {
+	p := dmm.poolOf(addr)
+	t := policy.Target{
+		Hostnames: policyNames(p, append([]string{addr.Hostname}, addr.Aliases...)),
+	}
+	if p != nil {
+		t.Pool = p.Name
+	}
+	return t
+}
+
+// policyNames returns the names the policy checks. The short names are checked as names of the domain
+// of the pool, so the patterns like "*.ci.lan" cover the short aliases added for the names in it.
+func policyNames(p *pool, names []string) []string {
+	var ret []string
+	for _, name := range names {
+		if p != nil && name != "" && !strings.Contains(name, ".") {
+			name, _ = p.normalize(name)
+		}
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// authorize returns nil if the policy lets the caller change the targets with the method,
+// recording the denials. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) authorize(ctx context.Context, method string, addr *pb.Address, targets ...policy.Target) error {
+	if dmm.policy == nil {
+		return nil
+	}
+	identity := policy.FromContext(ctx)
+	err := dmm.policy.Allow(identity, method, targets...)
+	if err != nil {
+		dmm.recordDenied(identity, method, addr, err)
+	}
+	return err
+}
+
+// recordDenied logs a refused request and appends it to the journal. The denials repeated by the same identity
+// for the same method within denialWindow are only counted, so a client retrying cannot flood the journal.
+// Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) recordDenied(identity, method string, addr *pb.Address, reason error) {
+	log.Printf("server: %v", reason)
+	if dmm.journal == nil {
+		return
+	}
+	now := time.Now().UTC()
+	key := policy.Name(identity) + "/" + method
+	if dmm.denials == nil {
+		dmm.denials = make(map[string]*denial)
+	}
+	last, ok := dmm.denials[key]
+	if ok && now.Sub(last.recorded) < denialWindow {
+		last.repeated++
+		return
+	}
+	je := journal.Entry{
+		Revision: dmm.events.Revision(),
+		Time:     now,
+		Action:   journal.ActionDeny,
+		Identity: policy.Name(identity),
+		Method:   method,
+	}
+	if ok {
+		je.Repeated = last.repeated
+	}
+	dmm.denials[key] = &denial{recorded: now}
+	if addr != nil {
+		je.Address = toJournalAddr(addr)
+	}
+	err := dmm.journal.Append(&je)
+	if err != nil {
+		log.Printf("cannot add to journal: %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
+)
+
+// Check works on the lines of the managed files rather than on the parsed entries,
+// because the parsers keep the lines clashing with the previous ones verbatim, out of the indexes.
+
+var problemErrors = map[pb.Problem]pb.Error{
+	pb.Problem_ORPHAN_BINDING: pb.Error_MISMATCH,
+	pb.Problem_ORPHAN_HOST:    pb.Error_MISMATCH,
+	pb.Problem_OUT_OF_RANGE:   pb.Error_MISMATCH,
+	pb.Problem_SHARED_ADDRESS: pb.Error_DUPLICATE,
+	pb.Problem_NAME_CONFLICT:  pb.Error_DUPLICATE,
+}
+
+type hostLine struct {
+	ix   int
+	host etchosts.Host
+}
+
+type bindingLine struct {
+	ix      int
+	binding dhcphosts.Binding
+	// the addresses, with the IPv6 interface identifiers completed
+	ips []net.IP
+}
+
+// finding is a problem found, with the indexes of the lines involved and of the ones the fix drops
+type finding struct {
+	problem    pb.Problem
+	subject    string
+	hosts      []int
+	leases     []int
+	dropHosts  []int
+	dropLeases []int
+}
+
+// checkState looks for the problems in the lines of the managed files. The lines out of range may be static
+// entries written on purpose, so the fix drops them only if dropOutOfRange is set. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) checkState(hostsLines, leasesLines []string, fix pb.Resolution, dropOutOfRange bool) []*finding {
+	var hosts []hostLine
+	for ix, l := range hostsLines {
+		if h, err := etchosts.ParseHostString(l); err == nil {
+			hosts = append(hosts, hostLine{ix: ix, host: h})
+		}
+	}
+	var bindings []bindingLine
+	for ix, l := range leasesLines {
+		s := strings.TrimSpace(l)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		b, err := dhcphosts.ParseBindingString(s)
+		if err != nil {
+			continue
+		}
+		bl := bindingLine{ix: ix, binding: b}
+		for _, ip := range b.IPs() {
+			bl.ips = append(bl.ips, dmm.expandIP6(ip))
+		}
+		bindings = append(bindings, bl)
+	}
+
+	named := make(map[string]bool)
+	for _, hl := range hosts {
+		named[hl.host.Address.String()] = true
+	}
+	// the addresses in the order they are first bound, with the lines binding them
+	var boundOrder []string
+	bound := make(map[string][]bindingLine)
+	for _, bl := range bindings {
+		for _, ip := range bl.ips {
+			key := ip.String()
+			if _, ok := bound[key]; !ok {
+				boundOrder = append(boundOrder, key)
+			}
+			bound[key] = append(bound[key], bl)
+		}
+	}
+
+	var ret []*finding
+	for _, bl := range bindings {
+		if len(bl.ips) == 0 || bl.binding.Hostname != "" {
+			continue
+		}
+		orphan := true
+		for _, ip := range bl.ips {
+			if named[ip.String()] {
+				orphan = false
+			}
+		}
+		if orphan {
+			ret = append(ret, dropAll(pb.Problem_ORPHAN_BINDING, bl.binding.Key(), nil, []int{bl.ix}))
+		}
+	}
+	for _, hl := range hosts {
+		addr := hl.host.Address
+		if dmm.allocFor(addr) != nil && len(bound[addr.String()]) == 0 {
+			ret = append(ret, dropAll(pb.Problem_ORPHAN_HOST, hl.host.CanonicalHostname, []int{hl.ix}, nil))
+		}
+	}
+	ret = append(ret, dmm.outOfRange(hosts, bindings, dropOutOfRange)...)
+	for _, key := range boundOrder {
+		bls := bound[key]
+		clients := make(map[string]bool)
+		for _, bl := range bls {
+			clients[bl.binding.Key()] = true
+		}
+		if len(clients) < 2 {
+			continue
+		}
+		f := &finding{problem: pb.Problem_SHARED_ADDRESS, subject: key}
+		keep := bls[keepIndex(len(bls), fix)].binding.Key()
+		for _, bl := range bls {
+			f.leases = append(f.leases, bl.ix)
+			if fix != pb.Resolution_REPORT && bl.binding.Key() != keep {
+				f.dropLeases = append(f.dropLeases, bl.ix)
+			}
+		}
+		ret = append(ret, f)
+	}
+	ret = append(ret, nameConflicts(hosts, fix)...)
+	if fix == pb.Resolution_REPORT {
+		for _, f := range ret {
+			f.dropHosts, f.dropLeases = nil, nil
+		}
+	}
+	return ret
+}
+
+// outOfRange finds the hosts and the dhcp-host lines about addresses out of all the pools, by address.
+// The loopback addresses are never handed out, so they are left alone.
+func (dmm *DNSMasqMgr) outOfRange(hosts []hostLine, bindings []bindingLine, drop bool) []*finding {
+	var order []string
+	byAddr := make(map[string]*finding)
+	add := func(ip net.IP) *finding {
+		if ip.IsLoopback() || isInterfaceID(ip) || dmm.allocFor(ip) != nil {
+			return nil
+		}
+		key := ip.String()
+		f, ok := byAddr[key]
+		if !ok {
+			f = &finding{problem: pb.Problem_OUT_OF_RANGE, subject: key}
+			byAddr[key] = f
+			order = append(order, key)
+		}
+		return f
+	}
+	for _, hl := range hosts {
+		if f := add(hl.host.Address); f != nil {
+			f.hosts = append(f.hosts, hl.ix)
+		}
+	}
+	for _, bl := range bindings {
+		for _, ip := range bl.ips {
+			if f := add(ip); f != nil {
+				f.leases = append(f.leases, bl.ix)
+				break
+			}
+		}
+	}
+	var ret []*finding
+	for _, key := range order {
+		f := byAddr[key]
+		if drop {
+			f.dropHosts, f.dropLeases = f.hosts, f.leases
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// nameConflicts finds the names given to different addresses of the same family
+func nameConflicts(hosts []hostLine, fix pb.Resolution) []*finding {
+	type nameKey struct {
+		name string
+		ipv4 bool
+	}
+	var order []nameKey
+	byName := make(map[nameKey][]hostLine)
+	for _, hl := range hosts {
+		for _, name := range hl.host.Names() {
+			nk := nameKey{name: strings.ToLower(name), ipv4: hl.host.IsIPv4()}
+			if _, ok := byName[nk]; !ok {
+				order = append(order, nk)
+			}
+			byName[nk] = append(byName[nk], hl)
+		}
+	}
+	var ret []*finding
+	for _, nk := range order {
+		hls := byName[nk]
+		addrs := make(map[string]bool)
+		for _, hl := range hls {
+			addrs[hl.host.Address.String()] = true
+		}
+		if len(addrs) < 2 {
+			continue
+		}
+		f := &finding{problem: pb.Problem_NAME_CONFLICT, subject: nk.name}
+		keep := hls[keepIndex(len(hls), fix)].host.Address
+		for _, hl := range hls {
+			f.hosts = append(f.hosts, hl.ix)
+			if !hl.host.Address.Equal(keep) {
+				f.dropHosts = append(f.dropHosts, hl.ix)
+			}
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// dropAll returns a finding whose fix drops all the lines involved
+func dropAll(problem pb.Problem, subject string, hosts, leases []int) *finding {
+	return &finding{
+		problem:    problem,
+		subject:    subject,
+		hosts:      hosts,
+		leases:     leases,
+		dropHosts:  hosts,
+		dropLeases: leases,
+	}
+}
+
+// keepIndex returns the index of the line the resolution keeps among n clashing lines
+func keepIndex(n int, fix pb.Resolution) int {
+	if fix == pb.Resolution_KEEP_LAST {
+		return n - 1
+	}
+	return 0
+}
+
+// isInterfaceID returns true if ip is an IPv6 interface identifier (e.g. ::56), which dnsmasq
+// completes with the prefix of the dhcp-range
+func isInterfaceID(ip net.IP) bool {
+	return ip.To4() == nil && ip.Mask(net.CIDRMask(64, 128)).IsUnspecified()
+}
+
+// expandIP6 completes an IPv6 interface identifier with the prefix of the first pool having an IPv6 range
+func (dmm *DNSMasqMgr) expandIP6(ip net.IP) net.IP {
+	for _, p := range dmm.pools {
+		if x := p.expandIP6(ip); !x.Equal(ip) {
+			return x
+		}
+	}
+	return ip
+}
+
+// Check reports the inconsistencies between the managed files, and fixes them if asked to.
+// The fix drops lines, so the policy must allow Check on the entries they are about.
+// The lines out of range are dropped only if OUT_OF_RANGE is among the problems requested.
+func (dmm *DNSMasqMgr) Check(ctx context.Context, req *pb.CheckRequest) (*pb.CheckReply, error) {
+	if req == nil {
+		return nil, ErrRequestData
+	}
+	if _, ok := pb.Resolution_name[int32(req.Fix)]; !ok {
+		return nil, ErrInvalidParam
+	}
+	wanted := make(map[pb.Problem]bool)
+	for _, p := range req.Problems {
+		if _, ok := problemErrors[p]; !ok {
+			return nil, ErrInvalidParam
+		}
+		wanted[p] = true
+	}
+	if req.Fix != pb.Resolution_REPORT && dmm.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if req.Fix == pb.Resolution_REPORT {
+		dmm.lock.RLock()
+		defer dmm.lock.RUnlock()
+	} else {
+		dmm.lock.Lock()
+		defer dmm.lock.Unlock()
+	}
+	hostsLines, leasesLines := splitLines(dmm.nameMap.String()), splitLines(dmm.addrMap.String())
+	var found []*finding
+	for _, f := range dmm.checkState(hostsLines, leasesLines, req.Fix, wanted[pb.Problem_OUT_OF_RANGE]) {
+		if len(wanted) == 0 || wanted[f.problem] {
+			found = append(found, f)
+		}
+	}
+
+	ret := &pb.CheckReply{}
+	dropHosts, dropLeases := make(map[int]bool), make(map[int]bool)
+	for _, f := range found {
+		pf := &pb.Finding{
+			Problem: f.problem,
+			Error:   problemErrors[f.problem],
+			Subject: f.subject,
+			Hosts:   pick(hostsLines, f.hosts),
+			Leases:  pick(leasesLines, f.leases),
+		}
+		pf.Dropped = append(pick(hostsLines, f.dropHosts), pick(leasesLines, f.dropLeases)...)
+		for _, ix := range f.dropHosts {
+			dropHosts[ix] = true
+		}
+		for _, ix := range f.dropLeases {
+			dropLeases[ix] = true
+		}
+		ret.Findings = append(ret.Findings, pf)
+	}
+	if len(dropHosts) == 0 && len(dropLeases) == 0 {
+		return ret, nil
+	}
+
+	var targets []policy.Target
+	for ix := range dropHosts {
+		if h, err := etchosts.ParseHostString(hostsLines[ix]); err == nil {
+			addr := pb.Address{Hostname: h.CanonicalHostname, Aliases: h.Aliases}
+			setIP(&addr, h.Address)
+			targets = append(targets, dmm.target(&addr))
+		}
+	}
+	for ix := range dropLeases {
+		if b, err := dhcphosts.ParseBindingString(strings.TrimSpace(leasesLines[ix])); err == nil {
+			addr := pb.Address{Hostname: b.Hostname}
+			setBinding(&addr, b)
+			for _, ip := range b.IPs() {
+				setIP(&addr, dmm.expandIP6(ip))
+			}
+			targets = append(targets, dmm.target(&addr))
+		}
+	}
+	err := dmm.authorize(ctx, "Check", nil, targets...)
+	if err != nil {
+		return nil, err
+	}
+	err = dmm.applyFix(omit(hostsLines, dropHosts), omit(leasesLines, dropLeases))
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// applyFix replaces the state with the fixed lines. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) applyFix(hostsLines, leasesLines []string) error {
+	nameMap, addrMap, err := parseState(joinLines(hostsLines), joinLines(leasesLines))
+	if err != nil {
+		return err
+	}
+	addrMap.SetOrder(dmm.addrMap.Order())
+
+	prev := dmm.checkpoint()
+	dmm.nameMap, dmm.addrMap = nameMap, addrMap
+	err = dmm.store()
+	if err != nil {
+		dmm.rollback(prev)
+		return err
+	}
+	dmm.replaced(prev)
+	dmm.recordResync(pb.Action_FIX, dmm.nameMap, dmm.addrMap)
+	log.Printf("server: fixed the problems found in the managed files")
+	return nil
+}
+
+// pick returns the lines at the indexes
+func pick(lines []string, ixs []int) []string {
+	var ret []string
+	for _, ix := range ixs {
+		ret = append(ret, lines[ix])
+	}
+	return ret
+}
+
+// omit returns the lines but the ones at the indexes
+func omit(lines []string, ixs map[int]bool) []string {
+	var ret []string
+	for ix, l := range lines {
+		if !ixs[ix] {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+)
+
+const checkHosts string = "" +
+	"127.0.0.1\tlocalhost\n" +
+	"192.168.1.1\tgateway.test.lan\tgateway\n" +
+	"192.168.1.63\tclient.test.lan\tclient\n" +
+	"192.168.1.61\tstale.test.lan\n" +
+	"192.168.1.62\tclient.test.lan\n" +
+	""
+
+const checkLeases string = "" +
+	"52:54:aa:11:bb:22,192.168.1.63\n" +
+	"52:54:aa:11:bb:33,192.168.1.63\n" +
+	"52:54:aa:11:bb:44,192.168.1.64\n" +
+	"52:54:aa:11:bb:55,10.0.0.5,outside\n" +
+	"52:54:aa:11:bb:66,192.168.1.62\n" +
+	""
+
+type checkSummary struct {
+	problem pb.Problem
+	subject string
+	dropped int
+}
+
+func summarize(findings []*pb.Finding) []checkSummary {
+	var ret []checkSummary
+	for _, f := range findings {
+		ret = append(ret, checkSummary{f.Problem, f.Subject, len(f.Dropped)})
+	}
+	return ret
+}
+
+func TestCheck(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	ioutil.WriteFile(conf.HostsPath, []byte(checkHosts), 0644)
+	ioutil.WriteFile(conf.LeasesPath, []byte(checkLeases), 0644)
+	ctx := context.Background()
+
+	ro, err := NewDNSMasqMgrReadOnly(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	reply, err := ro.Check(ctx, &pb.CheckRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error checking: %v", err)
+	}
+	expected := []checkSummary{
+		{pb.Problem_ORPHAN_BINDING, "52:54:aa:11:bb:44", 0},
+		{pb.Problem_ORPHAN_HOST, "stale.test.lan", 0},
+		{pb.Problem_OUT_OF_RANGE, "192.168.1.1", 0},
+		{pb.Problem_OUT_OF_RANGE, "10.0.0.5", 0},
+		{pb.Problem_SHARED_ADDRESS, "192.168.1.63", 0},
+		{pb.Problem_NAME_CONFLICT, "client.test.lan", 0},
+	}
+	if got := summarize(reply.Findings); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected findings: %v", got)
+	}
+	if reply.Findings[4].Error != pb.Error_DUPLICATE || len(reply.Findings[4].Leases) != 2 {
+		t.Errorf("unexpected finding: %v", reply.Findings[4])
+	}
+	reply, err = ro.Check(ctx, &pb.CheckRequest{Problems: []pb.Problem{pb.Problem_ORPHAN_HOST}})
+	if err != nil || len(reply.Findings) != 1 || reply.Findings[0].Error != pb.Error_MISMATCH {
+		t.Errorf("unexpected filtered findings: %v %v", reply, err)
+	}
+	_, err = ro.Check(ctx, &pb.CheckRequest{Fix: pb.Resolution_KEEP_FIRST})
+	if err != ErrReadOnly {
+		t.Errorf("fixed in read-only mode: %v", err)
+	}
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	reply, err = dmm.Check(ctx, &pb.CheckRequest{Fix: pb.Resolution_KEEP_LAST})
+	if err != nil {
+		t.Fatalf("unexpected error fixing: %v", err)
+	}
+	expected = []checkSummary{
+		{pb.Problem_ORPHAN_BINDING, "52:54:aa:11:bb:44", 1},
+		{pb.Problem_ORPHAN_HOST, "stale.test.lan", 1},
+		{pb.Problem_OUT_OF_RANGE, "192.168.1.1", 0},
+		{pb.Problem_OUT_OF_RANGE, "10.0.0.5", 0},
+		{pb.Problem_SHARED_ADDRESS, "192.168.1.63", 1},
+		{pb.Problem_NAME_CONFLICT, "client.test.lan", 1},
+	}
+	if got := summarize(reply.Findings); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected fixed findings: %v", got)
+	}
+	if reply.Findings[4].Dropped[0] != "52:54:aa:11:bb:22,192.168.1.63" {
+		t.Errorf("kept the wrong binding: %v", reply.Findings[4].Dropped)
+	}
+	// the last client.test.lan was kept, so the binding kept for its former address is left without a name
+	reply, err = dmm.Check(ctx, &pb.CheckRequest{Problems: []pb.Problem{pb.Problem_ORPHAN_BINDING}})
+	if err != nil || len(reply.Findings) != 1 || reply.Findings[0].Subject != "52:54:aa:11:bb:33" {
+		t.Errorf("unexpected findings after the fix: %v %v", reply, err)
+	}
+	data, _ := ioutil.ReadFile(conf.HostsPath)
+	if string(data) != "127.0.0.1\tlocalhost\n192.168.1.1\tgateway.test.lan\tgateway\n192.168.1.62\tclient.test.lan\n" {
+		t.Errorf("unexpected hosts after the fix: %q", data)
+	}
+	data, _ = ioutil.ReadFile(conf.LeasesPath)
+	if string(data) != "52:54:aa:11:bb:33,192.168.1.63\n52:54:aa:11:bb:55,10.0.0.5,outside\n52:54:aa:11:bb:66,192.168.1.62\n" {
+		t.Errorf("unexpected leases after the fix: %q", data)
+	}
+
+	// the lines out of range are dropped only if asked for
+	reply, err = dmm.Check(ctx, &pb.CheckRequest{Fix: pb.Resolution_KEEP_FIRST, Problems: []pb.Problem{pb.Problem_OUT_OF_RANGE}})
+	if err != nil || len(reply.Findings) != 2 || len(reply.Findings[0].Dropped) != 1 || len(reply.Findings[1].Dropped) != 1 {
+		t.Errorf("unexpected findings dropping the lines out of range: %v %v", reply, err)
+	}
+	dmm.Close()
+
+	data, _ = ioutil.ReadFile(conf.LeasesPath)
+	if string(data) != "52:54:aa:11:bb:33,192.168.1.63\n52:54:aa:11:bb:66,192.168.1.62\n" {
+		t.Errorf("unexpected leases after dropping the lines out of range: %q", data)
+	}
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil || len(entries) != 2 || entries[0].Action != journal.ActionFix {
+		t.Errorf("unexpected journal: %v %v", entries, err)
+	}
+}
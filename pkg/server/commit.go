@@ -0,0 +1,201 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The managed files are replaced with a simple ordered commit:
+// 1. every file is written to a temporary file in the same directory of the target, and synced.
+// 2. a commit marker listing the pending renames is written and synced.
+// 3. the temporary files are renamed over the targets, in order.
+// 4. the commit marker is removed.
+// If we crash before 2, the targets are untouched, and the leftovers are just garbage.
+// If we crash after 2, recoverCommit completes the renames on the next startup.
+// So dnsmasq never sees a truncated file, and never sees a hosts file and a leases file
+// coming from different generations for longer than the recovery takes.
+// Temporary files and the marker are dotfiles, which dnsmasq ignores when reading directories.
+
+const commitMarkerName string = ".dnsmasqmgr.commit"
+
+type fileUpdate struct {
+	Path string `json:"path"`
+	Temp string `json:"temp"`
+	data []byte
+	mode os.FileMode
+}
+
+func commitMarkerPath(path string) string {
+	return filepath.Join(filepath.Dir(path), commitMarkerName)
+}
+
+func tempPathFor(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func writeSynced(path string, data []byte, mode os.FileMode) error {
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func applyRenames(updates []fileUpdate) error {
+	for _, upd := range updates {
+		err := os.Rename(upd.Temp, upd.Path)
+		if err != nil {
+			return err
+		}
+		err = syncDir(upd.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrCommitPending reports a commit whose renames failed after the marker was written
+var ErrCommitPending error = errors.New("Managed files not replaced, the commit completes on the next startup")
+
+// commitFiles atomically replaces all the files in updates. marker is the path of the commit marker.
+// Once the marker is written, failed renames are retried once; if they fail again, the marker is left
+// for recoverCommit to complete the commit on the next startup, and ErrCommitPending is returned.
+func commitFiles(marker string, updates []fileUpdate) error {
+	for ix := range updates {
+		updates[ix].Temp = tempPathFor(updates[ix].Path)
+		err := writeSynced(updates[ix].Temp, updates[ix].data, updates[ix].mode)
+		if err != nil {
+			removeTemps(updates[:ix])
+			return err
+		}
+	}
+
+	data, err := json.Marshal(updates)
+	if err != nil {
+		removeTemps(updates)
+		return err
+	}
+	// the marker itself must never be seen half-written
+	markerTemp := tempPathFor(marker)
+	err = writeSynced(markerTemp, data, 0644)
+	if err != nil {
+		removeTemps(updates)
+		return err
+	}
+	err = os.Rename(markerTemp, marker)
+	if err == nil {
+		err = syncDir(marker)
+	}
+	if err != nil {
+		// nothing was renamed yet, so the commit can still be abandoned
+		os.Remove(markerTemp)
+		os.Remove(marker)
+		removeTemps(updates)
+		return err
+	}
+
+	err = applyRenames(updates)
+	if err == nil {
+		err = os.Remove(marker)
+		if err == nil {
+			err = syncDir(marker)
+		}
+		return err
+	}
+	log.Printf("server: commit interrupted, retrying: %v", err)
+	err = recoverCommit(marker)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCommitPending, err)
+	}
+	return nil
+}
+
+func removeTemps(updates []fileUpdate) {
+	for _, upd := range updates {
+		os.Remove(upd.Temp)
+	}
+}
+
+// recoverCommit completes a commit interrupted after the marker was written, if any.
+func recoverCommit(marker string) error {
+	data, err := ioutil.ReadFile(marker)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var updates []fileUpdate
+	err = json.Unmarshal(data, &updates)
+	if err != nil {
+		return err
+	}
+	for _, upd := range updates {
+		_, err := os.Stat(upd.Temp)
+		if os.IsNotExist(err) {
+			// already renamed before the interruption
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = applyRenames([]fileUpdate{upd})
+		if err != nil {
+			return err
+		}
+		log.Printf("server: recovered interrupted commit of '%s'", upd.Path)
+	}
+
+	err = os.Remove(marker)
+	if err != nil {
+		return err
+	}
+	return syncDir(marker)
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"testing"
+)
+
+func TestCommitFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commit")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostsPath := filepath.Join(dir, "hosts")
+	leasesPath := filepath.Join(dir, "dhcphosts")
+	ioutil.WriteFile(hostsPath, []byte("old hosts\n"), 0644)
+	ioutil.WriteFile(leasesPath, []byte("old leases\n"), 0600)
+
+	marker := commitMarkerPath(hostsPath)
+	err = commitFiles(marker, []fileUpdate{
+		{Path: hostsPath, data: []byte("new hosts\n"), mode: 0644},
+		{Path: leasesPath, data: []byte("new leases\n"), mode: 0600},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+
+	checkContent(t, hostsPath, "new hosts\n")
+	checkContent(t, leasesPath, "new leases\n")
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("leftover files after commit: %v", entries)
+	}
+}
+
+func TestCommitFilesFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commit")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostsPath := filepath.Join(dir, "hosts")
+	ioutil.WriteFile(hostsPath, []byte("old hosts\n"), 0644)
+
+	// the second file cannot be written: the first one is left alone, with no leftovers
+	err = commitFiles(commitMarkerPath(hostsPath), []fileUpdate{
+		{Path: hostsPath, data: []byte("new hosts\n"), mode: 0644},
+		{Path: filepath.Join(dir, "missing", "dhcphosts"), data: []byte("new leases\n"), mode: 0600},
+	})
+	if err == nil {
+		t.Fatalf("unexpected success committing to a missing directory")
+	}
+	checkContent(t, hostsPath, "old hosts\n")
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("leftover files after a failed commit: %v", entries)
+	}
+}
+
+func TestCommitFilesPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commit")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostsPath := filepath.Join(dir, "hosts")
+	leasesPath := filepath.Join(dir, "dhcphosts")
+	ioutil.WriteFile(hostsPath, []byte("old hosts\n"), 0644)
+	// a file cannot be renamed over a directory which is not empty
+	os.MkdirAll(filepath.Join(leasesPath, "busy"), 0755)
+
+	marker := commitMarkerPath(hostsPath)
+	err = commitFiles(marker, []fileUpdate{
+		{Path: hostsPath, data: []byte("new hosts\n"), mode: 0644},
+		{Path: leasesPath, data: []byte("new leases\n"), mode: 0600},
+	})
+	if !errors.Is(err, ErrCommitPending) {
+		t.Fatalf("unexpected error committing over a directory: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("commit marker removed: %v", err)
+	}
+
+	os.RemoveAll(leasesPath)
+	err = recoverCommit(marker)
+	if err != nil {
+		t.Fatalf("unexpected error recovering: %v", err)
+	}
+	checkContent(t, hostsPath, "new hosts\n")
+	checkContent(t, leasesPath, "new leases\n")
+}
+
+func TestRecoverInterruptedCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commit")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostsPath := filepath.Join(dir, "hosts")
+	leasesPath := filepath.Join(dir, "dhcphosts")
+	marker := commitMarkerPath(hostsPath)
+
+	// crash after the hosts file was renamed, but before the leases file was
+	updates := []fileUpdate{
+		{Path: hostsPath, Temp: tempPathFor(hostsPath)},
+		{Path: leasesPath, Temp: tempPathFor(leasesPath)},
+	}
+	ioutil.WriteFile(hostsPath, []byte("new hosts\n"), 0644)
+	ioutil.WriteFile(leasesPath, []byte("old leases\n"), 0644)
+	ioutil.WriteFile(updates[1].Temp, []byte("new leases\n"), 0644)
+	data, _ := json.Marshal(updates)
+	ioutil.WriteFile(marker, data, 0644)
+
+	err = recoverCommit(marker)
+	if err != nil {
+		t.Fatalf("unexpected error recovering: %v", err)
+	}
+	checkContent(t, hostsPath, "new hosts\n")
+	checkContent(t, leasesPath, "new leases\n")
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("commit marker not removed: %v", err)
+	}
+
+	// nothing to do
+	err = recoverCommit(marker)
+	if err != nil {
+		t.Errorf("unexpected error recovering without marker: %v", err)
+	}
+}
+
+func checkContent(t *testing.T, path, expected string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("unexpected error reading back %s: %v", path, err)
+	}
+	if string(content) != expected {
+		t.Errorf("inconsistent content in %s: %q %q", path, string(content), expected)
+	}
+}
@@ -24,21 +24,69 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/apcera/util/iprange"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
 )
 
 const (
 	DefaultIface string = "127.0.0.1"
 	DefaultPort  int    = 50777
+	// MaxIP6RangeBits bounds the size of the IPv6 pool: the allocator counts addresses in 64 bits.
+	MaxIP6RangeBits int = 32
+	// MaxExcludeBits bounds the size of the exclusions, which are reserved one address at a time.
+	MaxExcludeBits int = 16
+	// DefaultPoolName is the name of the pool made of IPRange and IP6Range
+	DefaultPoolName string = "default"
 )
 
+// What to do with the changes done to the managed files outside dnsmasqmgrd. See Config.ExternalEdits.
+const (
+	EditsMerge     string = "merge"
+	EditsRefuse    string = "refuse"
+	EditsOverwrite string = "overwrite"
+)
+
+// Pool is a named set of addresses, usually a VLAN served by dnsmasq
+type Pool struct {
+	Name string `json:"name"`
+	// Subnet is the network of the pool in CIDR notation (optional). Ranges and requested addresses must fit in it.
+	Subnet string `json:"subnet"`
+	// Range is the IPv4 range addresses are taken from, like Config.IPRange
+	Range string `json:"range"`
+	// Range6 is the optional IPv6 range, like Config.IP6Range
+	Range6 string `json:"range6"`
+	// Exclude lists the addresses or ranges never handed out, e.g. "192.168.10.1" or "192.168.10.100-110"
+	Exclude []string `json:"exclude"`
+	// Domain completes the hostnames without domain requested in the pool. Defaults to Config.Domain.
+	Domain string `json:"domain"`
+	// MACPrefixes select the pool for the requests from these hardware addresses, e.g. "b8:27:eb"
+	MACPrefixes []string `json:"macprefixes"`
+	// Tags select the pool for the requests carrying any of them, and are set on the dhcp-host
+	// lines of the pool (set:<tag>), so dnsmasq can apply per-pool options.
+	Tags []string `json:"tags"`
+}
+
 type Config struct {
-	IPRange     string `json:"iprange"`
+	IPRange string `json:"iprange"`
+	// IP6Range is the optional IPv6 pool, with full addresses (e.g. "fd00::100-fd00::1ff").
+	// If set, every new entry gets an IPv6 address too.
+	IP6Range    string `json:"ip6range"`
 	HostsPath   string `json:"hostspath"`
 	LeasesPath  string `json:"leasespath"`
 	CertFile    string `json:"certfile"`
@@ -46,6 +94,41 @@ type Config struct {
 	Iface       string `json:"iface"`
 	Port        int    `json:"port"`
 	JournalPath string `json:"journalpath"`
+	// SnapshotDir holds the snapshots of the managed files the journal is replayed on.
+	// Snapshots are disabled if empty.
+	SnapshotDir string `json:"snapshotdir"`
+	// SnapshotInterval is how often a snapshot is taken (Go duration syntax). Disabled if empty.
+	SnapshotInterval string `json:"snapshotinterval"`
+	// SnapshotKeep is how many snapshots are kept. All of them are kept if zero.
+	SnapshotKeep int `json:"snapshotkeep"`
+	// JournalMaxSize is the size in bytes past which the journal is rotated. No limit if zero.
+	JournalMaxSize int64 `json:"journalmaxsize"`
+	// JournalMaxAge is the age past which the journal is rotated (Go duration syntax). No limit if empty.
+	JournalMaxAge string `json:"journalmaxage"`
+	// LeasesOrder is the layout of the leases file: "insertion" (default) or "ip"
+	LeasesOrder string `json:"leasesorder"`
+	// ClientCAFile holds the CA certificates (PEM) the client certificates must be signed by.
+	// If set, clients must present a certificate (mutual TLS). Requires CertFile and KeyFile.
+	ClientCAFile string `json:"clientcafile"`
+	// ActiveLeasesPath is the leases file of dnsmasq (--dhcp-leasefile), e.g. /var/lib/misc/dnsmasq.leases.
+	// It is only read, to report the dynamic leases. Optional.
+	ActiveLeasesPath string `json:"activeleasespath"`
+	// PolicyPath is the authorization policy for the changes (see the policy package).
+	// Everyone can change everything if empty.
+	PolicyPath string `json:"policypath"`
+	// ExternalEdits is what to do when the managed files are changed outside dnsmasqmgrd:
+	// "merge" (default) merges the edits with the changes done through dnsmasqmgrd;
+	// "refuse" refuses any change until the edits are reverted or dnsmasqmgrd is restarted;
+	// "overwrite" discards the edits on the next change, like older releases did.
+	ExternalEdits string `json:"externaledits"`
+	// WatchInterval is how often the managed files are checked for external edits when inotify
+	// is not available (Go duration syntax). Defaults to 2s.
+	WatchInterval string `json:"watchinterval"`
+	// Domain is the local domain, for the pools without a domain of their own. The hostnames
+	// without domain are completed with it, and the ones in it get their short name as alias.
+	Domain string `json:"domain"`
+	// Pools are the named pools, in addition to the default one made of IPRange and IP6Range, if any.
+	Pools []Pool `json:"pools"`
 }
 
 func Default() *Config {
@@ -67,24 +150,227 @@ func ParseFile(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// ParseDuration parses a duration in the Go syntax. The empty string means zero.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration: %s", s)
+	}
+	return d, nil
+}
+
+// ParseIP6Range parses the IPv6 pool. See Config.IP6Range.
+func ParseIP6Range(s string) (*iprange.IPRange, error) {
+	ips, err := iprange.ParseIPRange(s)
+	if err != nil {
+		return nil, err
+	}
+	if ips.Start.To4() != nil || ips.End.To4() != nil {
+		return nil, fmt.Errorf("not an IPv6 range: %s", s)
+	}
+	if rangeBits(ips) > MaxIP6RangeBits {
+		return nil, fmt.Errorf("IPv6 range too large: %s", s)
+	}
+	return ips, nil
+}
+
+// rangeBits returns how many bits are needed to count the addresses of the range
+func rangeBits(ips *iprange.IPRange) int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(ips.End), new(big.Int).SetBytes(ips.Start))
+	return size.BitLen()
+}
+
+// AllPools returns the configured pools, starting with the default one, if any
+func (cfg *Config) AllPools() []Pool {
+	var ret []Pool
+	if cfg.IPRange != "" {
+		ret = append(ret, Pool{
+			Name:   DefaultPoolName,
+			Range:  cfg.IPRange,
+			Range6: cfg.IP6Range,
+			Domain: cfg.Domain,
+		})
+	}
+	for _, p := range cfg.Pools {
+		if p.Domain == "" {
+			p.Domain = cfg.Domain
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// ParsedPool is a Pool with all its addresses parsed
+type ParsedPool struct {
+	Pool
+	Net      *net.IPNet
+	IPs      *iprange.IPRange
+	IP6s     *iprange.IPRange
+	Excluded []*iprange.IPRange
+}
+
+// Parse validates the Pool and parses its addresses
+func (p Pool) Parse() (*ParsedPool, error) {
+	var err error
+	pp := ParsedPool{Pool: p}
+	if p.Name == "" {
+		return nil, fmt.Errorf("missing pool name")
+	}
+	if p.Subnet != "" {
+		_, pp.Net, err = net.ParseCIDR(p.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: bad subnet: %v", p.Name, err)
+		}
+	}
+	pp.IPs, err = iprange.ParseIPRange(p.Range)
+	if err != nil {
+		return nil, fmt.Errorf("pool %s: bad range: %v", p.Name, err)
+	}
+	if pp.IPs.Start.To4() == nil {
+		return nil, fmt.Errorf("pool %s: not an IPv4 range: %s", p.Name, p.Range)
+	}
+	if p.Range6 != "" {
+		pp.IP6s, err = ParseIP6Range(p.Range6)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: bad range6: %v", p.Name, err)
+		}
+	}
+	if !pp.Contains(pp.IPs.Start) || !pp.Contains(pp.IPs.End) {
+		return nil, fmt.Errorf("pool %s: range %s out of subnet %s", p.Name, p.Range, p.Subnet)
+	}
+	for _, x := range p.Exclude {
+		ips, err := iprange.ParseIPRange(x)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: bad exclusion: %v", p.Name, err)
+		}
+		if rangeBits(ips) > MaxExcludeBits {
+			return nil, fmt.Errorf("pool %s: exclusion too large: %s", p.Name, x)
+		}
+		pp.Excluded = append(pp.Excluded, ips)
+	}
+	if p.Domain != "" {
+		if err := etchosts.CheckHostname(strings.TrimPrefix(p.Domain, ".")); err != nil {
+			return nil, fmt.Errorf("pool %s: bad domain: %v", p.Name, err)
+		}
+	}
+	for _, prefix := range p.MACPrefixes {
+		if prefix == "" || strings.Trim(strings.ToLower(prefix), "0123456789abcdef:-") != "" {
+			return nil, fmt.Errorf("pool %s: bad mac prefix: %q", p.Name, prefix)
+		}
+	}
+	return &pp, nil
+}
+
+// Contains returns true if the IPv4 address is in the subnet of the pool, or if the pool has no subnet
+func (pp *ParsedPool) Contains(ip net.IP) bool {
+	return pp.Net == nil || pp.Net.Contains(ip)
+}
+
+// ParsePools validates and parses all the pools. See AllPools.
+func (cfg *Config) ParsePools() ([]*ParsedPool, error) {
+	var ret []*ParsedPool
+	for _, p := range cfg.AllPools() {
+		pp, err := p.Parse()
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range ret {
+			if x.Name == pp.Name {
+				return nil, fmt.Errorf("duplicated pool: %s", pp.Name)
+			}
+			if x.IPs.Overlaps(pp.IPs) || (x.IP6s != nil && pp.IP6s != nil && x.IP6s.Overlaps(pp.IP6s)) {
+				return nil, fmt.Errorf("pool %s overlaps pool %s", pp.Name, x.Name)
+			}
+		}
+		ret = append(ret, pp)
+	}
+	return ret, nil
+}
+
 func (cfg *Config) Check() error {
-	if cfg.IPRange == "" {
-		return fmt.Errorf("ip range must be specified")
+	if cfg.IPRange == "" && len(cfg.Pools) == 0 {
+		return fmt.Errorf("ip range or pools must be specified")
+	}
+	if cfg.IPRange == "" && cfg.IP6Range != "" {
+		return fmt.Errorf("ip6 range requires the ip range")
+	}
+	if _, err := cfg.ParsePools(); err != nil {
+		return err
+	}
+	if cfg.ClientCAFile != "" && (cfg.CertFile == "" || cfg.KeyFile == "") {
+		return fmt.Errorf("client CA requires the server certificate and key")
+	}
+	if cfg.PolicyPath != "" {
+		if _, err := policy.ParseFile(cfg.PolicyPath); err != nil {
+			return fmt.Errorf("bad policy: %v", err)
+		}
 	}
 	if cfg.HostsPath == "" || cfg.LeasesPath == "" {
 		return fmt.Errorf("missing configuration files: hosts=[%v] leases=[%v]", cfg.HostsPath, cfg.LeasesPath)
 	}
+	if cfg.SnapshotDir != "" && cfg.JournalPath == "" {
+		return fmt.Errorf("snapshots require the journal")
+	}
+	if _, err := ParseDuration(cfg.SnapshotInterval); err != nil {
+		return fmt.Errorf("bad snapshot interval: %v", err)
+	}
+	if _, err := ParseDuration(cfg.JournalMaxAge); err != nil {
+		return fmt.Errorf("bad journal max age: %v", err)
+	}
+	if cfg.SnapshotKeep < 0 || cfg.JournalMaxSize < 0 {
+		return fmt.Errorf("negative limits: snapshotkeep=%d journalmaxsize=%d", cfg.SnapshotKeep, cfg.JournalMaxSize)
+	}
+	if _, err := dhcphosts.ParseOrder(cfg.LeasesOrder); err != nil {
+		return fmt.Errorf("bad leases order: %v", err)
+	}
+	switch cfg.ExternalEdits {
+	case "", EditsMerge, EditsRefuse, EditsOverwrite:
+	default:
+		return fmt.Errorf("bad external edits policy: %q", cfg.ExternalEdits)
+	}
+	if _, err := ParseDuration(cfg.WatchInterval); err != nil {
+		return fmt.Errorf("bad watch interval: %v", err)
+	}
 	return nil
 }
 
+// SetupTLS returns the options to serve over TLS, if CertFile and KeyFile are set,
+// requiring the client certificates if ClientCAFile is set too.
 func (cfg *Config) SetupTLS() ([]grpc.ServerOption, error) {
-	var err error
-	var opts []grpc.ServerOption
-	if cfg.CertFile != "" && cfg.KeyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(cfg.CertFile, cfg.KeyFile)
-		if err == nil {
-			opts = []grpc.ServerOption{grpc.Creds(creds)}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if cfg.ClientCAFile != "" {
+		tlsConf.ClientCAs, err = loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
 		}
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConf))}, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
 	}
-	return opts, err
+	return pool, nil
 }
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
+)
+
+// errorCodes maps the errors of the server and of the managed files to gRPC codes,
+// so clients can tell them apart without parsing messages.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{etchosts.ErrNotFoundHostname, codes.NotFound},
+	{etchosts.ErrNotFoundAddress, codes.NotFound},
+	{etchosts.ErrNotFoundAlias, codes.NotFound},
+	{dhcphosts.ErrHWAddrNotFound, codes.NotFound},
+	{dhcphosts.ErrIPAddrNotFound, codes.NotFound},
+	{ErrUnknownPool, codes.NotFound},
+	{ErrLeaseNotFound, codes.NotFound},
+	{etchosts.ErrDuplicate, codes.AlreadyExists},
+	{dhcphosts.ErrDuplicateFound, codes.AlreadyExists},
+	{ErrAddrInUse, codes.AlreadyExists},
+	{ErrRequestData, codes.InvalidArgument},
+	{ErrInvalidParam, codes.InvalidArgument},
+	{ErrMissingKey, codes.InvalidArgument},
+	{ErrOutOfSubnet, codes.InvalidArgument},
+	{ErrNoHostname, codes.InvalidArgument},
+	{etchosts.ErrBadIPFormat, codes.InvalidArgument},
+	{etchosts.ErrBadEntryFormat, codes.InvalidArgument},
+	{etchosts.ErrMissingHostname, codes.InvalidArgument},
+	{etchosts.ErrBadHostname, codes.InvalidArgument},
+	{dhcphosts.ErrBadHWAddrFormat, codes.InvalidArgument},
+	{dhcphosts.ErrBadIPFormat, codes.InvalidArgument},
+	{dhcphosts.ErrBadBindingFormat, codes.InvalidArgument},
+	{ErrPoolExhaust, codes.ResourceExhausted},
+	{ErrReadOnly, codes.FailedPrecondition},
+	{ErrIncomplete, codes.FailedPrecondition},
+	{ErrNoPools, codes.FailedPrecondition},
+	{ErrNoActiveLeases, codes.FailedPrecondition},
+	{ErrNoJournal, codes.FailedPrecondition},
+	{ErrNoSnapshots, codes.FailedPrecondition},
+	{ErrExternalEdit, codes.FailedPrecondition},
+	{ErrMismatch, codes.Aborted},
+	{ErrEditConflict, codes.Aborted},
+	{ErrWatcherLag, codes.Aborted},
+	{ErrRevisionGone, codes.OutOfRange},
+	{ErrFuturePoint, codes.OutOfRange},
+	{ErrPastPoint, codes.OutOfRange},
+	{ErrNotSupported, codes.Unimplemented},
+	{policy.ErrDenied, codes.PermissionDenied},
+	{policy.ErrBadToken, codes.Unauthenticated},
+}
+
+// errorDetails tells apart the errors sharing a code, attaching a pb.ErrorDetail to their statuses
+var errorDetails = []struct {
+	err    error
+	detail pb.Error
+}{
+	{ErrMismatch, pb.Error_MISMATCH},
+	{ErrEditConflict, pb.Error_CONFLICT},
+	{ErrWatcherLag, pb.Error_LAGGING},
+}
+
+// ToStatus converts err to a gRPC status error. Errors which are already statuses are returned unchanged.
+// The handlers return plain errors, and leave the conversion to the interceptors.
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return withDetail(status.New(ec.code, err.Error()), err).Err()
+		}
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+func withDetail(st *status.Status, err error) *status.Status {
+	for _, ed := range errorDetails {
+		if errors.Is(err, ed.err) {
+			if ret, derr := st.WithDetails(&pb.ErrorDetail{Error: ed.detail}); derr == nil {
+				return ret
+			}
+		}
+	}
+	return st
+}
+
+// UnaryErrorInterceptor converts the errors of the unary calls with ToStatus
+func UnaryErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, ToStatus(err)
+}
+
+// StreamErrorInterceptor converts the errors of the streaming calls with ToStatus
+func StreamErrorInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return ToStatus(handler(srv, ss))
+}
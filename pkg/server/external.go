@@ -0,0 +1,363 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+)
+
+// The managed files may be edited by hand while dnsmasqmgrd runs. We remember the content
+// we last read or wrote, and before every write, and whenever the files are noticed to change,
+// we compare it with the content on disk. The edits are then either merged, refused or
+// overwritten, depending on config.Config.ExternalEdits.
+// The merge is a three-way merge by lines: the content we last saw is the common ancestor,
+// the in-memory state is ours, the content on disk is theirs. Lines are the natural unit here,
+// because both etchosts and dhcphosts keep the lines they did not change verbatim.
+
+var (
+	ErrExternalEdit error = errors.New("Managed files changed outside dnsmasqmgrd")
+	ErrEditConflict error = errors.New("Managed files changed outside dnsmasqmgrd in conflict with the request")
+)
+
+// seenFile is the content of a managed file as dnsmasqmgrd last read or wrote it
+type seenFile struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+	content string
+}
+
+// readSeen reads the file. The file is stat-ed first, so if it changes meanwhile
+// the next check notices it by the modification time.
+func readSeen(path string) (seenFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return seenFile{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return seenFile{}, err
+	}
+	return seenFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		sum:     sha256.Sum256(data),
+		content: string(data),
+	}, nil
+}
+
+// wroteSeen records the content just written to the file
+func wroteSeen(path string, data []byte) seenFile {
+	sf := seenFile{
+		sum:     sha256.Sum256(data),
+		content: string(data),
+	}
+	if info, err := os.Stat(path); err == nil {
+		sf.modTime, sf.size = info.ModTime(), info.Size()
+	}
+	return sf
+}
+
+// check returns the current content of the file, and true if it differs from the one seen.
+// The file is read only if its modification time or size changed.
+func (sf seenFile) check(path string) (seenFile, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sf, false, err
+	}
+	if info.ModTime().Equal(sf.modTime) && info.Size() == sf.size {
+		return sf, false, nil
+	}
+	cur, err := readSeen(path)
+	if err != nil {
+		return sf, false, err
+	}
+	return cur, cur.sum != sf.sum, nil
+}
+
+// syncExternal handles the edits done to the managed files outside dnsmasqmgrd according to the policy.
+// It returns an error if the managed files must not be written. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) syncExternal() error {
+	if dmm.editPolicy == config.EditsOverwrite {
+		return nil
+	}
+	err := dmm.adoptExternal()
+	if err != nil {
+		if dmm.editAlert == nil {
+			log.Printf("server: refusing to change the managed files: %v", err)
+		}
+		dmm.editAlert = err
+		return err
+	}
+	if dmm.editAlert != nil {
+		log.Printf("server: managed files are consistent again, accepting changes")
+		dmm.editAlert = nil
+	}
+	return nil
+}
+
+func (dmm *DNSMasqMgr) adoptExternal() error {
+	hosts, hostsChanged, err := dmm.hostsSeen.check(dmm.hostsPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrExternalEdit, err)
+	}
+	leases, leasesChanged, err := dmm.leasesSeen.check(dmm.leasesPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrExternalEdit, err)
+	}
+	if !hostsChanged && !leasesChanged {
+		// at most touched: no need to read them again next time
+		dmm.hostsSeen, dmm.leasesSeen = hosts, leases
+		return nil
+	}
+	log.Printf("server: managed files changed outside dnsmasqmgrd: hosts=%v leases=%v", hostsChanged, leasesChanged)
+	if dmm.editPolicy == config.EditsRefuse {
+		return ErrExternalEdit
+	}
+	return dmm.mergeExternal(hosts, leases)
+}
+
+// mergeExternal merges the edits which brought the managed files from the content seen to hosts and leases
+// into the in-memory state. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) mergeExternal(hosts, leases seenFile) error {
+	theirNames, theirAddrs, err := parseState(hosts.content, leases.content)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrExternalEdit, err)
+	}
+	order := dmm.addrMap.Order()
+	theirAddrs.SetOrder(order)
+
+	mergedHosts, err := mergeLines(dmm.hostsSeen.content, hosts.content, dmm.nameMap.String(), hostKeys)
+	if err != nil {
+		return err
+	}
+	mergedLeases, err := mergeLines(dmm.leasesSeen.content, leases.content, dmm.addrMap.String(), bindingKeys)
+	if err != nil {
+		return err
+	}
+	nameMap, addrMap, err := parseState(mergedHosts, mergedLeases)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEditConflict, err)
+	}
+	addrMap.SetOrder(order)
+
+	prev := dmm.checkpoint()
+	dmm.nameMap, dmm.addrMap = nameMap, addrMap
+	dmm.hostsSeen, dmm.leasesSeen = hosts, leases
+	dmm.replaced(prev)
+	dmm.recordResync(pb.Action_EXTERNAL, theirNames, theirAddrs)
+	log.Printf("server: merged the edits done outside dnsmasqmgrd")
+	return nil
+}
+
+// replaced gives back to the pools the addresses dropped since prev, and takes out the ones added.
+// Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) replaced(prev checkpoint) {
+	for _, h := range prev.nameMap.Hosts() {
+		dmm.releaseUnused(h.Address)
+	}
+	for _, b := range prev.addrMap.Bindings() {
+		for _, ip := range b.IPs() {
+			dmm.releaseUnused(ip)
+		}
+	}
+	dmm.reserveInUse()
+}
+
+// recordResync assigns a revision to the changes which replaced the managed files as a whole,
+// either the edits done outside dnsmasqmgrd or the fixes of Check, leaving them as in nameMap and addrMap.
+// The journal refers to them through a snapshot of the files, so they can be replayed only
+// if snapshots are enabled. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) recordResync(action pb.Action, nameMap *etchosts.Conf, addrMap *dhcphosts.Conf) {
+	ev := dmm.events.publish(action, &pb.Address{}, nil)
+	if dmm.journal == nil {
+		return
+	}
+	now := time.Unix(ev.Timestamp, 0).UTC()
+	if dmm.snapshotDir == "" {
+		log.Printf("server: the changes at revision %d cannot be replayed without snapshots", ev.Revision)
+	} else {
+		err := journal.WriteSnapshot(dmm.snapshotDir, &journal.Snapshot{
+			Revision: ev.Revision,
+			Time:     now,
+			Hosts:    nameMap.String(),
+			Leases:   addrMap.String(),
+		})
+		if err != nil {
+			log.Printf("server: cannot snapshot the changes at revision %d: %v", ev.Revision, err)
+		}
+	}
+	err := dmm.journal.Append(&journal.Entry{
+		Revision: ev.Revision,
+		Time:     now,
+		Action:   journalActions[action],
+	})
+	if err != nil {
+		log.Printf("cannot add to journal: %v", err)
+	}
+}
+
+// watchLoop handles the external edits as soon as they are noticed, rather than on the next change
+func (dmm *DNSMasqMgr) watchLoop() {
+	for range dmm.watcher.C {
+		dmm.lock.Lock()
+		dmm.syncExternal()
+		dmm.lock.Unlock()
+	}
+	close(dmm.watchDone)
+}
+
+// hostKeys returns the names and the address the etchosts line is about
+func hostKeys(line string) []string {
+	h, err := etchosts.ParseHostString(line)
+	if err != nil {
+		return nil
+	}
+	return append(h.Names(), h.Address.String())
+}
+
+// bindingKeys returns the client key and the addresses the dhcphosts line is about
+func bindingKeys(line string) []string {
+	s := strings.TrimSpace(line)
+	if s == "" || strings.HasPrefix(s, "#") {
+		return nil
+	}
+	b, err := dhcphosts.ParseBindingString(s)
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	if key := b.Key(); key != "" {
+		ret = append(ret, key)
+	}
+	for _, ip := range b.IPs() {
+		ret = append(ret, ip.String())
+	}
+	return ret
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// subtract returns the lines of a not in b, counting repeated lines, in the order of a
+func subtract(a, b []string) []string {
+	count := make(map[string]int)
+	for _, l := range b {
+		count[l]++
+	}
+	var ret []string
+	for _, l := range a {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+func indexFrom(lines []string, l string, from int) int {
+	for ix := from; ix < len(lines); ix++ {
+		if lines[ix] == l {
+			return ix
+		}
+	}
+	return -1
+}
+
+// mergeLines applies to ours the lines removed and added going from base to theirs.
+// The lines added go after the line preceding them in theirs. The edits conflict
+// with ours if they touch any of the keys ours changed, unless both did the same change.
+func mergeLines(base, theirs, ours string, keys func(string) []string) (string, error) {
+	baseLines, theirLines, ourLines := splitLines(base), splitLines(theirs), splitLines(ours)
+	removed, added := subtract(baseLines, theirLines), subtract(theirLines, baseLines)
+	ourRemoved, ourAdded := subtract(baseLines, ourLines), subtract(ourLines, baseLines)
+	// the changes done on both sides are already in ours
+	removed, ourRemoved = subtract(removed, ourRemoved), subtract(ourRemoved, removed)
+	added, ourAdded = subtract(added, ourAdded), subtract(ourAdded, added)
+
+	touched := make(map[string]bool)
+	for _, lines := range [][]string{ourRemoved, ourAdded} {
+		for _, l := range lines {
+			for _, k := range keys(l) {
+				touched[k] = true
+			}
+		}
+	}
+	for _, lines := range [][]string{removed, added} {
+		for _, l := range lines {
+			for _, k := range keys(l) {
+				if touched[k] {
+					return "", fmt.Errorf("%w: %s", ErrEditConflict, k)
+				}
+			}
+		}
+	}
+
+	ret := append([]string(nil), ourLines...)
+	for _, l := range removed {
+		if ix := indexFrom(ret, l, 0); ix != -1 {
+			ret = append(ret[:ix], ret[ix+1:]...)
+		}
+	}
+	pending := make(map[string]int)
+	for _, l := range added {
+		pending[l]++
+	}
+	pos := 0
+	for _, l := range theirLines {
+		if pending[l] > 0 {
+			pending[l]--
+			ret = append(ret, "")
+			copy(ret[pos+1:], ret[pos:])
+			ret[pos] = l
+			pos++
+		} else if ix := indexFrom(ret, l, pos); ix != -1 {
+			pos = ix + 1
+		}
+	}
+	return joinLines(ret), nil
+}
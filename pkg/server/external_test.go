@@ -0,0 +1,237 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+)
+
+func TestMergeLines(t *testing.T) {
+	base := "127.0.0.1\tlocalhost\n# servers\n192.168.1.9\tserver\n192.168.1.63\tclient\n"
+	testCases := []struct {
+		name   string
+		theirs string
+		ours   string
+		merged string
+		err    error
+	}{
+		{
+			name:   "unchanged",
+			theirs: base,
+			ours:   base,
+			merged: base,
+		},
+		{
+			name:   "both added",
+			theirs: "127.0.0.1\tlocalhost\n# servers\n192.168.1.9\tserver\n192.168.1.10\tbackup\n192.168.1.63\tclient\n",
+			ours:   base + "192.168.1.64\tnew\n",
+			merged: "127.0.0.1\tlocalhost\n# servers\n192.168.1.9\tserver\n192.168.1.10\tbackup\n192.168.1.63\tclient\n192.168.1.64\tnew\n",
+		},
+		{
+			name:   "they removed, we changed",
+			theirs: "127.0.0.1\tlocalhost\n192.168.1.63\tclient\n",
+			ours:   "127.0.0.1\tlocalhost\n# servers\n192.168.1.9\tserver\n192.168.1.63\tclient\tlaptop\n",
+			merged: "127.0.0.1\tlocalhost\n192.168.1.63\tclient\tlaptop\n",
+		},
+		{
+			name:   "same change",
+			theirs: base + "192.168.1.64\tnew\n",
+			ours:   base + "192.168.1.64\tnew\n",
+			merged: base + "192.168.1.64\tnew\n",
+		},
+		{
+			name:   "conflict",
+			theirs: "127.0.0.1\tlocalhost\n# servers\n192.168.1.9\tserver\n192.168.1.62\tclient\n",
+			ours:   "127.0.0.1\tlocalhost\n# servers\n192.168.1.9\tserver\n192.168.1.63\tclient\tlaptop\n",
+			err:    ErrEditConflict,
+		},
+		{
+			name:   "same address",
+			theirs: base + "192.168.1.64\tother\n",
+			ours:   base + "192.168.1.64\tnew\n",
+			err:    ErrEditConflict,
+		},
+	}
+	for _, tc := range testCases {
+		merged, err := mergeLines(base, tc.theirs, tc.ours, hostKeys)
+		if !errors.Is(err, tc.err) || merged != tc.merged {
+			t.Errorf("%s: unexpected merge: %q %v", tc.name, merged, err)
+		}
+	}
+}
+
+// stopWatching leaves the external edits to the next change, to make the tests deterministic
+func stopWatching(dmm *DNSMasqMgr) {
+	dmm.watcher.Close()
+	<-dmm.watchDone
+	dmm.watcher = nil
+}
+
+func appendFile(t *testing.T, path, s string) {
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("unexpected error opening %v: %v", path, err)
+	}
+	defer fh.Close()
+	fh.WriteString(s)
+}
+
+func TestExternalEditsMerge(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.SnapshotDir = filepath.Join(filepath.Dir(conf.HostsPath), "snapshots")
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	ctx := context.Background()
+
+	// noticed by the watcher
+	appendFile(t, conf.HostsPath, "192.168.1.60\tprinter.test.lan\n")
+	appendFile(t, conf.LeasesPath, "02:00:00:00:00:aa,192.168.1.60\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, err := dmm.LookupAddress(ctx, &pb.AddressRequest{Key: pb.Key_HOSTNAME, Addr: &pb.Address{Hostname: "printer.test.lan"}})
+		if err == nil && r.Addr.Macaddr == "02:00:00:00:00:aa" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("external edit not merged: %v %v", r, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	st, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Pools[0].Remaining != 3 || st.Alert != "" {
+		t.Errorf("unexpected status after merging: %v", st)
+	}
+
+	// noticed on the next change
+	stopWatching(dmm)
+	appendFile(t, conf.HostsPath, "192.168.1.2\tnas.test.lan\tnas\n")
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "new.test.lan", Macaddr: "02:00:00:00:00:01", Ipaddr: "192.168.1.61"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	data, _ := ioutil.ReadFile(conf.HostsPath)
+	for _, want := range []string{"printer.test.lan", "nas.test.lan\tnas", "new.test.lan"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in the hosts file:\n%s", want, data)
+		}
+	}
+
+	// a conflicting edit fails the change, and is merged on the next one
+	appendFile(t, conf.HostsPath, "192.168.1.62\tother.test.lan\n")
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "other.test.lan", Macaddr: "02:00:00:00:00:02", Ipaddr: "192.168.1.64"},
+	})
+	if status.Code(ToStatus(err)) != codes.Aborted {
+		t.Errorf("unexpected error on conflicting edits: %v", err)
+	}
+	st, _ = dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Alert == "" {
+		t.Errorf("missing alert after a conflict: %v", st)
+	}
+	err = dmm.Store()
+	if err != nil {
+		t.Errorf("unexpected error storing after a conflict: %v", err)
+	}
+	_, err = dmm.LookupAddress(ctx, &pb.AddressRequest{Key: pb.Key_HOSTNAME, Addr: &pb.Address{Hostname: "other.test.lan"}})
+	if err != nil {
+		t.Errorf("external edit not merged after a conflict: %v", err)
+	}
+	dmm.Close()
+
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil || len(entries) != 4 || entries[0].Action != journal.ActionExternal {
+		t.Fatalf("unexpected journal: %v %v", entries, err)
+	}
+	os.Remove(conf.HostsPath)
+	err = Recover(conf)
+	if err != nil {
+		t.Fatalf("unexpected error recovering: %v", err)
+	}
+	recovered, _ := ioutil.ReadFile(conf.HostsPath)
+	for _, want := range []string{"printer.test.lan", "nas.test.lan\tnas", "new.test.lan", "other.test.lan"} {
+		if !strings.Contains(string(recovered), want) {
+			t.Errorf("missing %q in the recovered hosts file:\n%s", want, recovered)
+		}
+	}
+}
+
+func TestExternalEditsRefuse(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.ExternalEdits = config.EditsRefuse
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+	stopWatching(dmm)
+	ctx := context.Background()
+
+	appendFile(t, conf.HostsPath, "192.168.1.60\tprinter.test.lan\n")
+	req := pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "new.test.lan", Macaddr: "02:00:00:00:00:01"},
+	}
+	_, err = dmm.RequestAddress(ctx, &req)
+	if status.Code(ToStatus(err)) != codes.FailedPrecondition {
+		t.Errorf("unexpected error after an external edit: %v", err)
+	}
+	st, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Alert == "" {
+		t.Errorf("missing alert after an external edit: %v", st)
+	}
+	data, _ := ioutil.ReadFile(conf.HostsPath)
+	if !strings.Contains(string(data), "printer.test.lan") || strings.Contains(string(data), "new.test.lan") {
+		t.Errorf("external edit overwritten:\n%s", data)
+	}
+
+	// reverting the edit lifts the alert
+	ioutil.WriteFile(conf.HostsPath, []byte(testHosts), 0644)
+	_, err = dmm.RequestAddress(ctx, &req)
+	if err != nil {
+		t.Errorf("unexpected error after reverting the external edit: %v", err)
+	}
+	st, _ = dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Alert != "" {
+		t.Errorf("unexpected alert after reverting the external edit: %v", st)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/dnsleases"
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+)
+
+var (
+	ErrNoActiveLeases error = errors.New("The active leases file is not configured")
+	ErrLeaseNotFound  error = errors.New("No active lease found")
+	ErrNoHostname     error = errors.New("The lease has no hostname, one must be given")
+)
+
+func toPbLease(l dnsleases.Lease) *pb.Lease {
+	ret := pb.Lease{
+		Ipaddr:    l.IP.String(),
+		Hostname:  l.Hostname,
+		Clientid:  l.ClientID,
+		Iaid:      l.IAID,
+		Temporary: l.Temporary,
+	}
+	if !l.Expiry.IsZero() {
+		ret.Expiry = l.Expiry.Unix()
+	}
+	if l.HW != nil {
+		ret.Macaddr = l.HW.String()
+	}
+	return &ret
+}
+
+// bindingOf finds the static binding of the client holding the lease: by hardware address,
+// then by client identifier, then by address. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) bindingOf(l dnsleases.Lease) (dhcphosts.Binding, bool) {
+	if l.HW != nil && l.HWType == 0 {
+		if b, err := dmm.addrMap.GetByHWAddr(l.HW.String()); err == nil {
+			return b, true
+		}
+	}
+	if l.ClientID != "" {
+		if b, err := dmm.addrMap.GetByKey("id:" + l.ClientID); err == nil {
+			return b, true
+		}
+	}
+	if b, err := dmm.addrMap.GetByIP(l.IP.String()); err == nil {
+		return b, true
+	}
+	return dhcphosts.Binding{}, false
+}
+
+// staticAddress returns the entry of the binding, as LookupAddress would. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) staticAddress(ctx context.Context, b dhcphosts.Binding) *pb.Address {
+	r, err := dmm.lookupAddressByMacaddr(ctx, b.Key())
+	if err != nil {
+		return nil
+	}
+	return r.Addr
+}
+
+// ListLeases reads the leases dnsmasq handed out, each one next to the static binding of the same client, if any.
+// The static bindings without a lease follow.
+func (dmm *DNSMasqMgr) ListLeases(ctx context.Context, req *pb.LeasesRequest) (*pb.LeasesReply, error) {
+	if req == nil {
+		return nil, ErrRequestData
+	}
+	if dmm.activeLeases == "" {
+		return nil, ErrNoActiveLeases
+	}
+	ls, err := dnsleases.ParseFile(dmm.activeLeases)
+	if err != nil {
+		return nil, err
+	}
+	leases := ls.Leases()
+	if !req.Expired {
+		leases = ls.Active(time.Now())
+	}
+
+	dmm.lock.RLock()
+	defer dmm.lock.RUnlock()
+
+	ret := pb.LeasesReply{
+		ServerDuid: ls.ServerDUID,
+	}
+	leased := make(map[string]bool)
+	for _, l := range leases {
+		entry := pb.LeaseEntry{
+			Lease: toPbLease(l),
+		}
+		if b, ok := dmm.bindingOf(l); ok {
+			entry.Static = dmm.staticAddress(ctx, b)
+			leased[b.Key()] = true
+		}
+		ret.Entries = append(ret.Entries, &entry)
+	}
+	for _, b := range dmm.addrMap.Bindings() {
+		if leased[b.Key()] {
+			continue
+		}
+		if addr := dmm.staticAddress(ctx, b); addr != nil {
+			ret.Entries = append(ret.Entries, &pb.LeaseEntry{Static: addr})
+		}
+	}
+	return &ret, nil
+}
+
+// findLease returns the active lease selected by the request
+func findLease(leases []dnsleases.Lease, key pb.Key, addr *pb.Address) (dnsleases.Lease, error) {
+	for _, l := range leases {
+		switch key {
+		case pb.Key_MACADDR:
+			if addr.Macaddr != "" {
+				hw, err := net.ParseMAC(addr.Macaddr)
+				if err != nil {
+					return dnsleases.Lease{}, dhcphosts.ErrBadHWAddrFormat
+				}
+				if bytes.Equal(l.HW, hw) {
+					return l, nil
+				}
+			} else if addr.Clientid != "" && strings.EqualFold(l.ClientID, addr.Clientid) {
+				return l, nil
+			}
+		case pb.Key_IPADDR:
+			want := addr.Ipaddr
+			if want == "" {
+				want = addr.Ip6Addr
+			}
+			ip := net.ParseIP(want)
+			if ip == nil {
+				return dnsleases.Lease{}, dhcphosts.ErrBadIPFormat
+			}
+			if l.IP.Equal(ip) {
+				return l, nil
+			}
+		case pb.Key_HOSTNAME:
+			if l.Hostname != "" && strings.EqualFold(l.Hostname, addr.Hostname) {
+				return l, nil
+			}
+		default:
+			return dnsleases.Lease{}, ErrInvalidParam
+		}
+	}
+	return dnsleases.Lease{}, ErrLeaseNotFound
+}
+
+// PinLease turns an active lease into a static entry, as a RequestAddress for the same client would.
+// See pb.PinRequest.
+func (dmm *DNSMasqMgr) PinLease(ctx context.Context, req *pb.PinRequest) (*pb.AddressReply, error) {
+	if dmm.readOnly {
+		return nil, ErrReadOnly
+	}
+	if req == nil || req.Addr == nil {
+		return nil, ErrRequestData
+	}
+	if dmm.activeLeases == "" {
+		return nil, ErrNoActiveLeases
+	}
+	ls, err := dnsleases.ParseFile(dmm.activeLeases)
+	if err != nil {
+		return nil, err
+	}
+	l, err := findLease(ls.Active(time.Now()), req.Key, req.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := pb.Address{
+		Hostname: req.Hostname,
+	}
+	if addr.Hostname == "" {
+		addr.Hostname = l.Hostname
+	}
+	if addr.Hostname == "" {
+		return nil, ErrNoHostname
+	}
+	if l.HW != nil && l.HWType == 0 {
+		addr.Macaddr = l.HW.String()
+	} else if l.ClientID != "" {
+		addr.Clientid = l.ClientID
+	} else {
+		return nil, ErrInvalidParam
+	}
+	// the address is kept only if it is in a pool, where it gets reserved; it decides the pool, unless given
+	probe := pb.Address{}
+	setIP(&probe, l.IP)
+	dmm.lock.RLock()
+	owner := dmm.poolOf(&probe)
+	dmm.lock.RUnlock()
+	poolName := req.Pool
+	keep := owner != nil && (poolName == "" || poolName == owner.Name)
+	if keep {
+		setIP(&addr, l.IP)
+		poolName = owner.Name
+	}
+	log.Printf("server: pinning lease [%s] as %s (keep address=%v)", l, addr.Hostname, keep)
+	return dmm.requestAddress(ctx, "PinLease", &pb.AddressRequest{
+		Addr: &addr,
+		Pool: poolName,
+	})
+}
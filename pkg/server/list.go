@@ -0,0 +1,261 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+)
+
+const (
+	defaultPageSize int32 = 100
+	maxPageSize     int32 = 1000
+)
+
+// entry is an address as seen joining the hosts and the leases files on the IP address
+type entry struct {
+	addr  *pb.Address
+	match pb.Match
+}
+
+// ip returns the IPv4 address of the entry, or the IPv6 address if it has none
+func (e entry) ip() net.IP {
+	if e.addr.Ipaddr == "" {
+		return net.ParseIP(e.addr.Ip6Addr)
+	}
+	return net.ParseIP(e.addr.Ipaddr)
+}
+
+func (e entry) ips() []net.IP {
+	var ret []net.IP
+	for _, s := range []string{e.addr.Ipaddr, e.addr.Ip6Addr} {
+		if ip := net.ParseIP(s); ip != nil {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
+// sortKey orders the entries by IP address first, then by hostname and MAC address
+func (e entry) sortKey() string {
+	ip := e.ip()
+	ipKey := strings.Repeat("f", 2*net.IPv6len)
+	if ip != nil {
+		ipKey = hex.EncodeToString(ip.To16())
+	}
+	return fmt.Sprintf("%s|%s|%s", ipKey, e.addr.Hostname, e.addr.Macaddr)
+}
+
+func (e entry) reply() *pb.AddressReply {
+	key := pb.Key_HOSTNAME
+	if e.addr.Hostname == "" {
+		key = pb.Key_MACADDR
+	}
+	return &pb.AddressReply{
+		Key:   key,
+		Match: e.match,
+		Addr:  e.addr,
+	}
+}
+
+// entries returns all the known addresses, sorted. The hosts entries of both families
+// sharing the canonical hostname make one address. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) entries() []entry {
+	var ret []entry
+	byName := make(map[string]int)
+	bound := make(map[string]bool)
+	for _, h := range dmm.nameMap.Hosts() {
+		ix, ok := byName[h.CanonicalHostname]
+		if !ok {
+			ix = len(ret)
+			byName[h.CanonicalHostname] = ix
+			ret = append(ret, entry{
+				addr: &pb.Address{
+					Hostname: h.CanonicalHostname,
+					Aliases:  h.Aliases,
+				},
+				match: pb.Match_PARTIAL,
+			})
+		}
+		e := &ret[ix]
+		setIP(e.addr, h.Address)
+		if e.match == pb.Match_FULL {
+			continue
+		}
+		if b, err := dmm.addrMap.GetByIP(h.Address.String()); err == nil {
+			setBinding(e.addr, b)
+			e.match = pb.Match_FULL
+			bound[b.Key()] = true
+		}
+	}
+	for _, b := range dmm.addrMap.Bindings() {
+		if bound[b.Key()] {
+			continue
+		}
+		e := entry{
+			addr:  &pb.Address{},
+			match: pb.Match_PARTIAL,
+		}
+		setBinding(e.addr, b)
+		for _, ip := range b.IPs() {
+			setIP(e.addr, ip)
+		}
+		ret = append(ret, e)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].sortKey() < ret[j].sortKey()
+	})
+	return ret
+}
+
+// listFilter selects the entries matching all the criteria of a ListRequest
+type listFilter struct {
+	hostnameGlob  string
+	macaddrPrefix string
+	subnet        *net.IPNet
+	match         pb.Match
+}
+
+func newListFilter(req *pb.ListRequest) (*listFilter, error) {
+	lf := listFilter{
+		hostnameGlob:  strings.ToLower(req.HostnameGlob),
+		macaddrPrefix: strings.Replace(strings.ToLower(req.MacaddrPrefix), "-", ":", -1),
+		match:         req.Match,
+	}
+	if lf.hostnameGlob != "" {
+		if _, err := path.Match(lf.hostnameGlob, ""); err != nil {
+			return nil, ErrInvalidParam
+		}
+	}
+	if req.Subnet != "" {
+		_, subnet, err := net.ParseCIDR(req.Subnet)
+		if err != nil {
+			return nil, ErrInvalidParam
+		}
+		lf.subnet = subnet
+	}
+	return &lf, nil
+}
+
+// matchName returns true if either the hostname or any alias matches the glob, ignoring the case as DNS does
+func (lf *listFilter) matchName(addr *pb.Address) bool {
+	for _, name := range append([]string{addr.Hostname}, addr.Aliases...) {
+		if ok, _ := path.Match(lf.hostnameGlob, strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inSubnet returns true if any address of the entry is in the subnet
+func (lf *listFilter) inSubnet(e entry) bool {
+	for _, ip := range e.ips() {
+		if lf.subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (lf *listFilter) accept(e entry) bool {
+	if lf.hostnameGlob != "" && !lf.matchName(e.addr) {
+		return false
+	}
+	if lf.macaddrPrefix != "" && !strings.HasPrefix(e.addr.Macaddr, lf.macaddrPrefix) {
+		return false
+	}
+	if lf.subnet != nil && !lf.inSubnet(e) {
+		return false
+	}
+	if lf.match != pb.Match_NONE && lf.match != e.match {
+		return false
+	}
+	return true
+}
+
+// page tokens are opaque to clients: they encode the sort key of the last entry returned,
+// so pagination is not disturbed by entries added or removed between the calls.
+func encodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidParam
+	}
+	return string(key), nil
+}
+
+func (dmm *DNSMasqMgr) ListAddresses(ctx context.Context, req *pb.ListRequest) (*pb.ListReply, error) {
+	if req == nil {
+		return nil, ErrRequestData
+	}
+	lf, err := newListFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	var after string
+	if req.PageToken != "" {
+		after, err = decodePageToken(req.PageToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dmm.lock.RLock()
+	defer dmm.lock.RUnlock()
+
+	reply := pb.ListReply{}
+	var lastKey string
+	for _, e := range dmm.entries() {
+		key := e.sortKey()
+		if after != "" && key <= after {
+			continue
+		}
+		if !lf.accept(e) {
+			continue
+		}
+		if int32(len(reply.Addrs)) == pageSize {
+			reply.NextPageToken = encodePageToken(lastKey)
+			break
+		}
+		reply.Addrs = append(reply.Addrs, e.reply())
+		lastKey = key
+	}
+	return &reply, nil
+}
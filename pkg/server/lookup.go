@@ -25,8 +25,11 @@ package server
 
 import (
 	"context"
+	"net"
 
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
 	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
 )
 
 func (dmm *DNSMasqMgr) LookupAddress(ctx context.Context, req *pb.AddressRequest) (*pb.AddressReply, error) {
@@ -35,45 +38,114 @@ func (dmm *DNSMasqMgr) LookupAddress(ctx context.Context, req *pb.AddressRequest
 	}
 	dmm.lock.RLock()
 	defer dmm.lock.RUnlock()
+	return dmm.lookupAddress(ctx, req)
+}
+
+// lookupAddress must be called with dmm.lock held
+func (dmm *DNSMasqMgr) lookupAddress(ctx context.Context, req *pb.AddressRequest) (*pb.AddressReply, error) {
 	switch req.Key {
 	case pb.Key_HOSTNAME:
 		return dmm.lookupAddressByHostname(ctx, req.Addr.Hostname)
 	case pb.Key_MACADDR:
-		return dmm.lookupAddressByMacaddr(ctx, req.Addr.Macaddr)
+		// DHCPv6 clients may be known by client identifier only
+		return dmm.lookupAddressByMacaddr(ctx, bindingKey(req.Addr))
 	case pb.Key_IPADDR:
+		if req.Addr.Ipaddr == "" {
+			return dmm.lookupAddressByIpaddr(ctx, req.Addr.Ip6Addr)
+		}
 		return dmm.lookupAddressByIpaddr(ctx, req.Addr.Ipaddr)
+	case pb.Key_ALIAS:
+		if len(req.Addr.Aliases) == 0 {
+			return &pb.AddressReply{Match: pb.Match_NONE}, ErrMissingKey
+		}
+		return dmm.lookupAddressByAlias(ctx, req.Addr.Aliases[0])
 	}
 	return nil, ErrInvalidParam
 }
 
-func (dmm *DNSMasqMgr) lookupAddressByHostname(ctx context.Context, hostname string) (*pb.AddressReply, error) {
-	reply := pb.AddressReply{
-		Match: pb.Match_NONE,
+// bindingKey returns the key of the binding of the address: the hardware address if any,
+// otherwise the client identifier. See dhcphosts.Binding.Key.
+func bindingKey(addr *pb.Address) string {
+	if addr.Macaddr == "" && addr.Clientid != "" {
+		return "id:" + addr.Clientid
 	}
-	if hostname == "" {
-		return &reply, ErrMissingKey
+	return addr.Macaddr
+}
+
+// setIP fills the field of addr matching the family of ip
+func setIP(addr *pb.Address, ip net.IP) {
+	if ip.To4() != nil {
+		addr.Ipaddr = ip.String()
+	} else {
+		addr.Ip6Addr = ip.String()
 	}
+}
 
+func setBinding(addr *pb.Address, binding dhcphosts.Binding) {
+	addr.Macaddr = binding.HW.String()
+	addr.Clientid = binding.ClientID
+	addr.Tags = binding.SetTags
+}
+
+func (dmm *DNSMasqMgr) lookupAddressByHostname(ctx context.Context, hostname string) (*pb.AddressReply, error) {
+	if hostname == "" {
+		return &pb.AddressReply{Match: pb.Match_NONE}, ErrMissingKey
+	}
 	host, err := dmm.nameMap.GetByHostname(hostname)
+	// short names are looked up in the domains of the pools too
+	for _, p := range dmm.pools {
+		if err == nil {
+			break
+		}
+		if fqdn, _ := p.normalize(hostname); fqdn != hostname {
+			host, err = dmm.nameMap.GetByHostname(fqdn)
+		}
+	}
 	if err != nil {
-		return &reply, err
+		return &pb.AddressReply{Match: pb.Match_NONE}, err
 	}
-	reply = pb.AddressReply{
+	return dmm.hostReply(host), nil
+}
+
+func (dmm *DNSMasqMgr) lookupAddressByAlias(ctx context.Context, alias string) (*pb.AddressReply, error) {
+	if alias == "" {
+		return &pb.AddressReply{Match: pb.Match_NONE}, ErrMissingKey
+	}
+	host, err := dmm.nameMap.GetByAlias(alias)
+	if err != nil {
+		return &pb.AddressReply{Match: pb.Match_NONE}, err
+	}
+	return dmm.hostReply(host), nil
+}
+
+// hostReply completes the host entry with the addresses of both families
+// and with the binding using any of them, if any
+func (dmm *DNSMasqMgr) hostReply(host etchosts.Host) *pb.AddressReply {
+	reply := pb.AddressReply{
 		Addr: &pb.Address{
 			Hostname: host.CanonicalHostname,
-			Ipaddr:   host.Address.String(),
+			Aliases:  host.Aliases,
 		},
 		Match: pb.Match_PARTIAL,
 	}
-	binding, err := dmm.addrMap.GetByIP(reply.Addr.Ipaddr)
-	if err != nil {
-		return &reply, nil
-	}
-	reply.Addr.Macaddr = binding.HW.String()
-	reply.Match = pb.Match_FULL
-	return &reply, nil
+	for _, h := range dmm.nameMap.GetAllByHostname(host.CanonicalHostname) {
+		setIP(reply.Addr, h.Address)
+	}
+	for _, ip := range []string{reply.Addr.Ipaddr, reply.Addr.Ip6Addr} {
+		if ip == "" {
+			continue
+		}
+		binding, err := dmm.addrMap.GetByIP(ip)
+		if err == nil {
+			setBinding(reply.Addr, binding)
+			reply.Match = pb.Match_FULL
+			break
+		}
+	}
+	return &reply
 }
 
+// lookupAddressByMacaddr finds the entry by binding key: a hardware address or "id:<client_id>"
 func (dmm *DNSMasqMgr) lookupAddressByMacaddr(ctx context.Context, macaddr string) (*pb.AddressReply, error) {
 	reply := pb.AddressReply{
 		Match: pb.Match_NONE,
@@ -82,51 +154,55 @@ func (dmm *DNSMasqMgr) lookupAddressByMacaddr(ctx context.Context, macaddr strin
 		return &reply, ErrMissingKey
 	}
 
-	binding, err := dmm.addrMap.GetByHWAddr(macaddr)
+	var binding dhcphosts.Binding
+	var err error
+	if _, perr := net.ParseMAC(macaddr); perr == nil {
+		binding, err = dmm.addrMap.GetByHWAddr(macaddr)
+	} else {
+		binding, err = dmm.addrMap.GetByKey(macaddr)
+	}
 	if err != nil {
 		return &reply, err
 	}
 	reply = pb.AddressReply{
-		Addr: &pb.Address{
-			Macaddr: binding.HW.String(),
-			Ipaddr:  binding.IP.String(),
-		},
+		Addr:  &pb.Address{},
 		Match: pb.Match_PARTIAL,
 	}
+	setBinding(reply.Addr, binding)
+	for _, ip := range binding.IPs() {
+		setIP(reply.Addr, ip)
+	}
 
-	host, err := dmm.nameMap.GetByAddress(reply.Addr.Ipaddr)
+	err = etchosts.ErrNotFoundHostname
+	var host etchosts.Host
+	for _, ip := range binding.IPs() {
+		host, err = dmm.nameMap.GetByAddress(ip.String())
+		if err == nil {
+			break
+		}
+	}
+	if err != nil && binding.Hostname != "" {
+		host, err = dmm.nameMap.GetByHostname(binding.Hostname)
+	}
 	if err != nil {
 		return &reply, nil
 	}
 	reply.Addr.Hostname = host.CanonicalHostname
+	reply.Addr.Aliases = host.Aliases
+	for _, h := range dmm.nameMap.GetAllByHostname(host.CanonicalHostname) {
+		setIP(reply.Addr, h.Address)
+	}
 	reply.Match = pb.Match_FULL
 	return &reply, nil
 }
 
 func (dmm *DNSMasqMgr) lookupAddressByIpaddr(ctx context.Context, ipaddr string) (*pb.AddressReply, error) {
-	reply := pb.AddressReply{
-		Match: pb.Match_NONE,
-	}
 	if ipaddr == "" {
-		return &reply, ErrMissingKey
+		return &pb.AddressReply{Match: pb.Match_NONE}, ErrMissingKey
 	}
-
 	host, err := dmm.nameMap.GetByAddress(ipaddr)
 	if err != nil {
-		return &reply, err
+		return &pb.AddressReply{Match: pb.Match_NONE}, err
 	}
-	reply = pb.AddressReply{
-		Addr: &pb.Address{
-			Hostname: host.CanonicalHostname,
-			Ipaddr:   host.Address.String(),
-		},
-		Match: pb.Match_PARTIAL,
-	}
-	binding, err := dmm.addrMap.GetByIP(reply.Addr.Ipaddr)
-	if err != nil {
-		return &reply, nil
-	}
-	reply.Addr.Macaddr = binding.HW.String()
-	reply.Match = pb.Match_FULL
-	return &reply, nil
+	return dmm.hostReply(host), nil
 }
@@ -0,0 +1,293 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// the package policy decides which clients may change which entries of the managed files
+package policy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// AnyIdentity in a rule matches all the clients, the anonymous ones too
+	AnyIdentity string = "*"
+	// Anonymous is the identity of the clients with neither a token nor a client certificate
+	Anonymous string = ""
+)
+
+var (
+	ErrDenied    error = errors.New("Operation not allowed")
+	ErrBadToken  error = errors.New("Bad bearer token")
+	ErrBadPolicy error = errors.New("Malformed policy")
+)
+
+// Methods are the RPCs changing the managed files, which are the ones the policy applies to.
+// Check changes them only when asked to fix the problems it finds.
+var Methods = []string{
+	"RequestAddress",
+	"DeleteAddress",
+	"UpdateAddress",
+	"AddAlias",
+	"RemoveAlias",
+	"PinLease",
+	"Check",
+}
+
+// IsMutating returns true if the method, either the name or the full gRPC name, changes the managed files
+func IsMutating(method string) bool {
+	method = path.Base(method)
+	for _, m := range Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+type Token struct {
+	Identity string `json:"identity"`
+	// Token is sent by the clients as "authorization: Bearer <token>" metadata
+	Token string `json:"token"`
+}
+
+type Rule struct {
+	// Identity is the common name of a client certificate, the identity of a token or AnyIdentity
+	Identity string `json:"identity"`
+	// Methods are the RPCs allowed, among Methods. All of them if empty.
+	Methods []string `json:"methods"`
+	// Pools are the pools of the entries the rule allows to change. Any pool, or none, if empty.
+	Pools []string `json:"pools"`
+	// Hostnames are the patterns (see path.Match, e.g. "*.ci.lan") the names and the aliases
+	// of the entries must match. Any name if empty.
+	Hostnames []string `json:"hostnames"`
+}
+
+// Policy allows the changes matching any of its rules, and denies all the others.
+type Policy struct {
+	Tokens []Token `json:"tokens"`
+	Rules  []Rule  `json:"rules"`
+}
+
+// Target is an entry changed by a request, as it is before or after the change
+type Target struct {
+	Pool      string
+	Hostnames []string
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("%s in pool '%s'", strings.Join(t.Hostnames, ","), t.Pool)
+}
+
+func Parse(r io.Reader) (*Policy, error) {
+	p := Policy{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPolicy, err)
+	}
+	err = p.Check()
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseFile(path string) (*Policy, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return Parse(fh)
+}
+
+func (p *Policy) Check() error {
+	seen := make(map[string]bool)
+	for _, t := range p.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("%w: empty token for '%s'", ErrBadPolicy, t.Identity)
+		}
+		if seen[t.Token] {
+			return fmt.Errorf("%w: duplicated token for '%s'", ErrBadPolicy, t.Identity)
+		}
+		seen[t.Token] = true
+	}
+	for _, r := range p.Rules {
+		for _, m := range r.Methods {
+			if !IsMutating(m) {
+				return fmt.Errorf("%w: unknown method: %s", ErrBadPolicy, m)
+			}
+		}
+		for _, h := range r.Hostnames {
+			if _, err := path.Match(h, ""); err != nil {
+				return fmt.Errorf("%w: bad hostname pattern: %s", ErrBadPolicy, h)
+			}
+		}
+	}
+	return nil
+}
+
+// Identity tells who is calling: the identity of the bearer token if any, otherwise
+// the common name of the verified client certificate, otherwise Anonymous.
+// The tokens sent over connections without TLS are refused: they could have been sniffed.
+func (p *Policy) Identity(ctx context.Context) (string, error) {
+	var info *credentials.TLSInfo
+	if pr, ok := peer.FromContext(ctx); ok {
+		if ti, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			info = &ti
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("authorization"); len(vals) > 0 {
+			fields := strings.Fields(vals[0])
+			if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+				return Anonymous, fmt.Errorf("%w: malformed authorization metadata", ErrBadToken)
+			}
+			if info == nil {
+				return Anonymous, fmt.Errorf("%w: sent without TLS", ErrBadToken)
+			}
+			return p.tokenIdentity(fields[1])
+		}
+	}
+	if info != nil {
+		chains := info.State.VerifiedChains
+		if len(chains) > 0 && len(chains[0]) > 0 {
+			return chains[0][0].Subject.CommonName, nil
+		}
+	}
+	return Anonymous, nil
+}
+
+func (p *Policy) tokenIdentity(token string) (string, error) {
+	for _, t := range p.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t.Identity, nil
+		}
+	}
+	return Anonymous, ErrBadToken
+}
+
+// AllowMethod returns nil if any rule lets the identity call the method, on some entries at least
+func (p *Policy) AllowMethod(identity, method string) error {
+	for _, r := range p.Rules {
+		if r.matchCaller(identity, method) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s may not call %s", ErrDenied, Name(identity), method)
+}
+
+// Allow returns nil if the identity may call the method on all the targets
+func (p *Policy) Allow(identity, method string, targets ...Target) error {
+	for _, t := range targets {
+		allowed := false
+		for _, r := range p.Rules {
+			if r.matchCaller(identity, method) && r.matchTarget(t) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s may not call %s on %s", ErrDenied, Name(identity), method, t)
+		}
+	}
+	return nil
+}
+
+// Name returns the identity as shown in the logs and in the journal
+func Name(identity string) string {
+	if identity == Anonymous {
+		return "anonymous"
+	}
+	return identity
+}
+
+func (r Rule) matchCaller(identity, method string) bool {
+	if r.Identity != AnyIdentity && (identity == Anonymous || r.Identity != identity) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchTarget(t Target) bool {
+	if len(r.Pools) > 0 {
+		found := false
+		for _, p := range r.Pools {
+			if p == t.Pool {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Hostnames) == 0 {
+		return true
+	}
+	for _, name := range t.Hostnames {
+		found := false
+		for _, h := range r.Hostnames {
+			if ok, _ := path.Match(h, name); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type identityKey struct{}
+
+// NewContext returns a context carrying the identity of the caller
+func NewContext(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the caller stored by NewContext, Anonymous if none
+func FromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
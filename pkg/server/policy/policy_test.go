@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const testPolicy string = `{
+	"tokens": [
+		{"identity": "ci", "token": "s3cret"}
+	],
+	"rules": [
+		{"identity": "admin"},
+		{"identity": "ci", "methods": ["RequestAddress", "DeleteAddress"], "pools": ["default", "ci"], "hostnames": ["*.ci.lan"]},
+		{"identity": "*", "methods": ["AddAlias"], "hostnames": ["*.guest.lan"]}
+	]
+}`
+
+func TestParse(t *testing.T) {
+	_, err := Parse(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error parsing the policy: %v", err)
+	}
+	bad := []string{
+		`{"rules": [{"identity": "ci", "methods": ["LookupAddress"]}]}`,
+		`{"rules": [{"identity": "ci", "hostnames": ["[a-"]}]}`,
+		`{"tokens": [{"identity": "ci", "token": ""}]}`,
+		`{"tokens": [{"identity": "ci", "token": "x"}, {"identity": "qa", "token": "x"}]}`,
+		`{"rulez": []}`,
+	}
+	for _, s := range bad {
+		_, err = Parse(strings.NewReader(s))
+		if !errors.Is(err, ErrBadPolicy) {
+			t.Errorf("%s: unexpected error: %v", s, err)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p, _ := Parse(strings.NewReader(testPolicy))
+	ci := Target{Pool: "ci", Hostnames: []string{"build1.ci.lan", "b1.ci.lan"}}
+	prod := Target{Pool: "default", Hostnames: []string{"db.prod.lan"}}
+	mixed := Target{Pool: "ci", Hostnames: []string{"build1.ci.lan", "db.prod.lan"}}
+	iot := Target{Pool: "iot", Hostnames: []string{"cam.ci.lan"}}
+	guest := Target{Pool: "default", Hostnames: []string{"phone.guest.lan"}}
+
+	cases := []struct {
+		identity string
+		method   string
+		targets  []Target
+		allowed  bool
+	}{
+		{"admin", "UpdateAddress", []Target{prod, ci}, true},
+		{"ci", "RequestAddress", []Target{ci}, true},
+		{"ci", "DeleteAddress", []Target{ci}, true},
+		{"ci", "UpdateAddress", []Target{ci}, false},
+		{"ci", "RequestAddress", []Target{prod}, false},
+		{"ci", "RequestAddress", []Target{mixed}, false},
+		{"ci", "RequestAddress", []Target{iot}, false},
+		{"ci", "AddAlias", []Target{guest}, true},
+		{Anonymous, "AddAlias", []Target{guest}, true},
+		{Anonymous, "AddAlias", []Target{ci}, false},
+		{Anonymous, "RequestAddress", []Target{guest}, false},
+	}
+	for _, c := range cases {
+		err := p.Allow(c.identity, c.method, c.targets...)
+		if c.allowed && err != nil {
+			t.Errorf("%s %s %v: unexpected error: %v", Name(c.identity), c.method, c.targets, err)
+		}
+		if !c.allowed && !errors.Is(err, ErrDenied) {
+			t.Errorf("%s %s %v: unexpectedly allowed", Name(c.identity), c.method, c.targets)
+		}
+	}
+
+	if err := p.AllowMethod("ci", "UpdateAddress"); !errors.Is(err, ErrDenied) {
+		t.Errorf("unexpected error checking the method: %v", err)
+	}
+	if err := p.AllowMethod("ci", "DeleteAddress"); err != nil {
+		t.Errorf("unexpected error checking the method: %v", err)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	p, _ := Parse(strings.NewReader(testPolicy))
+	cases := []struct {
+		auth     string
+		identity string
+		err      error
+	}{
+		{"", Anonymous, nil},
+		{"Bearer s3cret", "ci", nil},
+		{"bearer s3cret", "ci", nil},
+		{"Bearer wrong", Anonymous, ErrBadToken},
+		{"Basic s3cret", Anonymous, ErrBadToken},
+	}
+	tlsPeer := &peer.Peer{AuthInfo: credentials.TLSInfo{}}
+	for _, c := range cases {
+		ctx := peer.NewContext(context.Background(), tlsPeer)
+		if c.auth != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", c.auth))
+		}
+		identity, err := p.Identity(ctx)
+		if identity != c.identity || !errors.Is(err, c.err) {
+			t.Errorf("%q: unexpected identity %q error %v", c.auth, identity, err)
+		}
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer s3cret"))
+	identity, err := p.Identity(ctx)
+	if identity != Anonymous || !errors.Is(err, ErrBadToken) {
+		t.Errorf("token accepted without TLS: identity %q error %v", identity, err)
+	}
+
+	ctx = NewContext(context.Background(), "ci")
+	if FromContext(ctx) != "ci" || FromContext(context.Background()) != Anonymous {
+		t.Errorf("identity not carried by the context")
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/apcera/util/iprange"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+)
+
+var (
+	ErrUnknownPool error = errors.New("Unknown pool")
+	ErrOutOfSubnet error = errors.New("Address out of the subnet of the pool")
+)
+
+// pool is a configured pool with its allocators
+type pool struct {
+	*config.ParsedPool
+	alloc  *iprange.IPRangeAllocator
+	alloc6 *iprange.IPRangeAllocator
+}
+
+func newPool(pp *config.ParsedPool) *pool {
+	p := pool{
+		ParsedPool: pp,
+		alloc:      iprange.NewAllocator(pp.IPs),
+	}
+	if pp.IP6s != nil {
+		p.alloc6 = iprange.NewAllocator(pp.IP6s)
+	}
+	for _, x := range pp.Excluded {
+		for _, alloc := range p.allocators() {
+			if alloc.IPRange().Overlaps(x) {
+				alloc.Subtract(x)
+			}
+		}
+	}
+	return &p
+}
+
+func (p *pool) allocators() []*iprange.IPRangeAllocator {
+	if p.alloc6 == nil {
+		return []*iprange.IPRangeAllocator{p.alloc}
+	}
+	return []*iprange.IPRangeAllocator{p.alloc, p.alloc6}
+}
+
+// allocFor returns the allocator of the pool the address belongs to, nil if none.
+// The allocators don't check the range on release, so they must never see foreign addresses.
+func (p *pool) allocFor(ip net.IP) *iprange.IPRangeAllocator {
+	for _, alloc := range p.allocators() {
+		if alloc.IPRange().Contains(ip.To16()) {
+			return alloc
+		}
+	}
+	return nil
+}
+
+// excluded returns true if the address must never be handed out
+func (p *pool) excluded(ip net.IP) bool {
+	for _, x := range p.Excluded {
+		if x.Contains(ip.To16()) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandIP6 completes an IPv6 interface identifier (e.g. ::56) with the prefix of the IPv6 range,
+// like dnsmasq does with the dhcp-range. Any other address is returned unchanged.
+func (p *pool) expandIP6(ip net.IP) net.IP {
+	if p.IP6s == nil || ip.To4() != nil || !ip.Mask(net.CIDRMask(64, 128)).IsUnspecified() {
+		return ip
+	}
+	ret := make(net.IP, net.IPv6len)
+	copy(ret, p.IP6s.Start.To16()[:8])
+	copy(ret[8:], ip.To16()[8:])
+	return ret
+}
+
+// normalize lowercases the hostname, drops its trailing dot and completes it with the domain of the pool,
+// unless it already has one. It also returns the short name, if the hostname is in the domain of the pool.
+func (p *pool) normalize(hostname string) (string, string) {
+	return normalizeName(hostname, p.Domain)
+}
+
+func normalizeName(hostname, domain string) (string, string) {
+	name := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	if domain == "" {
+		return name, ""
+	}
+	if !strings.Contains(name, ".") {
+		return name + "." + domain, name
+	}
+	short := strings.TrimSuffix(name, "."+domain)
+	if short == name || strings.Contains(short, ".") {
+		return name, ""
+	}
+	return name, short
+}
+
+func (p *pool) matchMAC(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range p.MACPrefixes {
+		prefix = strings.Replace(strings.ToLower(prefix), "-", ":", -1)
+		if strings.HasPrefix(hw.String(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pool) matchTags(tags []string) bool {
+	for _, tag := range tags {
+		for _, x := range p.Tags {
+			if tag == x {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tags returns the requested tags followed by the ones of the pool
+func (p *pool) tags(requested []string) []string {
+	ret := append([]string{}, requested...)
+	for _, tag := range p.Tags {
+		if !hasTag(ret, tag) {
+			ret = append(ret, tag)
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, x := range tags {
+		if x == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pool) status() *pb.Pool {
+	ret := pb.Pool{
+		Name:      p.Name,
+		Range:     p.Range,
+		Total:     p.alloc.Size(),
+		Remaining: p.alloc.Remaining(),
+		Subnet:    p.Subnet,
+		Domain:    p.Domain,
+	}
+	if p.alloc6 != nil {
+		ret.Range6 = p.Range6
+		ret.Total6 = p.alloc6.Size()
+		ret.Remaining6 = p.alloc6.Remaining()
+	}
+	return &ret
+}
+
+// selectPool finds the pool for a new entry. See pb.AddressRequest.
+func (dmm *DNSMasqMgr) selectPool(req *pb.AddressRequest) (*pool, error) {
+	if req.Pool != "" {
+		for _, p := range dmm.pools {
+			if p.Name == req.Pool {
+				return p, nil
+			}
+		}
+		return nil, ErrUnknownPool
+	}
+	for _, p := range dmm.pools {
+		if p.matchMAC(req.Addr.Macaddr) {
+			return p, nil
+		}
+	}
+	for _, p := range dmm.pools {
+		if p.matchTags(req.Addr.Tags) {
+			return p, nil
+		}
+	}
+	if ip := net.ParseIP(req.Addr.Ipaddr); ip != nil {
+		for _, p := range dmm.pools {
+			if p.allocFor(ip) != nil || (p.Net != nil && p.Net.Contains(ip)) {
+				return p, nil
+			}
+		}
+	}
+	return dmm.pools[0], nil
+}
+
+// poolOf returns the pool any of the addresses belongs to, nil if none
+func (dmm *DNSMasqMgr) poolOf(addr *pb.Address) *pool {
+	for _, s := range []string{addr.Ipaddr, addr.Ip6Addr} {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		for _, p := range dmm.pools {
+			if p.allocFor(ip) != nil {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// fits returns true if an entry of the pool may take the address: in the subnet of the pool,
+// and out of the ranges of the other pools
+func (dmm *DNSMasqMgr) fits(p *pool, ip net.IP) bool {
+	if ip.To4() != nil && !p.Contains(ip) {
+		return false
+	}
+	for _, other := range dmm.pools {
+		if other != p && other.allocFor(ip) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// allocFor returns the allocator of the pool the address belongs to, nil if none
+func (dmm *DNSMasqMgr) allocFor(ip net.IP) *iprange.IPRangeAllocator {
+	if ip == nil {
+		return nil
+	}
+	for _, p := range dmm.pools {
+		if alloc := p.allocFor(ip); alloc != nil {
+			return alloc
+		}
+	}
+	return nil
+}
+
+// excluded returns true if the address is excluded from its pool
+func (dmm *DNSMasqMgr) excluded(ip net.IP) bool {
+	for _, p := range dmm.pools {
+		if p.excluded(ip) {
+			return true
+		}
+	}
+	return false
+}
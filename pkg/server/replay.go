@@ -0,0 +1,378 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+)
+
+var (
+	ErrNoJournal   error = errors.New("The journal is not configured")
+	ErrNoSnapshots error = errors.New("No snapshots available")
+	ErrFuturePoint error = errors.New("The requested point is past the end of the journal")
+	ErrPastPoint   error = errors.New("The requested point is older than the oldest snapshot")
+)
+
+func toJournalAddr(a *pb.Address) *journal.Addr {
+	return &journal.Addr{
+		Hostname: a.Hostname,
+		Macaddr:  a.Macaddr,
+		Ipaddr:   a.Ipaddr,
+		Aliases:  append([]string{}, a.Aliases...),
+		Ip6Addr:  a.Ip6Addr,
+		ClientID: a.Clientid,
+		Tags:     a.Tags,
+	}
+}
+
+func fromJournalAddr(a *journal.Addr) *pb.Address {
+	if a == nil {
+		return &pb.Address{}
+	}
+	return &pb.Address{
+		Hostname: a.Hostname,
+		Macaddr:  a.Macaddr,
+		Ipaddr:   a.Ipaddr,
+		Aliases:  a.Aliases,
+		Ip6Addr:  a.Ip6Addr,
+		Clientid: a.ClientID,
+		Tags:     a.Tags,
+	}
+}
+
+func parseState(hosts, leases string) (*etchosts.Conf, *dhcphosts.Conf, error) {
+	nameMap, err := etchosts.Parse(strings.NewReader(hosts))
+	if err != nil {
+		return nil, nil, err
+	}
+	addrMap, err := dhcphosts.Parse(strings.NewReader(leases))
+	if err != nil {
+		return nil, nil, err
+	}
+	return nameMap, addrMap, nil
+}
+
+// applyEntry redoes on the given state the change recorded in the journal entry
+func applyEntry(nameMap *etchosts.Conf, addrMap *dhcphosts.Conf, e journal.Entry) error {
+	addr := fromJournalAddr(e.Address)
+	switch e.Action {
+	case journal.ActionAdd:
+		b := dhcphosts.Binding{
+			ClientID: addr.Clientid,
+			SetTags:  addr.Tags,
+		}
+		for _, ip := range []string{addr.Ipaddr, addr.Ip6Addr} {
+			if ip == "" {
+				continue
+			}
+			_, err, _ := nameMap.Add(addr.Hostname, ip, addr.Aliases)
+			if err != nil {
+				return err
+			}
+			b.SetIP(net.ParseIP(ip))
+		}
+		if addr.Macaddr != "" {
+			hw, err := net.ParseMAC(addr.Macaddr)
+			if err != nil {
+				return err
+			}
+			b.HW = hw
+		}
+		_, err, _ := addrMap.AddBinding(b)
+		return err
+	case journal.ActionDelete:
+		addrMap.Remove(bindingKey(addr))
+		nameMap.Remove(addr.Hostname)
+		return nil
+	case journal.ActionUpdate:
+		return applyUpdate(nameMap, addrMap, fromJournalAddr(e.Previous), addr)
+	case journal.ActionDeny:
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", e.Action)
+}
+
+// replay rebuilds the content of the managed files at the given point, redoing
+// the changes recorded in the journal on top of the most recent snapshot before the point.
+// The journal holds only the changes, so without a snapshot the lines never journaled would be lost.
+func replay(snapDir string, entries []journal.Entry, p journal.Point) (*etchosts.Conf, *dhcphosts.Conf, error) {
+	if snapDir == "" {
+		return nil, nil, ErrNoSnapshots
+	}
+	snaps, err := journal.Snapshots(snapDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, nil, ErrNoSnapshots
+	}
+	base, err := journal.FindSnapshot(snapDir, p)
+	if err == journal.ErrNoSnapshot {
+		// the journal before the oldest snapshot may be compacted away
+		return nil, nil, ErrPastPoint
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	nameMap, addrMap, err := parseState(base.Hosts, base.Leases)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("server: replaying the journal from revision %d up to %v", base.Revision, p)
+
+	for _, e := range entries {
+		if e.Revision <= base.Revision || !p.Includes(e.Revision, e.Time) {
+			continue
+		}
+		resync := e.Action == journal.ActionExternal || e.Action == journal.ActionFix
+		if e.Action == journal.ActionRestore || resync {
+			snap, err := journal.LoadSnapshot(snapDir, e.Revision)
+			if err != nil {
+				return nil, nil, fmt.Errorf("revision %d: %v", e.Revision, err)
+			}
+			nameMap, addrMap, err = parseState(snap.Hosts, snap.Leases)
+		} else {
+			err = applyEntry(nameMap, addrMap, e)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("revision %d: %v", e.Revision, err)
+		}
+	}
+	return nameMap, addrMap, nil
+}
+
+// lastRevision returns the most recent revision recorded either in the journal or in the snapshots,
+// because the journal may have been compacted
+func lastRevision(snapDir string, entries []journal.Entry) (int64, error) {
+	rev := journal.LastRevision(entries)
+	if snapDir == "" {
+		return rev, nil
+	}
+	snap, err := journal.FindSnapshot(snapDir, journal.Latest)
+	if err == journal.ErrNoSnapshot {
+		return rev, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if snap.Revision > rev {
+		rev = snap.Revision
+	}
+	return rev, nil
+}
+
+func fileMode(path string) os.FileMode {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0644
+	}
+	return info.Mode().Perm()
+}
+
+// writeState replaces atomically the content of the managed files
+func writeState(conf *config.Config, nameMap *etchosts.Conf, addrMap *dhcphosts.Conf) error {
+	order, err := dhcphosts.ParseOrder(conf.LeasesOrder)
+	if err != nil {
+		return err
+	}
+	addrMap.SetOrder(order)
+	return commitFiles(commitMarkerPath(conf.HostsPath), []fileUpdate{
+		{
+			Path: conf.HostsPath,
+			data: []byte(nameMap.String()),
+			mode: fileMode(conf.HostsPath),
+		},
+		{
+			Path: conf.LeasesPath,
+			data: []byte(addrMap.String()),
+			mode: fileMode(conf.LeasesPath),
+		},
+	})
+}
+
+// Recover rebuilds the managed files replaying the whole journal on top of the latest snapshot.
+// dnsmasqmgrd must not be serving while the files are recovered.
+func Recover(conf *config.Config) error {
+	if conf.JournalPath == "" {
+		return ErrNoJournal
+	}
+	if conf.SnapshotDir == "" {
+		return ErrNoSnapshots
+	}
+	err := recoverCommit(commitMarkerPath(conf.HostsPath))
+	if err != nil {
+		return err
+	}
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil {
+		return err
+	}
+	nameMap, addrMap, err := replay(conf.SnapshotDir, entries, journal.Latest)
+	if err != nil {
+		return err
+	}
+	err = writeState(conf, nameMap, addrMap)
+	if err != nil {
+		return err
+	}
+	log.Printf("server: recovered the managed files at revision %d", journal.LastRevision(entries))
+	return nil
+}
+
+// Restore brings the managed files back as they were at the given point.
+// The restore is itself a change, recorded in the journal with a new revision.
+// dnsmasqmgrd must not be serving while the files are restored.
+func Restore(conf *config.Config, p journal.Point) (int64, error) {
+	if conf.JournalPath == "" {
+		return 0, ErrNoJournal
+	}
+	if conf.SnapshotDir == "" {
+		return 0, ErrNoSnapshots
+	}
+	err := recoverCommit(commitMarkerPath(conf.HostsPath))
+	if err != nil {
+		return 0, err
+	}
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil {
+		return 0, err
+	}
+	last, err := lastRevision(conf.SnapshotDir, entries)
+	if err != nil {
+		return 0, err
+	}
+	if p.Time.IsZero() && p != journal.Latest && p.Revision > last {
+		return 0, ErrFuturePoint
+	}
+	nameMap, addrMap, err := replay(conf.SnapshotDir, entries, p)
+	if err != nil {
+		return 0, err
+	}
+
+	// the snapshot must be in place before the journal entry which refers to it
+	now := time.Now().UTC()
+	rev := last + 1
+	err = journal.WriteSnapshot(conf.SnapshotDir, &journal.Snapshot{
+		Revision: rev,
+		Time:     now,
+		Hosts:    nameMap.String(),
+		Leases:   addrMap.String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = writeState(conf, nameMap, addrMap)
+	if err != nil {
+		return 0, err
+	}
+	w, err := journal.Open(conf.JournalPath)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	err = w.Append(&journal.Entry{
+		Revision: rev,
+		Time:     now,
+		Action:   journal.ActionRestore,
+		Until:    p.String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("server: restored the managed files at %v as revision %d", p, rev)
+	return rev, nil
+}
+
+// takeSnapshot stores the current content of the managed files, unless
+// a snapshot with the same revision already exists. If the content differs, the files were
+// edited outside dnsmasqmgrd: the snapshot is kept, because the journal replays through it,
+// and the edits are recorded as a new revision. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) takeSnapshot() error {
+	if dmm.snapshotDir == "" {
+		return nil
+	}
+	snap := journal.Snapshot{
+		Revision: dmm.events.Revision(),
+		Time:     time.Now().UTC(),
+		Hosts:    dmm.nameMap.String(),
+		Leases:   dmm.addrMap.String(),
+	}
+	prev, err := journal.LoadSnapshot(dmm.snapshotDir, snap.Revision)
+	if err == nil {
+		if prev.Hosts != snap.Hosts || prev.Leases != snap.Leases {
+			log.Printf("server: managed files changed outside dnsmasqmgrd after revision %d", snap.Revision)
+			dmm.recordResync(pb.Action_EXTERNAL, dmm.nameMap, dmm.addrMap)
+		}
+		return nil
+	}
+	err = journal.WriteSnapshot(dmm.snapshotDir, &snap)
+	if err != nil {
+		return err
+	}
+	log.Printf("server: took snapshot at revision %d", snap.Revision)
+	return nil
+}
+
+// snapshotLoop periodically rotates the journal if needed, takes a snapshot and compacts the journal
+func (dmm *DNSMasqMgr) snapshotLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dmm.snapStop:
+			close(dmm.snapDone)
+			return
+		case now := <-ticker.C:
+			err := dmm.checkpointJournal(now)
+			if err != nil {
+				log.Printf("server: periodic snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+func (dmm *DNSMasqMgr) checkpointJournal(now time.Time) error {
+	dmm.lock.Lock()
+	defer dmm.lock.Unlock()
+	// rotate first, so the segment just closed is covered by the new snapshot
+	err := dmm.journal.MaybeRotate(now)
+	if err != nil {
+		return err
+	}
+	err = dmm.takeSnapshot()
+	if err != nil {
+		return err
+	}
+	return journal.Compact(dmm.journalPath, dmm.snapshotDir, dmm.snapshotKeep)
+}
@@ -25,15 +25,18 @@ package server
 
 import (
 	"errors"
-	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"strings"
 	"sync"
 
-	"github.com/apcera/util/iprange"
-
 	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
 	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/filewatch"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
 )
 
 var (
@@ -41,47 +44,109 @@ var (
 	ErrRequestData  error = errors.New("Malformed request")
 	ErrInvalidParam error = errors.New("Invalid parameter in request")
 	ErrMissingKey   error = errors.New("Missing key for research")
+	ErrPoolExhaust  error = errors.New("No more addresses available in the pool")
+	ErrReadOnly     error = errors.New("Server is in read-only mode")
+	ErrMismatch     error = errors.New("Entry does not match the expected values")
+	ErrIncomplete   error = errors.New("Entry is incomplete")
+	ErrAddrInUse    error = errors.New("Address already in use")
+	ErrNoPools      error = errors.New("No pools configured")
 )
 
 type DNSMasqMgr struct {
-	readOnly   bool
-	hostsPath  string
-	hostsInfo  os.FileInfo
-	leasesPath string
-	leasesInfo os.FileInfo
-	flushChan  chan bool
-	doneChan   chan bool
-	lock       sync.RWMutex
-	nameMap    *etchosts.Conf
-	addrMap    *dhcphosts.Conf
-	ipAlloc    *iprange.IPRangeAllocator
-	journal    *os.File
-	changes    *log.Logger
+	readOnly     bool
+	hostsPath    string
+	hostsInfo    os.FileInfo
+	leasesPath   string
+	leasesInfo   os.FileInfo
+	storeChan    chan storeRequest
+	doneChan     chan bool
+	lock         sync.RWMutex
+	nameMap      *etchosts.Conf
+	addrMap      *dhcphosts.Conf
+	pools        []*pool
+	journal      *journal.Writer
+	journalPath  string
+	snapshotDir  string
+	snapshotKeep int
+	snapStop     chan struct{}
+	snapDone     chan struct{}
+	events       *eventHub
+	policy       *policy.Policy
+	denials      map[string]*denial
+	activeLeases string
+	editPolicy   string
+	editAlert    error
+	hostsSeen    seenFile
+	leasesSeen   seenFile
+	watcher      *filewatch.Watcher
+	watchDone    chan struct{}
 }
 
-func NewDNSMasqMgrReadOnly(iprangeStr, hostsPath, leasesPath string) (*DNSMasqMgr, error) {
-	dmm, err := NewDNSMasqMgr(iprangeStr, hostsPath, leasesPath, "")
-	if dmm != nil {
-		dmm.readOnly = true
+// NewDNSMasqMgrReadOnly creates a DNSMasqMgr which never changes the managed files:
+// the mutating requests are rejected, and neither the journal nor the storing loop are started.
+func NewDNSMasqMgrReadOnly(conf *config.Config) (*DNSMasqMgr, error) {
+	dmm, err := newDNSMasqMgr(conf, true)
+	if err != nil {
+		return nil, err
 	}
 	log.Printf("server: started in ReadOnly mode")
-	return dmm, err
+	return dmm, nil
+}
+
+func NewDNSMasqMgr(conf *config.Config) (*DNSMasqMgr, error) {
+	return newDNSMasqMgr(conf, false)
 }
 
-func NewDNSMasqMgr(iprangeStr, hostsPath, leasesPath, journalPath string) (*DNSMasqMgr, error) {
+func newDNSMasqMgr(conf *config.Config, readOnly bool) (*DNSMasqMgr, error) {
 	var err error
-	ips, err := iprange.ParseIPRange(iprangeStr)
+	hostsPath, leasesPath, journalPath := conf.HostsPath, conf.LeasesPath, conf.JournalPath
+	pools, err := conf.ParsePools()
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return nil, ErrNoPools
+	}
+	leasesOrder, err := dhcphosts.ParseOrder(conf.LeasesOrder)
 	if err != nil {
 		return nil, err
 	}
 
 	dmm := DNSMasqMgr{
-		ipAlloc:    iprange.NewAllocator(ips),
+		readOnly:   readOnly,
 		hostsPath:  hostsPath,
 		leasesPath: leasesPath,
-		flushChan:  make(chan bool),
+		storeChan:  make(chan storeRequest),
 		doneChan:   make(chan bool),
 	}
+	dmm.activeLeases = conf.ActiveLeasesPath
+	dmm.editPolicy = conf.ExternalEdits
+	if dmm.editPolicy == "" {
+		dmm.editPolicy = config.EditsMerge
+	}
+	for _, pp := range pools {
+		dmm.pools = append(dmm.pools, newPool(pp))
+	}
+	if conf.PolicyPath != "" {
+		dmm.policy, err = policy.ParseFile(conf.PolicyPath)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("server: enforcing the policy '%v': %d rules", conf.PolicyPath, len(dmm.policy.Rules))
+		if len(dmm.policy.Tokens) > 0 && (conf.CertFile == "" || conf.KeyFile == "") {
+			log.Printf("server: WARNING: TLS is not configured, all the bearer tokens will be refused")
+		}
+	}
+	marker := commitMarkerPath(hostsPath)
+	if !readOnly {
+		err = recoverCommit(marker)
+		if err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(marker); err == nil {
+		log.Printf("server: found interrupted commit marker %v, files may be inconsistent", marker)
+	}
+
 	dmm.hostsInfo, err = os.Lstat(hostsPath)
 	if err != nil {
 		return nil, err
@@ -91,50 +156,178 @@ func NewDNSMasqMgr(iprangeStr, hostsPath, leasesPath, journalPath string) (*DNSM
 		return nil, err
 	}
 
-	hostsFile, err := os.Open(hostsPath)
+	// what we read is the base the external edits are detected and merged against
+	dmm.hostsSeen, err = readSeen(hostsPath)
 	if err != nil {
 		return nil, err
 	}
-	defer hostsFile.Close()
-	leasesFile, err := os.Open(leasesPath)
+	dmm.leasesSeen, err = readSeen(leasesPath)
 	if err != nil {
 		return nil, err
 	}
-	defer leasesFile.Close()
 
-	dmm.nameMap, err = etchosts.Parse(hostsFile)
+	dmm.nameMap, err = etchosts.Parse(strings.NewReader(dmm.hostsSeen.content))
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("server: parsed %d entries from '%v'", dmm.nameMap.Len(), hostsPath)
 
-	dmm.addrMap, err = dhcphosts.Parse(leasesFile)
+	dmm.addrMap, err = dhcphosts.Parse(strings.NewReader(dmm.leasesSeen.content))
 	if err != nil {
 		return nil, err
 	}
+	dmm.addrMap.SetOrder(leasesOrder)
 	log.Printf("server: parsed %d entries from '%v'", dmm.addrMap.Len(), leasesPath)
 
+	dmm.reserveInUse()
+	for _, p := range dmm.pools {
+		log.Printf("server: pool %s: %s: %d/%d addresses available", p.Name, p.Range, p.alloc.Remaining(), p.alloc.Size())
+		if p.alloc6 != nil {
+			log.Printf("server: pool %s: %s: %d/%d addresses available", p.Name, p.Range6, p.alloc6.Remaining(), p.alloc6.Size())
+		}
+	}
+
+	// revisions continue from the ones already recorded
+	var lastRev int64
+	if journalPath != "" {
+		entries, err := journal.Read(journalPath)
+		if err != nil {
+			return nil, err
+		}
+		lastRev, err = lastRevision(conf.SnapshotDir, entries)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("server: journal '%v' is at revision %d", journalPath, lastRev)
+	}
+	dmm.events = newEventHub(lastRev)
+
+	if readOnly {
+		log.Printf("server: set up DNSMasqMgr (ReadOnly)")
+		return &dmm, nil
+	}
+
+	// before starting anything, so a bad duration leaves nothing behind
+	maxAge, err := config.ParseDuration(conf.JournalMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	watchInterval, err := config.ParseDuration(conf.WatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	snapInterval, err := config.ParseDuration(conf.SnapshotInterval)
+	if err != nil {
+		return nil, err
+	}
+
 	if journalPath != "" {
-		dmm.journal, err = os.Create(journalPath)
+		dmm.journal, err = journal.Open(journalPath)
 		if err != nil {
 			return nil, err
 		}
-		dmm.changes = log.New(dmm.journal, "", log.LstdFlags)
+		dmm.journalPath = journalPath
+		dmm.journal.MaxSize = conf.JournalMaxSize
+		dmm.journal.MaxAge = maxAge
 		log.Printf("server: logging changes on %v", journalPath)
 	} else {
-		dmm.changes = log.New(ioutil.Discard, "", log.LstdFlags)
 		log.Printf("server: NOT logging changes")
 	}
 
+	if conf.SnapshotDir != "" {
+		err = os.MkdirAll(conf.SnapshotDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		dmm.snapshotDir = conf.SnapshotDir
+		// after opening the journal, to record the edits done while dnsmasqmgrd was down
+		err = dmm.takeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	go dmm.storeLoop()
 	log.Printf("server: started storing loop")
 
+	if dmm.editPolicy != config.EditsOverwrite {
+		dmm.watcher = filewatch.New([]string{hostsPath, leasesPath}, watchInterval)
+		dmm.watchDone = make(chan struct{})
+		go dmm.watchLoop()
+		log.Printf("server: watching the managed files for external edits (policy: %s)", dmm.editPolicy)
+	}
+
+	if dmm.snapshotDir != "" && dmm.journal != nil && snapInterval > 0 {
+		dmm.snapshotKeep = conf.SnapshotKeep
+		dmm.snapStop = make(chan struct{})
+		dmm.snapDone = make(chan struct{})
+		go dmm.snapshotLoop(snapInterval)
+		log.Printf("server: taking snapshots every %v", snapInterval)
+	}
+
 	log.Printf("server: set up DNSMasqMgr")
 	return &dmm, nil
 }
 
+// reserveInUse marks as used all the addresses already found in the managed files,
+// so they are never handed out again.
+func (dmm *DNSMasqMgr) reserveInUse() {
+	for _, h := range dmm.nameMap.Hosts() {
+		dmm.reserve(h.Address)
+	}
+	for _, b := range dmm.addrMap.Bindings() {
+		for _, ip := range b.IPs() {
+			dmm.reserve(ip)
+		}
+	}
+}
+
+// reserve marks the address as used in its pool, if any
+func (dmm *DNSMasqMgr) reserve(ip net.IP) {
+	if alloc := dmm.allocFor(ip); alloc != nil {
+		alloc.Reserve(ip.To16())
+	}
+}
+
+// inUse returns true if any entry in the managed files uses the given address.
+// Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) inUse(ip net.IP) bool {
+	if _, err := dmm.nameMap.GetByAddress(ip.String()); err == nil {
+		return true
+	}
+	if _, err := dmm.addrMap.GetByIP(ip.String()); err == nil {
+		return true
+	}
+	return false
+}
+
+// releaseUnused gives back to the pool the address, unless some entry still uses it
+// or it is excluded from the pool. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) releaseUnused(ip net.IP) {
+	if ip == nil || dmm.inUse(ip) || dmm.excluded(ip) {
+		return
+	}
+	if alloc := dmm.allocFor(ip); alloc != nil {
+		alloc.Release(ip.To16())
+	}
+}
+
 func (dmm *DNSMasqMgr) Close() error {
-	dmm.flushChan <- false
+	if dmm.readOnly {
+		return nil
+	}
+
+	if dmm.watcher != nil {
+		dmm.watcher.Close()
+		<-dmm.watchDone
+	}
+
+	if dmm.snapStop != nil {
+		close(dmm.snapStop)
+		<-dmm.snapDone
+	}
+
+	close(dmm.storeChan)
 	<-dmm.doneChan
 
 	if dmm.journal == nil {
@@ -0,0 +1,1152 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/journal"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/config"
+	"github.com/mojaves/dnsmasqmgr/pkg/server/policy"
+)
+
+const testHosts string = "" +
+	"127.0.0.1\tlocalhost\n" +
+	"192.168.1.1\tgateway.test.lan\tgateway\n" +
+	"192.168.1.63\tclient.test.lan\tclient\n" +
+	""
+
+const testLeases string = "" +
+	"52:54:aa:11:bb:22,192.168.1.63\n" +
+	""
+
+func setupTestConf(t *testing.T) (*config.Config, func()) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatalf("unexpected error creating tmpdir: %v", err)
+	}
+	conf := config.Default()
+	conf.IPRange = "192.168.1.60-64"
+	conf.HostsPath = filepath.Join(dir, "hosts")
+	conf.LeasesPath = filepath.Join(dir, "dhcphosts")
+	conf.JournalPath = filepath.Join(dir, "journal.json")
+	ioutil.WriteFile(conf.HostsPath, []byte(testHosts), 0644)
+	ioutil.WriteFile(conf.LeasesPath, []byte(testLeases), 0644)
+	return conf, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRequestDeleteAddress(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+
+	ctx := context.Background()
+	st, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Readonly || len(st.Pools) != 1 || st.Pools[0].Total != 5 || st.Pools[0].Remaining != 4 {
+		t.Errorf("unexpected status: %v", st)
+	}
+
+	r, err := dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "new.test.lan",
+			Macaddr:  "02:00:00:00:00:01",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	if r.Addr.Ipaddr == "" || r.Addr.Ipaddr == "192.168.1.63" {
+		t.Errorf("unexpected address allocated: %v", r.Addr.Ipaddr)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "other.test.lan",
+			Macaddr:  "02:00:00:00:00:02",
+			Ipaddr:   "192.168.1.63",
+		},
+	})
+	if status.Code(ToStatus(err)) != codes.AlreadyExists {
+		t.Errorf("unexpected error requesting an address in use: %v", err)
+	}
+	st, _ = dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Pools[0].Remaining != 3 {
+		t.Errorf("unexpected status after request: %v", st)
+	}
+
+	_, err = dmm.DeleteAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_HOSTNAME,
+		Addr: &pb.Address{Hostname: "new.test.lan"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error deleting an address: %v", err)
+	}
+	st, _ = dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Pools[0].Remaining != 4 {
+		t.Errorf("unexpected status after delete: %v", st)
+	}
+	checkContent(t, conf.HostsPath, testHosts)
+	checkContent(t, conf.LeasesPath, testLeases)
+}
+
+func TestUpdateAddress(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.Pools = []config.Pool{
+		{Name: "guests", Subnet: "192.168.30.0/24", Range: "192.168.30.10-20", Tags: []string{"guest"}},
+	}
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+
+	ctx := context.Background()
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "client.test.lan", Ipaddr: "192.168.1.62"},
+		Updated: &pb.Address{Ipaddr: "192.168.1.61"},
+	})
+	if status.Code(ToStatus(err)) != codes.Aborted {
+		t.Errorf("unexpected error updating with stale values: %v", err)
+	}
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "client.test.lan"},
+		Updated: &pb.Address{Ipaddr: "192.168.1.1"},
+	})
+	if status.Code(ToStatus(err)) != codes.AlreadyExists {
+		t.Errorf("unexpected error updating to an used address: %v", err)
+	}
+
+	r, err := dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_MACADDR,
+		Current: &pb.Address{Macaddr: "52:54:aa:11:bb:22", Ipaddr: "192.168.1.63"},
+		Updated: &pb.Address{Hostname: "renamed.test.lan", Ipaddr: "192.168.1.61"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error updating an address: %v", err)
+	}
+	if r.Addr.Hostname != "renamed.test.lan" || r.Addr.Macaddr != "52:54:aa:11:bb:22" || r.Addr.Ipaddr != "192.168.1.61" {
+		t.Errorf("unexpected updated address: %v", r.Addr)
+	}
+	if dmm.events.Revision() != 1 {
+		t.Errorf("unexpected revision after update: %v", dmm.events.Revision())
+	}
+
+	// the address given back is available again
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "new.test.lan",
+			Macaddr:  "02:00:00:00:00:01",
+			Ipaddr:   "192.168.1.63",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting the old address: %v", err)
+	}
+
+	checkContent(t, conf.HostsPath, ""+
+		"127.0.0.1\tlocalhost\n"+
+		"192.168.1.1\tgateway.test.lan\tgateway\n"+
+		"192.168.1.61\trenamed.test.lan\tclient\n"+
+		"192.168.1.63\tnew.test.lan\n")
+	checkContent(t, conf.LeasesPath, ""+
+		"52:54:aa:11:bb:22,192.168.1.61\n"+
+		"02:00:00:00:00:01,192.168.1.63\n")
+
+	// the entries stay in their pools
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "new.test.lan"},
+		Updated: &pb.Address{Ipaddr: "192.168.30.15"},
+	})
+	if err != ErrOutOfSubnet {
+		t.Errorf("unexpected error moving an entry in another pool: %v", err)
+	}
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "guest.test.lan", Macaddr: "02:00:00:00:00:02", Tags: []string{"guest"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting a guest address: %v", err)
+	}
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "guest.test.lan"},
+		Updated: &pb.Address{Ipaddr: "192.168.1.62"},
+	})
+	if err != ErrOutOfSubnet {
+		t.Errorf("unexpected error moving an entry out of the subnet of its pool: %v", err)
+	}
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "guest.test.lan"},
+		Updated: &pb.Address{Ipaddr: "192.168.30.200"},
+	})
+	if err != nil {
+		t.Errorf("unexpected error moving an entry within the subnet of its pool: %v", err)
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.IP6Range = "fd00::100-fd00::101"
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+
+	ctx := context.Background()
+	r, err := dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "laptop.test.lan",
+			Macaddr:  "02:00:00:00:00:01",
+			Ipaddr:   "192.168.1.61",
+			Ip6Addr:  "::100",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	// interface identifiers are completed with the prefix of the pool
+	if r.Addr.Ip6Addr != "fd00::100" {
+		t.Errorf("unexpected IPv6 address: %v", r.Addr)
+	}
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "server.test.lan",
+			Clientid: "00:01:00:01:16:d2:83:fc",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address by client-id: %v", err)
+	}
+	if r.Addr.Ip6Addr != "fd00::101" || r.Addr.Ipaddr == "" {
+		t.Errorf("unexpected addresses: %v", r.Addr)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "full.test.lan",
+			Macaddr:  "02:00:00:00:00:03",
+		},
+	})
+	if err != ErrPoolExhaust {
+		t.Errorf("unexpected error with the IPv6 pool exhausted: %v", err)
+	}
+
+	lr, err := dmm.LookupAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_IPADDR,
+		Addr: &pb.Address{Ip6Addr: "fd00::101"},
+	})
+	if err != nil || lr.Match != pb.Match_FULL || lr.Addr.Hostname != "server.test.lan" || lr.Addr.Clientid != "00:01:00:01:16:d2:83:fc" {
+		t.Errorf("unexpected lookup by IPv6 address: %v %v", lr, err)
+	}
+
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "laptop.test.lan", Ip6Addr: "fd00::100"},
+		Updated: &pb.Address{Hostname: "notebook.test.lan", Ip6Addr: "fd00::56"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error updating: %v", err)
+	}
+	_, err = dmm.DeleteAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_MACADDR,
+		Addr: &pb.Address{Clientid: "00:01:00:01:16:d2:83:fc"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error deleting by client-id: %v", err)
+	}
+
+	sr, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if len(sr.Pools) != 1 || sr.Pools[0].Remaining6 != 2 {
+		t.Errorf("unexpected pools: %v", sr.Pools)
+	}
+	dmm.Close()
+
+	expectedHosts := "" +
+		"127.0.0.1\tlocalhost\n" +
+		"192.168.1.1\tgateway.test.lan\tgateway\n" +
+		"192.168.1.63\tclient.test.lan\tclient\n" +
+		"192.168.1.61\tnotebook.test.lan\n" +
+		"fd00::56\tnotebook.test.lan\n"
+	checkContent(t, conf.HostsPath, expectedHosts)
+	checkContent(t, conf.LeasesPath, ""+
+		"52:54:aa:11:bb:22,192.168.1.63\n"+
+		"02:00:00:00:00:01,192.168.1.61,[fd00::56]\n")
+
+	// the journal rebuilds the same content
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading the journal: %v", err)
+	}
+	nameMap, addrMap, _ := parseState(testHosts, testLeases)
+	for _, e := range entries {
+		if err = applyEntry(nameMap, addrMap, e); err != nil {
+			t.Fatalf("unexpected error replaying %v: %v", e, err)
+		}
+	}
+	if nameMap.String() != expectedHosts {
+		t.Errorf("inconsistent replayed content:\n%v", nameMap.String())
+	}
+}
+
+func TestPools(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.Pools = []config.Pool{
+		{
+			Name:        "iot",
+			Subnet:      "192.168.20.0/24",
+			Range:       "192.168.20.10-13",
+			Exclude:     []string{"192.168.20.11-12"},
+			Domain:      "iot.lan",
+			MACPrefixes: []string{"b8:27:eb"},
+		},
+		{
+			Name:   "guests",
+			Subnet: "192.168.30.0/24",
+			Range:  "192.168.30.10-20",
+			Tags:   []string{"guest"},
+		},
+	}
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+
+	ctx := context.Background()
+	// by MAC prefix, with the domain of the pool, avoiding the exclusions
+	r, err := dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "sensor", Macaddr: "B8:27:EB:00:00:01"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	if r.Addr.Hostname != "sensor.iot.lan" || (r.Addr.Ipaddr != "192.168.20.10" && r.Addr.Ipaddr != "192.168.20.13") {
+		t.Errorf("unexpected address: %v", r.Addr)
+	}
+	// by tag, which is set on the binding
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "phone.guest.lan", Macaddr: "02:00:00:00:00:02", Tags: []string{"guest"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	if !strings.HasPrefix(r.Addr.Ipaddr, "192.168.30.") || len(r.Addr.Tags) != 1 {
+		t.Errorf("unexpected address: %v", r.Addr)
+	}
+	// by name, checking the subnet
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Pool: "guests",
+		Addr: &pb.Address{Hostname: "tv.guest.lan", Macaddr: "02:00:00:00:00:03", Ipaddr: "192.168.20.50"},
+	})
+	if status.Code(ToStatus(err)) != codes.InvalidArgument {
+		t.Errorf("unexpected error requesting an address out of the subnet: %v", err)
+	}
+	// the default pool has no subnet, but the ranges of the other pools are not its own
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Pool: config.DefaultPoolName,
+		Addr: &pb.Address{Hostname: "tv.guest.lan", Macaddr: "02:00:00:00:00:03", Ipaddr: "192.168.30.15"},
+	})
+	if err != ErrOutOfSubnet {
+		t.Errorf("unexpected error requesting an address of another pool: %v", err)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Pool: "servers",
+		Addr: &pb.Address{Hostname: "db.test.lan", Macaddr: "02:00:00:00:00:04"},
+	})
+	if status.Code(ToStatus(err)) != codes.NotFound {
+		t.Errorf("unexpected error requesting an address from an unknown pool: %v", err)
+	}
+	// by requested address
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "tv.guest.lan", Macaddr: "02:00:00:00:00:03", Ipaddr: "192.168.30.50"},
+	})
+	if err != nil || r.Addr.Ipaddr != "192.168.30.50" {
+		t.Fatalf("unexpected result requesting an address: %v %v", r, err)
+	}
+	// the pool is exhausted, the excluded addresses are never handed out
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "camera", Macaddr: "b8:27:eb:00:00:02"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "bulb", Macaddr: "b8:27:eb:00:00:03"},
+	})
+	if err != ErrPoolExhaust {
+		t.Errorf("unexpected error with the pool exhausted: %v", err)
+	}
+
+	sr, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if len(sr.Pools) != 3 || sr.Pools[1].Name != "iot" || sr.Pools[1].Total != 4 || sr.Pools[1].Remaining != 0 || sr.Pools[2].Remaining != 10 {
+		t.Errorf("unexpected pools: %v", sr.Pools)
+	}
+
+	conf.Pools = append(conf.Pools, config.Pool{Name: "overlap", Range: "192.168.30.20-30"})
+	if err = conf.Check(); err == nil {
+		t.Errorf("unexpected success checking overlapping pools")
+	}
+}
+
+func TestAliases(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	ctx := context.Background()
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "new.test.lan",
+			Macaddr:  "02:00:00:00:00:01",
+			Aliases:  []string{"gateway"},
+		},
+	})
+	if status.Code(ToStatus(err)) != codes.AlreadyExists || !strings.Contains(err.Error(), "gateway.test.lan") {
+		t.Errorf("unexpected error requesting an address with an alias in use: %v", err)
+	}
+
+	r, err := dmm.AddAlias(ctx, &pb.AliasRequest{
+		Key:     pb.Key_MACADDR,
+		Addr:    &pb.Address{Macaddr: "52:54:aa:11:bb:22"},
+		Aliases: []string{"laptop", "mypc"},
+	})
+	if err != nil || len(r.Addr.Aliases) != 3 {
+		t.Fatalf("unexpected result adding aliases: %v %v", r, err)
+	}
+	r, err = dmm.LookupAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_ALIAS,
+		Addr: &pb.Address{Aliases: []string{"mypc"}},
+	})
+	if err != nil || r.Match != pb.Match_FULL || r.Addr.Hostname != "client.test.lan" {
+		t.Errorf("unexpected lookup by alias: %v %v", r, err)
+	}
+	_, err = dmm.AddAlias(ctx, &pb.AliasRequest{
+		Key:     pb.Key_HOSTNAME,
+		Addr:    &pb.Address{Hostname: "client.test.lan"},
+		Aliases: []string{"desktop", "gateway"},
+	})
+	if err == nil {
+		t.Errorf("unexpected success adding an alias in use")
+	}
+	r, err = dmm.RemoveAlias(ctx, &pb.AliasRequest{
+		Key:     pb.Key_ALIAS,
+		Addr:    &pb.Address{Aliases: []string{"laptop"}},
+		Aliases: []string{"client", "laptop"},
+	})
+	if err != nil || len(r.Addr.Aliases) != 1 || r.Addr.Aliases[0] != "mypc" {
+		t.Errorf("unexpected result removing aliases: %v %v", r, err)
+	}
+	dmm.Close()
+
+	expected := "" +
+		"127.0.0.1\tlocalhost\n" +
+		"192.168.1.1\tgateway.test.lan\tgateway\n" +
+		"192.168.1.63\tclient.test.lan\tmypc\n"
+	checkContent(t, conf.HostsPath, expected)
+
+	// alias changes are replayed from the journal
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("unexpected journal: %v %v", entries, err)
+	}
+	nameMap, addrMap, err := parseState(testHosts, testLeases)
+	if err != nil {
+		t.Fatalf("unexpected error parsing the state: %v", err)
+	}
+	for _, e := range entries {
+		err = applyEntry(nameMap, addrMap, e)
+		if err != nil {
+			t.Fatalf("unexpected error replaying %v: %v", e, err)
+		}
+	}
+	if nameMap.String() != expected {
+		t.Errorf("unexpected replayed content:\n%s", nameMap.String())
+	}
+}
+
+func TestHostnames(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.Domain = "test.lan"
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+	ctx := context.Background()
+
+	for _, name := range []string{"my laptop", "laptop\tnas", "my_laptop", "-laptop"} {
+		_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+			Addr: &pb.Address{Hostname: name, Macaddr: "02:00:00:00:00:01"},
+		})
+		if status.Code(ToStatus(err)) != codes.InvalidArgument {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "laptop", Macaddr: "02:00:00:00:00:01", Aliases: []string{"bad alias"}},
+	})
+	if status.Code(ToStatus(err)) != codes.InvalidArgument {
+		t.Errorf("unexpected error requesting a bad alias: %v", err)
+	}
+
+	// short names are completed with the domain, and kept as aliases
+	r, err := dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "Laptop", Macaddr: "02:00:00:00:00:01"},
+	})
+	if err != nil || r.Addr.Hostname != "laptop.test.lan" || !reflect.DeepEqual(r.Addr.Aliases, []string{"laptop"}) {
+		t.Fatalf("unexpected result requesting a short name: %v %v", r, err)
+	}
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "dns.test.lan.", Macaddr: "02:00:00:00:00:02", Aliases: []string{"NS"}},
+	})
+	if err != nil || r.Addr.Hostname != "dns.test.lan" || !reflect.DeepEqual(r.Addr.Aliases, []string{"ns", "dns"}) {
+		t.Fatalf("unexpected result requesting a FQDN: %v %v", r, err)
+	}
+	// the short name is taken by dns.test.lan
+	r, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "ns", Macaddr: "02:00:00:00:00:03"},
+	})
+	if err != nil || r.Addr.Hostname != "ns.test.lan" || len(r.Addr.Aliases) != 0 {
+		t.Fatalf("unexpected result requesting a short name in use: %v %v", r, err)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "LAPTOP.test.lan", Macaddr: "02:00:00:00:00:04"},
+	})
+	if status.Code(ToStatus(err)) != codes.AlreadyExists {
+		t.Errorf("unexpected error requesting a name differing in case: %v", err)
+	}
+
+	for _, name := range []string{"laptop", "LAPTOP.TEST.LAN", "laptop.test.lan."} {
+		r, err = dmm.LookupAddress(ctx, &pb.AddressRequest{
+			Key:  pb.Key_HOSTNAME,
+			Addr: &pb.Address{Hostname: name},
+		})
+		if err != nil || r.Match != pb.Match_FULL || r.Addr.Hostname != "laptop.test.lan" {
+			t.Errorf("%q: unexpected lookup: %v %v", name, r, err)
+		}
+	}
+	_, err = dmm.AddAlias(ctx, &pb.AliasRequest{
+		Key:     pb.Key_HOSTNAME,
+		Addr:    &pb.Address{Hostname: "laptop"},
+		Aliases: []string{"my_pc"},
+	})
+	if status.Code(ToStatus(err)) != codes.InvalidArgument {
+		t.Errorf("unexpected error adding a bad alias: %v", err)
+	}
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "laptop"},
+		Updated: &pb.Address{Hostname: "new laptop"},
+	})
+	if status.Code(ToStatus(err)) != codes.InvalidArgument {
+		t.Errorf("unexpected error renaming to a bad name: %v", err)
+	}
+	r, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "Laptop.test.lan"},
+		Updated: &pb.Address{Hostname: "Notebook"},
+	})
+	if err != nil || r.Addr.Hostname != "notebook.test.lan" || !reflect.DeepEqual(r.Addr.Aliases, []string{"notebook"}) {
+		t.Errorf("unexpected result renaming: %v %v", r, err)
+	}
+	r, err = dmm.LookupAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_ALIAS,
+		Addr: &pb.Address{Aliases: []string{"laptop"}},
+	})
+	if err == nil {
+		t.Errorf("the old short name still resolves: %v", r)
+	}
+}
+
+func TestRestoreRecover(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.SnapshotDir = filepath.Join(filepath.Dir(conf.JournalPath), "snapshots")
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"a.test.lan", "b.test.lan"} {
+		_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+			Addr: &pb.Address{
+				Hostname: name,
+				Macaddr:  "02:00:00:00:00:0" + name[:1],
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error requesting an address: %v", err)
+		}
+	}
+	dmm.Close()
+
+	rev, err := Restore(conf, journal.Point{Revision: 1})
+	if err != nil || rev != 3 {
+		t.Fatalf("unexpected restore result: rev=%v err=%v", rev, err)
+	}
+	restored, _ := ioutil.ReadFile(conf.HostsPath)
+	if !strings.Contains(string(restored), "a.test.lan") || strings.Contains(string(restored), "b.test.lan") {
+		t.Errorf("unexpected restored content: %s", restored)
+	}
+
+	// the managed files get lost: rebuild them
+	ioutil.WriteFile(conf.HostsPath, nil, 0644)
+	ioutil.WriteFile(conf.LeasesPath, nil, 0644)
+	err = Recover(conf)
+	if err != nil {
+		t.Fatalf("unexpected error recovering: %v", err)
+	}
+	checkContent(t, conf.HostsPath, string(restored))
+
+	dmm, err = NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error restarting the server: %v", err)
+	}
+	if dmm.events.Revision() != 3 {
+		t.Errorf("revisions do not continue: %v", dmm.events.Revision())
+	}
+	dmm.Close()
+
+	// edited while down: the snapshot of the last revision is kept, the edits get a revision of their own
+	appendFile(t, conf.HostsPath, "192.168.1.9\tserver.test.lan\n")
+	dmm, err = NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error restarting the server: %v", err)
+	}
+	defer dmm.Close()
+	if dmm.events.Revision() != 4 {
+		t.Errorf("edits done while down not recorded: %v", dmm.events.Revision())
+	}
+	snap, err := journal.LoadSnapshot(conf.SnapshotDir, 3)
+	if err != nil || snap.Hosts != string(restored) {
+		t.Errorf("snapshot replaced: %v %v", snap, err)
+	}
+	snap, err = journal.LoadSnapshot(conf.SnapshotDir, 4)
+	if err != nil || !strings.Contains(snap.Hosts, "server.test.lan") {
+		t.Errorf("unexpected snapshot of the edits: %v %v", snap, err)
+	}
+}
+
+// the lines never journaled survive a recovery
+func TestRecover(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	_, err = dmm.RequestAddress(context.Background(), &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "a.test.lan", Macaddr: "02:00:00:00:00:01"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	dmm.Close()
+	stored, _ := ioutil.ReadFile(conf.HostsPath)
+
+	// the journal alone cannot rebuild the files
+	err = Recover(conf)
+	if err != ErrNoSnapshots {
+		t.Errorf("unexpected error recovering without snapshots: %v", err)
+	}
+	checkContent(t, conf.HostsPath, string(stored))
+
+	conf.SnapshotDir = filepath.Join(filepath.Dir(conf.JournalPath), "snapshots")
+	dmm, err = NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error restarting the server: %v", err)
+	}
+	_, err = dmm.RequestAddress(context.Background(), &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "b.test.lan", Macaddr: "02:00:00:00:00:02"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+	dmm.Close()
+	stored, _ = ioutil.ReadFile(conf.HostsPath)
+
+	ioutil.WriteFile(conf.HostsPath, nil, 0644)
+	ioutil.WriteFile(conf.LeasesPath, nil, 0644)
+	err = Recover(conf)
+	if err != nil {
+		t.Fatalf("unexpected error recovering: %v", err)
+	}
+	checkContent(t, conf.HostsPath, string(stored))
+	for _, want := range []string{"localhost", "gateway.test.lan", "client.test.lan", "a.test.lan", "b.test.lan"} {
+		if !strings.Contains(string(stored), want) {
+			t.Errorf("missing %q in the recovered hosts file:\n%s", want, stored)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.SnapshotDir = filepath.Join(filepath.Dir(conf.JournalPath), "snapshots")
+	conf.PolicyPath = filepath.Join(filepath.Dir(conf.JournalPath), "policy.json")
+	ioutil.WriteFile(conf.PolicyPath, []byte(`{
+		"rules": [
+			{"identity": "ci", "hostnames": ["*.ci.lan"]}
+		]
+	}`), 0600)
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	ctx := policy.NewContext(context.Background(), "ci")
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "build.ci.lan", Macaddr: "02:00:00:00:00:01"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an allowed address: %v", err)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "build.ci.lan", Macaddr: "02:00:00:00:00:02", Aliases: []string{"www.test.lan"}},
+	})
+	if status.Code(ToStatus(err)) != codes.PermissionDenied {
+		t.Errorf("unexpected error requesting an alias out of the policy: %v", err)
+	}
+	_, err = dmm.UpdateAddress(ctx, &pb.UpdateRequest{
+		Key:     pb.Key_HOSTNAME,
+		Current: &pb.Address{Hostname: "build.ci.lan"},
+		Updated: &pb.Address{Hostname: "build.test.lan"},
+	})
+	if !errors.Is(err, policy.ErrDenied) {
+		t.Errorf("unexpected error renaming out of the policy: %v", err)
+	}
+	_, err = dmm.DeleteAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_HOSTNAME,
+		Addr: &pb.Address{Hostname: "client.test.lan"},
+	})
+	if !errors.Is(err, policy.ErrDenied) {
+		t.Errorf("unexpected error deleting out of the policy: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		// the repeated denials are recorded once
+		_, err = dmm.DeleteAddress(context.Background(), &pb.AddressRequest{
+			Key:  pb.Key_HOSTNAME,
+			Addr: &pb.Address{Hostname: "build.ci.lan"},
+		})
+		if !errors.Is(err, policy.ErrDenied) {
+			t.Errorf("unexpected error deleting anonymously: %v", err)
+		}
+	}
+	if d := dmm.denials["anonymous/DeleteAddress"]; d == nil || d.repeated != 2 {
+		t.Errorf("unexpected repeated denials: %+v", d)
+	}
+	dmm.Close()
+
+	entries, err := journal.Read(conf.JournalPath)
+	if err != nil || len(entries) != 5 {
+		t.Fatalf("unexpected journal: %v %v", entries, err)
+	}
+	denied := entries[4]
+	if denied.Action != journal.ActionDeny || denied.Revision != 1 || denied.Identity != "anonymous" ||
+		denied.Method != "DeleteAddress" || denied.Address.Hostname != "build.ci.lan" {
+		t.Errorf("unexpected denial recorded: %+v", denied)
+	}
+
+	// the denials change nothing
+	ioutil.WriteFile(conf.HostsPath, nil, 0644)
+	ioutil.WriteFile(conf.LeasesPath, nil, 0644)
+	err = Recover(conf)
+	if err != nil {
+		t.Fatalf("unexpected error recovering: %v", err)
+	}
+	hosts, _ := ioutil.ReadFile(conf.HostsPath)
+	if !strings.Contains(string(hosts), "build.ci.lan") || strings.Contains(string(hosts), "www.test.lan") {
+		t.Errorf("unexpected recovered content: %s", hosts)
+	}
+}
+
+// the short aliases added for the names in the domain of the pool are covered by the patterns of the domain
+func TestPolicyDomain(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.Domain = "ci.lan"
+	conf.PolicyPath = filepath.Join(filepath.Dir(conf.JournalPath), "policy.json")
+	ioutil.WriteFile(conf.PolicyPath, []byte(`{
+		"rules": [
+			{"identity": "ci", "hostnames": ["*.ci.lan"]}
+		]
+	}`), 0600)
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+	ctx := policy.NewContext(context.Background(), "ci")
+	for ix, name := range []string{"build.ci.lan", "test"} {
+		r, err := dmm.RequestAddress(ctx, &pb.AddressRequest{
+			Addr: &pb.Address{Hostname: name, Macaddr: fmt.Sprintf("02:00:00:00:00:0%d", ix+1)},
+		})
+		if err != nil || len(r.Addr.Aliases) != 1 {
+			t.Fatalf("%s: unexpected result requesting an allowed address: %v %v", name, r, err)
+		}
+	}
+	_, err = dmm.AddAlias(ctx, &pb.AliasRequest{
+		Key:     pb.Key_HOSTNAME,
+		Addr:    &pb.Address{Hostname: "build"},
+		Aliases: []string{"runner"},
+	})
+	if err != nil {
+		t.Errorf("unexpected error adding an allowed short alias: %v", err)
+	}
+	_, err = dmm.DeleteAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_HOSTNAME,
+		Addr: &pb.Address{Hostname: "build.ci.lan"},
+	})
+	if err != nil {
+		t.Errorf("unexpected error deleting an allowed address: %v", err)
+	}
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "www.test.lan", Macaddr: "02:00:00:00:00:03"},
+	})
+	if !errors.Is(err, policy.ErrDenied) {
+		t.Errorf("unexpected error requesting out of the policy: %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	dmm, err := NewDNSMasqMgrReadOnly(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+
+	ctx := context.Background()
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{
+			Hostname: "new.test.lan",
+			Macaddr:  "02:00:00:00:00:01",
+		},
+	})
+	if status.Code(ToStatus(err)) != codes.FailedPrecondition {
+		t.Errorf("unexpected error requesting an address: %v", err)
+	}
+	_, err = dmm.DeleteAddress(ctx, &pb.AddressRequest{
+		Key:  pb.Key_HOSTNAME,
+		Addr: &pb.Address{Hostname: "client.test.lan"},
+	})
+	if status.Code(ToStatus(err)) != codes.FailedPrecondition {
+		t.Errorf("unexpected error deleting an address: %v", err)
+	}
+
+	st, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if !st.Readonly {
+		t.Errorf("read-only mode not reported")
+	}
+	checkContent(t, conf.HostsPath, testHosts)
+	checkContent(t, conf.LeasesPath, testLeases)
+	if _, err := os.Stat(conf.JournalPath); !os.IsNotExist(err) {
+		t.Errorf("journal created in read-only mode: %v", err)
+	}
+}
+
+func TestBadDuration(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	conf.JournalMaxAge = "a week"
+	dmm, err := NewDNSMasqMgr(conf)
+	if err == nil {
+		dmm.Close()
+		t.Errorf("unexpected success with a malformed journal max age")
+	}
+}
+
+func TestListAddresses(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	dmm, err := NewDNSMasqMgrReadOnly(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+
+	ctx := context.Background()
+	req := &pb.ListRequest{PageSize: 1}
+	var names []string
+	for {
+		r, err := dmm.ListAddresses(ctx, req)
+		if err != nil {
+			t.Fatalf("unexpected error listing: %v", err)
+		}
+		if len(r.Addrs) > 1 {
+			t.Errorf("page size not honoured: %v", r.Addrs)
+		}
+		for _, ar := range r.Addrs {
+			names = append(names, ar.Addr.Hostname)
+		}
+		if r.NextPageToken == "" {
+			break
+		}
+		req.PageToken = r.NextPageToken
+	}
+	if len(names) != 3 || names[0] != "localhost" || names[1] != "gateway.test.lan" || names[2] != "client.test.lan" {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	r, err := dmm.ListAddresses(ctx, &pb.ListRequest{
+		HostnameGlob: "*.TEST.lan",
+		Subnet:       "192.168.1.0/24",
+		Match:        pb.Match_FULL,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error listing: %v", err)
+	}
+	if len(r.Addrs) != 1 || r.Addrs[0].Addr.Macaddr != "52:54:aa:11:bb:22" {
+		t.Errorf("unexpected entries: %v", r.Addrs)
+	}
+
+	_, err = dmm.ListAddresses(ctx, &pb.ListRequest{Subnet: "192.168.1.0/33"})
+	if err != ErrInvalidParam {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestListLeases(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	ctx := context.Background()
+	_, err = dmm.ListLeases(ctx, &pb.LeasesRequest{})
+	if err != ErrNoActiveLeases {
+		t.Errorf("unexpected error without leases file: %v", err)
+	}
+	dmm.Close()
+
+	conf.ActiveLeasesPath = filepath.Join(filepath.Dir(conf.JournalPath), "dnsmasq.leases")
+	ioutil.WriteFile(conf.ActiveLeasesPath, []byte(""+
+		"4000000000 52:54:aa:11:bb:22 192.168.1.63 client *\n"+
+		"0 02:00:00:00:00:09 192.168.1.99 dyn 01:02:00:00:00:00:09\n"+
+		"1000000000 02:00:00:00:00:10 192.168.1.98 gone *\n"), 0644)
+	dmm, err = NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+	_, err = dmm.RequestAddress(ctx, &pb.AddressRequest{
+		Addr: &pb.Address{Hostname: "new.test.lan", Macaddr: "02:00:00:00:00:01"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error requesting an address: %v", err)
+	}
+
+	r, err := dmm.ListLeases(ctx, &pb.LeasesRequest{})
+	if err != nil || len(r.Entries) != 3 {
+		t.Fatalf("unexpected leases: %v %v", r, err)
+	}
+	e := r.Entries[0]
+	if e.Lease.Macaddr != "52:54:aa:11:bb:22" || e.Lease.Expiry != 4000000000 || e.Static == nil || e.Static.Hostname != "client.test.lan" {
+		t.Errorf("unexpected leased static entry: %v", e)
+	}
+	e = r.Entries[1]
+	if e.Lease.Hostname != "dyn" || e.Lease.Clientid != "01:02:00:00:00:00:09" || e.Static != nil {
+		t.Errorf("unexpected dynamic entry: %v", e)
+	}
+	e = r.Entries[2]
+	if e.Lease != nil || e.Static == nil || e.Static.Hostname != "new.test.lan" {
+		t.Errorf("unexpected static entry without lease: %v", e)
+	}
+
+	r, err = dmm.ListLeases(ctx, &pb.LeasesRequest{Expired: true})
+	if err != nil || len(r.Entries) != 4 || r.Entries[2].Lease.Hostname != "gone" {
+		t.Errorf("unexpected leases including the expired ones: %v %v", r, err)
+	}
+}
+
+func TestPinLease(t *testing.T) {
+	conf, cleanup := setupTestConf(t)
+	defer cleanup()
+	conf.ActiveLeasesPath = filepath.Join(filepath.Dir(conf.JournalPath), "dnsmasq.leases")
+	ioutil.WriteFile(conf.ActiveLeasesPath, []byte(""+
+		"4000000000 52:54:aa:11:bb:22 192.168.1.63 client *\n"+
+		"4000000000 02:00:00:00:00:09 192.168.1.61 dyn *\n"+
+		"4000000000 02:00:00:00:00:0a 192.168.1.99 outside *\n"+
+		"4000000000 02:00:00:00:00:0b 192.168.1.62 * *\n"+
+		"1000000000 02:00:00:00:00:0c 192.168.1.60 gone *\n"), 0644)
+
+	dmm, err := NewDNSMasqMgr(conf)
+	if err != nil {
+		t.Fatalf("unexpected error creating the server: %v", err)
+	}
+	defer dmm.Close()
+	ctx := context.Background()
+
+	r, err := dmm.PinLease(ctx, &pb.PinRequest{
+		Key:  pb.Key_MACADDR,
+		Addr: &pb.Address{Macaddr: "02:00:00:00:00:09"},
+	})
+	if err != nil || r.Addr.Hostname != "dyn" || r.Addr.Ipaddr != "192.168.1.61" || r.Addr.Macaddr != "02:00:00:00:00:09" {
+		t.Fatalf("unexpected pin result: %v %v", r, err)
+	}
+	_, err = dmm.PinLease(ctx, &pb.PinRequest{
+		Key:  pb.Key_IPADDR,
+		Addr: &pb.Address{Ipaddr: "192.168.1.62"},
+	})
+	if err != ErrNoHostname {
+		t.Errorf("unexpected error pinning a lease without hostname: %v", err)
+	}
+	r, err = dmm.PinLease(ctx, &pb.PinRequest{
+		Key:      pb.Key_IPADDR,
+		Addr:     &pb.Address{Ipaddr: "192.168.1.62"},
+		Hostname: "named.test.lan",
+	})
+	if err != nil || r.Addr.Hostname != "named.test.lan" || r.Addr.Ipaddr != "192.168.1.62" {
+		t.Errorf("unexpected pin result with hostname: %v %v", r, err)
+	}
+	// the pool hands out the addresses in no particular order
+	r, err = dmm.PinLease(ctx, &pb.PinRequest{
+		Key:  pb.Key_HOSTNAME,
+		Addr: &pb.Address{Hostname: "outside"},
+	})
+	if err != nil || r.Addr.Ipaddr == "192.168.1.99" || r.Addr.Ipaddr == "" {
+		t.Errorf("unexpected pin result out of the pool: %v %v", r, err)
+	}
+	_, err = dmm.PinLease(ctx, &pb.PinRequest{
+		Key:  pb.Key_MACADDR,
+		Addr: &pb.Address{Macaddr: "02:00:00:00:00:0c"},
+	})
+	if err != ErrLeaseNotFound {
+		t.Errorf("unexpected error pinning an expired lease: %v", err)
+	}
+	_, err = dmm.PinLease(ctx, &pb.PinRequest{
+		Key:      pb.Key_MACADDR,
+		Addr:     &pb.Address{Macaddr: "52:54:aa:11:bb:22"},
+		Hostname: "again.test.lan",
+	})
+	if status.Code(ToStatus(err)) != codes.AlreadyExists {
+		t.Errorf("unexpected error pinning a static client: %v", err)
+	}
+
+	st, _ := dmm.GetStatus(ctx, &pb.StatusRequest{})
+	if st.Pools[0].Remaining != 1 {
+		t.Errorf("unexpected status after pinning: %v", st)
+	}
+}
+
+func TestEventHubResume(t *testing.T) {
+	h := newEventHub(0)
+	for ix := 0; ix < 3; ix++ {
+		h.publish(pb.Action_ADD, &pb.Address{Hostname: "host.test.lan"}, nil)
+	}
+
+	ch, backlog, err := h.subscribe(1)
+	if err != nil {
+		t.Fatalf("unexpected error subscribing: %v", err)
+	}
+	if len(backlog) != 2 || backlog[0].Revision != 2 || backlog[1].Revision != 3 {
+		t.Errorf("unexpected backlog: %v", backlog)
+	}
+	h.publish(pb.Action_DELETE, &pb.Address{Hostname: "host.test.lan"}, nil)
+	ev := <-ch
+	if ev.Revision != 4 || ev.Action != pb.Action_DELETE {
+		t.Errorf("unexpected event: %v", ev)
+	}
+	h.unsubscribe(ch)
+
+	for ix := 0; ix < eventHistory; ix++ {
+		h.publish(pb.Action_ADD, &pb.Address{Hostname: "host.test.lan"}, nil)
+	}
+	_, _, err = h.subscribe(1)
+	if status.Code(ToStatus(err)) != codes.OutOfRange {
+		t.Errorf("unexpected error resuming from a compacted revision: %v", err)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{nil, codes.OK},
+		{ErrPoolExhaust, codes.ResourceExhausted},
+		{ErrMismatch, codes.Aborted},
+		{ErrNoPools, codes.FailedPrecondition},
+		{fmt.Errorf("%w: 1, oldest is 2", ErrRevisionGone), codes.OutOfRange},
+		{etchosts.ErrNotFoundHostname, codes.NotFound},
+		{&etchosts.ConflictError{What: "x.test.lan"}, codes.AlreadyExists},
+		{fmt.Errorf("%w: x", dhcphosts.ErrDuplicateFound), codes.AlreadyExists},
+		{errors.New("something else"), codes.Unknown},
+	}
+	for _, c := range cases {
+		if code := status.Code(ToStatus(c.err)); code != c.code {
+			t.Errorf("%v: unexpected code %v, expected %v", c.err, code, c.code)
+		}
+	}
+
+	// the errors sharing a code carry the detail telling them apart
+	st, _ := status.FromError(ToStatus(fmt.Errorf("%w: resume from revision 3", ErrWatcherLag)))
+	details := st.Details()
+	if st.Code() != codes.Aborted || len(details) != 1 {
+		t.Fatalf("unexpected status: %v %v", st, details)
+	}
+	if d, ok := details[0].(*pb.ErrorDetail); !ok || d.Error != pb.Error_LAGGING {
+		t.Errorf("unexpected detail: %v", details[0])
+	}
+}
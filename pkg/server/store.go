@@ -24,45 +24,97 @@
 package server
 
 import (
-	"io/ioutil"
+	"fmt"
 	"log"
+
+	"github.com/mojaves/dnsmasqmgr/pkg/dhcphosts"
+	"github.com/mojaves/dnsmasqmgr/pkg/etchosts"
 )
 
-func (dmm *DNSMasqMgr) requestStore() {
-	dmm.flushChan <- true
+// storeRequest asks the store loop to persist a consistent snapshot of the managed files
+type storeRequest struct {
+	hosts  []byte
+	leases []byte
+	done   chan error
 }
 
-func (dmm *DNSMasqMgr) storeLoop() {
-	for {
-		flush := <-dmm.flushChan
-		if !flush {
-			return
-		}
+// checkpoint is a copy of the in-memory state, to roll back changes which could not be stored
+type checkpoint struct {
+	nameMap *etchosts.Conf
+	addrMap *dhcphosts.Conf
+	// the external edits merged since are merged again on the next change
+	hostsSeen  seenFile
+	leasesSeen seenFile
+}
 
-		err := dmm.Store()
-		if err != nil {
-			log.Printf("store failed: %v", err)
-		}
+// checkpoint must be called with dmm.lock held
+func (dmm *DNSMasqMgr) checkpoint() checkpoint {
+	return checkpoint{
+		nameMap:    dmm.nameMap.Clone(),
+		addrMap:    dmm.addrMap.Clone(),
+		hostsSeen:  dmm.hostsSeen,
+		leasesSeen: dmm.leasesSeen,
 	}
+}
 
-	dmm.doneChan <- true
+// rollback must be called with dmm.lock held
+func (dmm *DNSMasqMgr) rollback(cp checkpoint) {
+	dmm.nameMap = cp.nameMap
+	dmm.addrMap = cp.addrMap
+	dmm.hostsSeen = cp.hostsSeen
+	dmm.leasesSeen = cp.leasesSeen
+	log.Printf("server: rolled back in-memory changes")
 }
 
-func (dmm *DNSMasqMgr) Store() error {
-	var err error
+func (dmm *DNSMasqMgr) storeLoop() {
+	for req := range dmm.storeChan {
+		marker := commitMarkerPath(dmm.hostsPath)
+		req.done <- commitFiles(marker, []fileUpdate{
+			{
+				Path: dmm.hostsPath,
+				data: req.hosts,
+				mode: dmm.hostsInfo.Mode().Perm(),
+			},
+			{
+				Path: dmm.leasesPath,
+				data: req.leases,
+				mode: dmm.leasesInfo.Mode().Perm(),
+			},
+		})
+	}
 
-	dmm.lock.Lock()
-	defer dmm.lock.Unlock()
+	dmm.doneChan <- true
+}
 
-	err = ioutil.WriteFile(dmm.hostsPath, []byte(dmm.nameMap.String()), dmm.hostsInfo.Mode())
+// store persists the current state, and waits for the outcome. The edits done meanwhile outside
+// dnsmasqmgrd are handled first, see syncExternal. Must be called with dmm.lock held.
+func (dmm *DNSMasqMgr) store() error {
+	if dmm.readOnly {
+		return ErrReadOnly
+	}
+	err := dmm.syncExternal()
 	if err != nil {
 		return err
 	}
-
-	err = ioutil.WriteFile(dmm.leasesPath, []byte(dmm.addrMap.String()), dmm.leasesInfo.Mode())
+	req := storeRequest{
+		hosts:  []byte(dmm.nameMap.String()),
+		leases: []byte(dmm.addrMap.String()),
+		done:   make(chan error, 1),
+	}
+	dmm.storeChan <- req
+	err = <-req.done
 	if err != nil {
-		return err
+		// the files may not be replaced: keep what was seen, not what was meant to be written
+		log.Printf("store failed: %v", err)
+		return fmt.Errorf("cannot store the changes: %v", err)
 	}
-
+	dmm.hostsSeen = wroteSeen(dmm.hostsPath, req.hosts)
+	dmm.leasesSeen = wroteSeen(dmm.leasesPath, req.leases)
 	return nil
 }
+
+func (dmm *DNSMasqMgr) Store() error {
+	dmm.lock.Lock()
+	defer dmm.lock.Unlock()
+	return dmm.store()
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2019 Francesco Romani - fromani/gmail
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to the following
+ * conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies
+ * or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/mojaves/dnsmasqmgr/pkg/dnsmasqmgr"
+)
+
+var (
+	ErrRevisionGone error = errors.New("Revision no longer available")
+	ErrWatcherLag   error = errors.New("Watcher lagging behind")
+)
+
+const (
+	// eventHistory is how many past events are kept to let watchers resume
+	eventHistory int = 1024
+	// watcherBacklog is how many events a watcher can lag behind before being dropped
+	watcherBacklog int = 64
+)
+
+// eventHub assigns revisions to the changes and fans them out to the watchers
+type eventHub struct {
+	lock     sync.Mutex
+	revision int64
+	history  []*pb.Event
+	watchers map[chan *pb.Event]bool
+}
+
+// newEventHub creates an eventHub whose next event will have revision rev+1
+func newEventHub(rev int64) *eventHub {
+	return &eventHub{
+		revision: rev,
+		watchers: make(map[chan *pb.Event]bool),
+	}
+}
+
+func (h *eventHub) Revision() int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.revision
+}
+
+func (h *eventHub) publish(action pb.Action, addr, prev *pb.Address) *pb.Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.revision++
+	ev := &pb.Event{
+		Revision:  h.revision,
+		Action:    action,
+		Addr:      addr,
+		Previous:  prev,
+		Timestamp: time.Now().Unix(),
+	}
+	h.history = append(h.history, ev)
+	if len(h.history) > eventHistory {
+		h.history = h.history[len(h.history)-eventHistory:]
+	}
+
+	for ch := range h.watchers {
+		select {
+		case ch <- ev:
+		default:
+			// too slow: drop it, it will need to resume
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// subscribe registers a new watcher, and returns the past events after the given revision
+func (h *eventHub) subscribe(from int64) (chan *pb.Event, []*pb.Event, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var backlog []*pb.Event
+	if from > 0 {
+		oldest := h.revision + 1
+		if len(h.history) > 0 {
+			oldest = h.history[0].Revision
+		}
+		if from < oldest-1 {
+			return nil, nil, fmt.Errorf("%w: %d, oldest is %d", ErrRevisionGone, from, oldest)
+		}
+		for _, ev := range h.history {
+			if ev.Revision > from {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	ch := make(chan *pb.Event, watcherBacklog)
+	h.watchers[ch] = true
+	return ch, backlog, nil
+}
+
+func (h *eventHub) unsubscribe(ch chan *pb.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.watchers[ch] {
+		delete(h.watchers, ch)
+		close(ch)
+	}
+}
+
+func (dmm *DNSMasqMgr) Watch(req *pb.WatchRequest, stream pb.DNSMasqManager_WatchServer) error {
+	if req == nil {
+		return ErrRequestData
+	}
+	ch, backlog, err := dmm.events.subscribe(req.FromRevision)
+	if err != nil {
+		return err
+	}
+	defer dmm.events.unsubscribe(ch)
+
+	last := req.FromRevision
+	for _, ev := range backlog {
+		err = stream.Send(ev)
+		if err != nil {
+			return err
+		}
+		last = ev.Revision
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("%w: resume from revision %d", ErrWatcherLag, last)
+			}
+			if ev.Revision <= last {
+				continue
+			}
+			err = stream.Send(ev)
+			if err != nil {
+				return err
+			}
+			last = ev.Revision
+		}
+	}
+}